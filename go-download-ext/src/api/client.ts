@@ -0,0 +1,53 @@
+import {SERVER_BASE, STORAGE} from "../types/constants"
+
+// 本地服务的令牌，通过配对获得，保存在 storage.local 中
+export function getToken(): Promise<string> {
+    return new Promise(resolve =>
+        chrome.storage.local.get({[STORAGE.TOKEN]: ''}, result => {
+            resolve(result[STORAGE.TOKEN] as string)
+        })
+    )
+}
+
+function setToken(token: string): Promise<void> {
+    return new Promise(resolve =>
+        chrome.storage.local.set({[STORAGE.TOKEN]: token}, () => resolve())
+    )
+}
+
+/**
+ * 带令牌请求本地服务，path 相对于 SERVER_BASE
+ */
+export async function apiFetch(path: string, init: RequestInit = {}): Promise<Response> {
+    const token = await getToken()
+    const headers = new Headers(init.headers)
+    if (token) headers.set('Authorization', `Bearer ${token}`)
+    return fetch(`${SERVER_BASE}${path}`, {...init, headers})
+}
+
+/**
+ * EventSource 不能设置请求头，令牌放在查询参数中
+ */
+export async function sseUrl(path: string): Promise<string> {
+    const token = await getToken()
+    return `${SERVER_BASE}${path}?token=${encodeURIComponent(token)}`
+}
+
+/**
+ * 与本地服务配对：服务端在桌面上展示配对码，用户输入后换取令牌
+ */
+export async function pair(askCode: () => string | null): Promise<void> {
+    const req = await fetch(`${SERVER_BASE}/pair/request`, {method: 'POST'}).then(r => r.json())
+    if (req.code !== 0) throw new Error(req.message)
+
+    const code = askCode()
+    if (!code) throw new Error('已取消')
+
+    const res = await fetch(`${SERVER_BASE}/pair/confirm`, {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({code: code.trim()})
+    }).then(r => r.json())
+    if (res.code !== 0) throw new Error(res.message)
+    await setToken(res.data.token)
+}
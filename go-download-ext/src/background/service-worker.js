@@ -1,5 +1,6 @@
-import {MSG, PROGRESS_CLEANUP_DELAY_MS, SERVER_BASE, STORAGE} from "../types/constants"
+import {MSG, PROGRESS_CLEANUP_DELAY_MS, STORAGE} from "../types/constants"
 import {syncAddHistoryItem} from "../mutex/history"
+import {apiFetch, sseUrl} from "../api/client"
 
 const sseMap = new Map() // id -> EventSource
 const lastForward = new Map() // id -> {time, percent}
@@ -33,42 +34,38 @@ chrome.runtime.onInstalled.addListener(() => {
 chrome.contextMenus.onClicked.addListener((info, tab) => {
     if (info.menuItemId !== 'download-link' || !info.linkUrl) return
 
-    chrome.storage.sync.get({downloadPath: '', proxyUrl: ''}, prefs => {
-        const {downloadPath, proxyUrl} = prefs
-        const body = {url: info.linkUrl}
-        if (downloadPath) body.downloadPath = downloadPath.trim()
-        if (proxyUrl) body.proxyUrl = proxyUrl
-
-        fetch(`${SERVER_BASE}/download`, {
-            method: 'POST',
-            headers: {'Content-Type': 'application/json'},
-            body: JSON.stringify(body)
-        })
-            .then(r => r.json())
-            .then(res => {
-                const {id, size} = res.data || {}
-                if (res.code === 0 && id) {
-                    // 写入 history（唯一负责写 history 的位置）
-                    addHistory({id, url: info.linkUrl, ts: Date.now(), status: 'pending', size: size})
-
-                    openProgressSSE(id)
-                } else {
-                    console.error('download API error:', res.code, res.message)
-                }
-            })
-            .catch(err => {
-                console.error('cannot reach local service:', err)
-            })
+    // 下载目录和代理由本地服务的配置文件决定
+    const body = {url: info.linkUrl}
+    apiFetch('/download', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(body)
     })
+        .then(r => r.json())
+        .then(res => {
+            const {id, size} = res.data || {}
+            if (res.code === 0 && id) {
+                // 写入 history（唯一负责写 history 的位置）
+                addHistory({id, url: info.linkUrl, ts: Date.now(), status: 'pending', size: size})
+
+                openProgressSSE(id)
+            } else {
+                console.error('download API error:', res.code, res.message)
+            }
+        })
+        .catch(err => {
+            console.error('cannot reach local service:', err)
+        })
 })
 
 // Start SSE for a task id (idempotent)
-function openProgressSSE(id) {
+async function openProgressSSE(id) {
     if (!id) return
     if (sseMap.has(id)) return
+    sseMap.set(id, null) // 占位，避免读取令牌期间重复打开
 
     try {
-        const url = `${SERVER_BASE}/progress/${id}`
+        const url = await sseUrl(`/progress/${id}`)
         const es = new EventSource(url)
         sseMap.set(id, es)
 
@@ -135,6 +132,7 @@ function openProgressSSE(id) {
             }, 3000)
         }
     } catch (err) {
+        sseMap.delete(id)
         console.error('openProgressSSE failed', err)
     }
 }
@@ -17,6 +17,7 @@ export const STORAGE = {
     DOWNLOADED_PREFIX: 'downloaded_', // 用：STORAGE.DOWNLOADED_PREFIX + id
     TOTAL_PREFIX: 'total_', // 用：STORAGE.TOTAL_PREFIX + id
     SPEED_PREFIX: 'speed_', // 用：STORAGE.SPEED_PREFIX + id
+    TOKEN: 'token', // 配对后获得的本地服务令牌
 } as const
 
 // app level 配置
@@ -0,0 +1,495 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/goccy/go-json"
+	"go-download/internal/core/config"
+	"go-download/internal/core/service"
+	"go-download/internal/core/sse"
+	"go-download/internal/core/types"
+	"golang.org/x/term"
+)
+
+// 退出码，便于脚本判断
+const (
+	exitOK    = 0
+	exitError = 1 // 请求失败，或任务失败、被取消
+	exitUsage = 2
+)
+
+// 断线后重新订阅进度的间隔
+var reconnectInterval = time.Second
+
+// Commands 是命令行客户端支持的子命令
+var Commands = map[string]bool{
+	"add": true, "list": true, "status": true, "pause": true, "resume": true, "cancel": true,
+	"tui": true, "import-curl": true,
+}
+
+const usage = `Usage: go-download <command> [options]
+
+Commands:
+  serve                    run the daemon without the system tray
+  add <url> [mirrors...]   start a download and print its id
+      --dir <path>         download directory
+      --procs <n>          connections per URL
+      --header <k: v>      extra request header, can be repeated
+      --sha256 <hex>       verify the file after download
+      --auto-verify        verify with SHA256SUMS or <file>.sha256 found next to the file
+      --signature <url>    verify a detached signature with the trusted keys, "auto"
+                           tries <file>.minisig, .asc and .sig
+      --extract            extract zip, tar and rar archives (not 7z) into a sibling directory
+      --delete-archive     with --extract, delete the archive once it is extracted
+      --user <u:p>         credentials, sent after a Basic or Digest challenge
+      --bearer <token>     send the token as a Bearer credential
+      --follow             wait for the download and show its progress
+  import-curl ['<curl command>'] [--dir <path>] [--procs <n>] [--follow]
+                           start a download from a "Copy as cURL" command,
+                           read from stdin when not given
+  list [--json]            list all tasks
+  status <id> [--follow] [--json]
+  pause <id>
+  resume <id>
+  cancel <id>              cancel a task and delete its partial files
+  tui                      full-screen view to monitor and manage all tasks
+
+Common options:
+  --server <url>           daemon address (default: from config.yaml)
+  --token <token>          api token (default: the token file next to config.yaml)
+  --config <path>          path of config.yaml
+`
+
+// app 是一次命令执行的上下文
+type app struct {
+	ctx    context.Context
+	client *Client
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// Run 执行一个子命令并返回退出码
+func Run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || !Commands[args[0]] {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cmd, args := args[0], args[1:]
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	server := fs.String("server", "", "")
+	token := fs.String("token", "", "")
+	configPath := fs.String("config", "", "")
+
+	var (
+		dir      = fs.String("dir", "", "")
+		procs    = fs.Int("procs", 0, "")
+		sha256   = fs.String("sha256", "", "")
+		follow   = fs.Bool("follow", false, "")
+		asJSON   = fs.Bool("json", false, "")
+		user     = fs.String("user", "", "")
+		bearer   = fs.String("bearer", "", "")
+		verify   = fs.Bool("auto-verify", false, "")
+		sig      = fs.String("signature", "", "")
+		unpack   = fs.Bool("extract", false, "")
+		del      = fs.Bool("delete-archive", false, "")
+		headers  headerFlag
+		operands []string
+	)
+	fs.Var(&headers, "header", "")
+
+	// 允许选项出现在参数之后，例如 add <url> --dir /data
+	for {
+		if err := fs.Parse(args); err != nil {
+			return exitUsage
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		operands = append(operands, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	// 相对路径按当前目录解析，否则守护进程会按它自己的工作目录解析
+	if *dir != "" {
+		abs, err := filepath.Abs(*dir)
+		if err != nil {
+			fmt.Fprintln(stderr, "error:", err)
+			return exitError
+		}
+		*dir = abs
+	}
+
+	base, tok, err := resolveServer(*server, *token, *configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return exitError
+	}
+	a := &app{ctx: ctx, client: NewClient(base, tok), stdout: stdout, stderr: stderr}
+
+	switch cmd {
+	case "add":
+		if len(operands) == 0 {
+			fmt.Fprint(stderr, usage)
+			return exitUsage
+		}
+		req := types.Request{
+			URL:          operands[0],
+			Mirrors:      operands[1:],
+			DownloadPath: *dir,
+			Procs:        *procs,
+			Headers:      headers.values,
+		}
+		if *sha256 != "" {
+			req.Checksum = "sha256:" + *sha256
+		}
+		if *verify {
+			req.AutoVerify = verify
+		}
+		if *unpack {
+			req.Extract = &types.Extract{Enabled: true, DeleteArchive: *del}
+		}
+		switch *sig {
+		case "":
+		case "auto":
+			req.Signature = &types.Signature{}
+		default:
+			req.Signature = &types.Signature{URL: *sig}
+		}
+		if *user != "" && *bearer != "" {
+			fmt.Fprintln(stderr, "error: --user and --bearer cannot be used together")
+			return exitUsage
+		}
+		if *user != "" {
+			username, password, _ := strings.Cut(*user, ":")
+			req.Auth = &types.Auth{Username: username, Password: password}
+		}
+		if *bearer != "" {
+			req.Auth = &types.Auth{Token: *bearer}
+		}
+		return a.add("/download", req, *follow)
+	case "import-curl":
+		if len(operands) > 1 {
+			fmt.Fprint(stderr, usage)
+			return exitUsage
+		}
+		imp := types.CurlImport{Request: types.Request{DownloadPath: *dir, Procs: *procs}}
+		if len(operands) == 1 {
+			imp.Command = operands[0]
+		} else {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return a.fail(err)
+			}
+			imp.Command = string(data)
+		}
+		return a.add("/import/curl", imp, *follow)
+	case "list":
+		return a.list(*asJSON)
+	case "tui":
+		return a.tui()
+	}
+
+	if len(operands) != 1 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	id := operands[0]
+	switch cmd {
+	case "status":
+		return a.status(id, *follow, *asJSON)
+	case "pause":
+		return a.action(http.MethodPost, "/tasks/"+id+"/pause")
+	case "resume":
+		return a.action(http.MethodPost, "/tasks/"+id+"/resume")
+	default: // cancel
+		return a.action(http.MethodDelete, "/tasks/"+id)
+	}
+}
+
+// resolveServer 确定守护进程地址和令牌，未指定时从配置目录读取
+func resolveServer(server, token, configPath string) (string, string, error) {
+	if configPath == "" {
+		p, err := config.DefaultPath()
+		if err != nil {
+			return "", "", err
+		}
+		configPath = p
+	}
+	if server == "" {
+		cfg, err := config.Read(configPath)
+		if err != nil {
+			return "", "", err
+		}
+		host, port, err := net.SplitHostPort(cfg.Listen)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid listen address %q in %s", cfg.Listen, configPath)
+		}
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			host = "127.0.0.1"
+		}
+		server = "http://" + net.JoinHostPort(host, port)
+	}
+	if token == "" {
+		token = os.Getenv("GO_DOWNLOAD_TOKEN")
+	}
+	if token == "" {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(configPath), "token"))
+		if err != nil && !os.IsNotExist(err) {
+			return "", "", err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	return strings.TrimRight(server, "/") + "/gd", token, nil
+}
+
+func (a *app) fail(err error) int {
+	fmt.Fprintln(a.stderr, "error:", err)
+	return exitError
+}
+
+// add 提交创建任务的请求，打印任务 id
+func (a *app) add(path string, body interface{}, follow bool) int {
+	var res struct {
+		ID    string `json:"id"`
+		Size  int64  `json:"size"`
+		State string `json:"state"`
+	}
+	if err := a.client.do(a.ctx, http.MethodPost, path, body, &res); err != nil {
+		return a.fail(err)
+	}
+	// 标准输出只打印任务 id，方便脚本捕获
+	fmt.Fprintln(a.stdout, res.ID)
+	if !follow {
+		return exitOK
+	}
+	return a.follow(res.ID)
+}
+
+func (a *app) list(asJSON bool) int {
+	var tasks []service.Task
+	if err := a.client.do(a.ctx, http.MethodGet, "/tasks", nil, &tasks); err != nil {
+		return a.fail(err)
+	}
+	if asJSON {
+		return a.printJSON(tasks)
+	}
+	w := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tPROGRESS\tSPEED\tURL")
+	for _, t := range tasks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", t.ID, t.State, percent(t.Downloaded, t.Total), speed(t.Speed), t.URL)
+	}
+	w.Flush()
+	return exitOK
+}
+
+func (a *app) status(id string, follow, asJSON bool) int {
+	var task service.Task
+	if err := a.client.do(a.ctx, http.MethodGet, "/tasks/"+id, nil, &task); err != nil {
+		return a.fail(err)
+	}
+	if follow {
+		return a.follow(id)
+	}
+	if asJSON {
+		return a.printJSON(task)
+	}
+	w := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%s\n", task.ID)
+	fmt.Fprintf(w, "URL:\t%s\n", task.URL)
+	fmt.Fprintf(w, "State:\t%s\n", task.State)
+	if task.Error != "" {
+		fmt.Fprintf(w, "Error:\t%s\n", task.Error)
+	}
+	fmt.Fprintf(w, "Progress:\t%s (%s / %s)\n", percent(task.Downloaded, task.Total), bytesize(task.Downloaded), bytesize(task.Total))
+	if task.State == service.StateRunning {
+		fmt.Fprintf(w, "Speed:\t%s, ETA %s\n", speed(task.Speed), eta(task.ETA))
+	}
+	if task.Path != "" {
+		fmt.Fprintf(w, "Path:\t%s\n", task.Path)
+	}
+	w.Flush()
+	return exitCode(task.State)
+}
+
+func (a *app) action(method, path string) int {
+	var task service.Task
+	if err := a.client.do(a.ctx, method, path, nil, &task); err != nil {
+		return a.fail(err)
+	}
+	fmt.Fprintf(a.stdout, "%s %s\n", task.ID, task.State)
+	return exitOK
+}
+
+// follow 渲染任务进度直到任务结束，断线时重新订阅
+func (a *app) follow(id string) int {
+	r := newRenderer(a.stderr)
+	for {
+		var last sse.Progress
+		err := a.client.stream(a.ctx, id, func(p sse.Progress) {
+			last = p
+			r.render(p)
+		})
+		if a.ctx.Err() != nil {
+			r.done()
+			return exitError
+		}
+		state := service.TaskState(last.State)
+		if (&service.Task{State: state}).Terminal() {
+			r.done()
+			if last.Error != "" {
+				fmt.Fprintln(a.stderr, "error:", last.Error)
+			}
+			if state == service.StateCompleted {
+				fmt.Fprintln(a.stderr, "saved to", last.Path)
+			}
+			return exitCode(state)
+		}
+		// 连接断开但任务未结束，确认任务仍然存在后重新订阅
+		var task service.Task
+		if err2 := a.client.do(a.ctx, http.MethodGet, "/tasks/"+id, nil, &task); err2 != nil {
+			r.done()
+			if err == nil {
+				err = err2
+			}
+			return a.fail(err)
+		}
+		if task.Terminal() {
+			r.done()
+			if task.Error != "" {
+				fmt.Fprintln(a.stderr, "error:", task.Error)
+			}
+			return exitCode(task.State)
+		}
+		select {
+		case <-a.ctx.Done():
+			r.done()
+			return exitError
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+func (a *app) printJSON(v interface{}) int {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return a.fail(err)
+	}
+	fmt.Fprintln(a.stdout, string(data))
+	return exitOK
+}
+
+func exitCode(state service.TaskState) int {
+	if state == service.StateFailed || state == service.StateCanceled {
+		return exitError
+	}
+	return exitOK
+}
+
+// headerFlag 收集可以重复的 --header "Name: value"
+type headerFlag struct {
+	values map[string]string
+}
+
+func (h *headerFlag) String() string { return "" }
+
+func (h *headerFlag) Set(v string) error {
+	name, value, ok := strings.Cut(v, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return errors.New(`want "Name: value"`)
+	}
+	if h.values == nil {
+		h.values = make(map[string]string)
+	}
+	h.values[name] = strings.TrimSpace(value)
+	return nil
+}
+
+// renderer 在终端上原地刷新进度，输出不是终端时每隔几秒打印一行
+type renderer struct {
+	w     io.Writer
+	tty   bool
+	last  time.Time
+	state string
+	drawn bool
+}
+
+func newRenderer(w io.Writer) *renderer {
+	f, ok := w.(*os.File)
+	return &renderer{w: w, tty: ok && term.IsTerminal(int(f.Fd()))}
+}
+
+func (r *renderer) render(p sse.Progress) {
+	line := formatProgress(p)
+	if r.tty {
+		fmt.Fprintf(r.w, "\r\033[K%s", line)
+		r.drawn = true
+		return
+	}
+	if p.State != r.state || time.Since(r.last) >= 2*time.Second {
+		fmt.Fprintln(r.w, line)
+		r.last, r.state = time.Now(), p.State
+	}
+}
+
+func (r *renderer) done() {
+	if r.drawn {
+		fmt.Fprintln(r.w)
+		r.drawn = false
+	}
+}
+
+func formatProgress(p sse.Progress) string {
+	s := fmt.Sprintf("%-9s %6s  %s / %s", p.State, percent(p.Downloaded, p.Total), bytesize(p.Downloaded), bytesize(p.Total))
+	if p.State == string(service.StateRunning) {
+		s += fmt.Sprintf("  %s  %d conns  ETA %s", speed(p.Speed), p.Connections, eta(p.ETA))
+	}
+	return s
+}
+
+func percent(done, total int64) string {
+	if total <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(done)*100/float64(total))
+}
+
+func bytesize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func speed(n int64) string {
+	return bytesize(n) + "/s"
+}
+
+func eta(seconds int64) string {
+	if seconds < 0 {
+		return "unknown"
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-download/internal/core/types"
+)
+
+// fakeDaemon 模拟守护进程的 API，final 为 SSE 最后推送的状态
+func fakeDaemon(t *testing.T, final string) (*httptest.Server, *types.Request) {
+	var got types.Request
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, data string) {
+		fmt.Fprintf(w, `{"code":0,"message":"ok","data":%s}`, data)
+	}
+	mux.HandleFunc("/gd/download", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		ok(w, `{"id":"t1","size":100,"state":"running"}`)
+	})
+	mux.HandleFunc("/gd/import/curl", func(w http.ResponseWriter, r *http.Request) {
+		var imp types.CurlImport
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&imp))
+		got = imp.Request
+		got.URL = imp.Command
+		ok(w, `{"id":"t1","size":100,"state":"running"}`)
+	})
+	mux.HandleFunc("/gd/tasks", func(w http.ResponseWriter, r *http.Request) {
+		ok(w, `[{"id":"t1","url":"http://example.com/a.iso","state":"running","total":200,"downloaded":50,"speed":2048}]`)
+	})
+	mux.HandleFunc("/gd/tasks/t1", func(w http.ResponseWriter, r *http.Request) {
+		ok(w, fmt.Sprintf(`{"id":"t1","state":%q,"total":100,"downloaded":100}`, final))
+	})
+	mux.HandleFunc("/gd/tasks/t1/pause", func(w http.ResponseWriter, r *http.Request) {
+		ok(w, `{"id":"t1","state":"paused"}`)
+	})
+	mux.HandleFunc("/gd/progress/t1", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "data: {\"downloaded\":50,\"total\":100,\"speed\":10,\"state\":\"running\"}\n\n")
+		fmt.Fprintf(w, "data: {\"downloaded\":100,\"total\":100,\"state\":%q,\"error\":\"boom\",\"path\":\"/tmp/a.iso\"}\n\n", final)
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"code":-1,"message":"missing or invalid token","data":{}}`)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &got
+}
+
+func run(t *testing.T, ts *httptest.Server, args ...string) (int, string, string) {
+	var stdout, stderr strings.Builder
+	args = append(args, "--server", ts.URL, "--token", "secret", "--config", filepath.Join(t.TempDir(), "config.yaml"))
+	code := Run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestAddFollow(t *testing.T) {
+	ts, got := fakeDaemon(t, "completed")
+	code, stdout, stderr := run(t, ts, "add", "http://example.com/a.iso", "http://mirror.example.com/a.iso",
+		"--dir", "/data", "--procs", "8", "--header", "Cookie: a=b", "--sha256", "abcd", "--user", "bob:p:w", "--auto-verify", "--signature", "auto", "--extract", "--follow")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "t1\n", stdout)
+	assert.Contains(t, stderr, "saved to /tmp/a.iso")
+
+	verify := true
+	assert.Equal(t, types.Request{
+		URL:          "http://example.com/a.iso",
+		Mirrors:      []string{"http://mirror.example.com/a.iso"},
+		DownloadPath: "/data",
+		Procs:        8,
+		Headers:      map[string]string{"Cookie": "a=b"},
+		Checksum:     "sha256:abcd",
+		Auth:         &types.Auth{Username: "bob", Password: "p:w"},
+		AutoVerify:   &verify,
+		Signature:    &types.Signature{},
+		Extract:      &types.Extract{Enabled: true},
+	}, *got)
+}
+
+func TestImportCurl(t *testing.T) {
+	ts, got := fakeDaemon(t, "completed")
+	cmd := `curl 'http://example.com/a.iso' -H 'Cookie: a=b'`
+	code, stdout, stderr := run(t, ts, "import-curl", cmd, "--dir", "/data")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "t1\n", stdout)
+	assert.Equal(t, types.Request{URL: cmd, DownloadPath: "/data"}, *got)
+
+	code, _, _ = run(t, ts, "import-curl", "a", "b")
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestRelativeDir(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	ts, got := fakeDaemon(t, "completed")
+	code, _, stderr := run(t, ts, "add", "http://example.com/a.iso", "--dir", "downloads")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, filepath.Join(wd, "downloads"), got.DownloadPath)
+
+	code, _, stderr = run(t, ts, "import-curl", "curl http://example.com/a.iso", "--dir", ".")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, wd, got.DownloadPath)
+}
+
+func TestFollowFailure(t *testing.T) {
+	ts, _ := fakeDaemon(t, "failed")
+	code, _, stderr := run(t, ts, "status", "t1", "--follow")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "error: boom")
+
+	code, _, _ = run(t, ts, "status", "t1")
+	assert.Equal(t, exitError, code, "status of a failed task must exit non-zero")
+}
+
+func TestListAndActions(t *testing.T) {
+	ts, _ := fakeDaemon(t, "completed")
+	code, stdout, _ := run(t, ts, "list")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "t1")
+	assert.Contains(t, stdout, "25.0%")
+	assert.Contains(t, stdout, "2.0 KiB/s")
+
+	code, stdout, _ = run(t, ts, "pause", "t1")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "t1 paused\n", stdout)
+
+	code, _, stderr := run(t, ts, "resume", "missing")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "error:")
+}
+
+func TestUsageAndAuth(t *testing.T) {
+	assert.Equal(t, exitUsage, Run(nil, io.Discard, io.Discard))
+	assert.Equal(t, exitUsage, Run([]string{"pause"}, io.Discard, io.Discard))
+
+	ts, _ := fakeDaemon(t, "completed")
+	var stderr strings.Builder
+	code := Run([]string{"list", "--server", ts.URL, "--token", "wrong"}, io.Discard, &stderr)
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr.String(), "missing or invalid token")
+}
@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"go-download/internal/core/sse"
+)
+
+// Client 通过 HTTP API 控制正在运行的守护进程
+type Client struct {
+	base  string // 形如 http://127.0.0.1:11235/gd
+	token string
+	http  *http.Client
+}
+
+func NewClient(base, token string) *Client {
+	return &Client{
+		base:  strings.TrimRight(base, "/"),
+		token: token,
+		http:  &http.Client{},
+	}
+}
+
+// response 与服务端的 r.Resp 对应
+type response struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// do 发送请求并把 data 解码到 out，业务错误转换为 error
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.authorize(req)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach go-download daemon: %w", err)
+	}
+	defer res.Body.Close()
+
+	var resp response
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return fmt.Errorf("%s %s: unexpected response %s", method, path, res.Status)
+	}
+	if resp.Code != 0 {
+		return fmt.Errorf("%s", resp.Message)
+	}
+	if out != nil {
+		return json.Unmarshal(resp.Data, out)
+	}
+	return nil
+}
+
+func (c *Client) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+}
+
+// stream 订阅任务的进度事件，直到服务端关闭连接或 ctx 结束
+func (c *Client) stream(ctx context.Context, id string, fn func(p sse.Progress)) error {
+	return c.subscribe(ctx, "/progress/"+id, fn)
+}
+
+// events 订阅所有任务的进度事件
+func (c *Client) events(ctx context.Context, fn func(p sse.Progress)) error {
+	return c.subscribe(ctx, "/events", fn)
+}
+
+func (c *Client) subscribe(ctx context.Context, path string, fn func(p sse.Progress)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	c.authorize(req)
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach go-download daemon: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("subscribe progress: %s", res.Status)
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var p sse.Progress
+		if err := json.Unmarshal([]byte(data), &p); err != nil {
+			return fmt.Errorf("decode progress: %w", err)
+		}
+		fn(p)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
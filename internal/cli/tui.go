@@ -0,0 +1,587 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go-download/internal/core/service"
+	"go-download/internal/core/sse"
+	"go-download/internal/core/types"
+	"golang.org/x/term"
+)
+
+// 终端界面的刷新间隔，以及重新拉取任务列表（例如优先级变化）的间隔
+var (
+	redrawInterval  = 100 * time.Millisecond
+	refreshInterval = 5 * time.Second
+)
+
+const (
+	keyUp        = "up"
+	keyDown      = "down"
+	keyEnter     = "enter"
+	keyEsc       = "esc"
+	keyBackspace = "backspace"
+	keyCtrlC     = "ctrl-c"
+)
+
+const helpLine = "↑/↓ select  enter details  a add  p pause  r resume  x cancel  +/- priority  q quit"
+
+type view int
+
+const (
+	viewList view = iota
+	viewDetail
+)
+
+// action 是按键触发的一次 API 调用
+type action struct {
+	method string
+	path   string
+	body   interface{}
+	desc   string // 成功后显示在状态栏
+}
+
+// model 是终端界面的全部状态，只在主循环中读写
+type model struct {
+	tasks    []service.Task
+	selected int
+	view     view
+	adding   bool   // 正在输入新下载的 URL
+	input    []rune // 已输入的 URL
+	confirm  string // 等待确认取消的任务 id
+	status   string
+	stale    bool // 需要重新拉取任务列表
+	width    int
+	height   int
+}
+
+// tui 以全屏终端界面显示所有任务，进度来自守护进程的 /events 事件流
+func (a *app) tui() int {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return a.fail(errors.New("tui needs an interactive terminal"))
+	}
+	// 进入全屏之前先确认能连上守护进程，出错时错误信息不会被清掉
+	var tasks []service.Task
+	if err := a.client.do(a.ctx, http.MethodGet, "/tasks", nil, &tasks); err != nil {
+		return a.fail(err)
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return a.fail(err)
+	}
+	defer term.Restore(fd, state)
+	// 使用备用屏幕并隐藏光标，退出时恢复
+	fmt.Fprint(a.stdout, "\033[?1049h\033[?25l")
+	defer fmt.Fprint(a.stdout, "\033[?25h\033[?1049l")
+
+	ctx, cancel := context.WithCancel(a.ctx)
+	defer cancel()
+	updates := make(chan func(m *model), 64)
+	send := func(fn func(m *model)) {
+		select {
+		case updates <- fn:
+		case <-ctx.Done():
+		}
+	}
+	keys := make(chan string, 64)
+	go readKeys(os.Stdin, keys)
+	go a.watchEvents(ctx, send)
+
+	refresh := func() {
+		go func() {
+			var tasks []service.Task
+			err := a.client.do(ctx, http.MethodGet, "/tasks", nil, &tasks)
+			send(func(m *model) {
+				if err != nil {
+					m.status = "error: " + err.Error()
+					return
+				}
+				m.setTasks(tasks)
+			})
+		}()
+	}
+
+	m := &model{}
+	m.setTasks(tasks)
+	redraw := time.NewTicker(redrawInterval)
+	defer redraw.Stop()
+	reload := time.NewTicker(refreshInterval)
+	defer reload.Stop()
+	dirty, changed := true, false
+	for {
+		if dirty {
+			m.width, m.height = terminalSize(fd)
+			draw(a.stdout, m.render())
+			dirty, changed = false, false
+		}
+		select {
+		case <-ctx.Done():
+			return exitOK
+		case k, ok := <-keys:
+			if !ok {
+				return exitOK
+			}
+			act, quit := m.handleKey(k)
+			if quit {
+				return exitOK
+			}
+			if act != nil {
+				a.perform(ctx, *act, send)
+			}
+			dirty = true
+		case fn := <-updates:
+			fn(m)
+			if m.stale {
+				m.stale = false
+				refresh()
+			}
+			// 进度事件很频繁，按 redrawInterval 合并重绘
+			changed = true
+		case <-redraw.C:
+			dirty = changed
+		case <-reload.C:
+			refresh()
+		}
+	}
+}
+
+// watchEvents 订阅所有任务的进度事件，断线后重新订阅
+func (a *app) watchEvents(ctx context.Context, send func(fn func(m *model))) {
+	for {
+		err := a.client.events(ctx, func(p sse.Progress) {
+			send(func(m *model) {
+				if !m.apply(p) {
+					m.stale = true
+				}
+			})
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		send(func(m *model) {
+			m.status = "event stream disconnected, reconnecting"
+			if err != nil {
+				m.status += ": " + err.Error()
+			}
+			m.stale = true
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+// perform 在后台执行 API 调用，结果显示在状态栏
+func (a *app) perform(ctx context.Context, act action, send func(fn func(m *model))) {
+	go func() {
+		err := a.client.do(ctx, act.method, act.path, act.body, nil)
+		send(func(m *model) {
+			if err != nil {
+				m.status = "error: " + err.Error()
+				return
+			}
+			m.status = act.desc
+			m.stale = true
+		})
+	}()
+}
+
+func terminalSize(fd int) (int, int) {
+	w, h, err := term.GetSize(fd)
+	if err != nil || w <= 0 || h <= 0 {
+		return 80, 24
+	}
+	return w, h
+}
+
+// draw 从左上角开始覆盖上一帧，避免整屏清除造成闪烁
+func draw(w io.Writer, lines []string) {
+	var b strings.Builder
+	b.WriteString("\033[H")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line)
+		b.WriteString("\033[K")
+	}
+	b.WriteString("\033[J")
+	io.WriteString(w, b.String())
+}
+
+// readKeys 把终端输入转换成按键，输入结束时关闭 keys
+func readKeys(r io.Reader, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		for _, k := range parseKeys(buf[:n]) {
+			keys <- k
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parseKeys 解析原始模式下读到的字节，方向键等转义序列转换为按键名
+func parseKeys(b []byte) []string {
+	var keys []string
+	for len(b) > 0 {
+		switch {
+		case bytes.HasPrefix(b, []byte("\x1b[A")), bytes.HasPrefix(b, []byte("\x1bOA")):
+			keys, b = append(keys, keyUp), b[3:]
+		case bytes.HasPrefix(b, []byte("\x1b[B")), bytes.HasPrefix(b, []byte("\x1bOB")):
+			keys, b = append(keys, keyDown), b[3:]
+		case len(b) > 1 && b[0] == 0x1b && b[1] == '[':
+			// 不支持的转义序列，跳到结束字节
+			i := 2
+			for i < len(b) && (b[i] < 0x40 || b[i] > 0x7e) {
+				i++
+			}
+			b = b[min(i+1, len(b)):]
+		case b[0] == 0x1b:
+			keys, b = append(keys, keyEsc), b[1:]
+		case b[0] == '\r' || b[0] == '\n':
+			keys, b = append(keys, keyEnter), b[1:]
+		case b[0] == 0x7f || b[0] == 0x08:
+			keys, b = append(keys, keyBackspace), b[1:]
+		case b[0] == 0x03:
+			keys, b = append(keys, keyCtrlC), b[1:]
+		case b[0] < 0x20:
+			b = b[1:]
+		default:
+			r, size := utf8.DecodeRune(b)
+			keys, b = append(keys, string(r)), b[size:]
+		}
+	}
+	return keys
+}
+
+// setTasks 替换任务列表，尽量保持选中同一个任务
+func (m *model) setTasks(tasks []service.Task) {
+	var id string
+	if t := m.current(); t != nil {
+		id = t.ID
+	}
+	m.tasks = tasks
+	for i := range m.tasks {
+		if m.tasks[i].ID == id {
+			m.selected = i
+			return
+		}
+	}
+	m.selected = min(m.selected, max(len(m.tasks)-1, 0))
+}
+
+// apply 把进度事件合并到任务，任务不在列表中时返回 false
+func (m *model) apply(p sse.Progress) bool {
+	for i := range m.tasks {
+		t := &m.tasks[i]
+		if t.ID != p.ID {
+			continue
+		}
+		t.State = service.TaskState(p.State)
+		t.Error = p.Error
+		if p.Path != "" {
+			t.Path = p.Path
+		}
+		t.Downloaded, t.Total = p.Downloaded, p.Total
+		t.Speed, t.ETA, t.Connections = p.Speed, p.ETA, p.Connections
+		t.Segments, t.MirrorStats = p.Segments, p.Mirrors
+		return true
+	}
+	return false
+}
+
+func (m *model) current() *service.Task {
+	if m.selected < 0 || m.selected >= len(m.tasks) {
+		return nil
+	}
+	return &m.tasks[m.selected]
+}
+
+// handleKey 处理一次按键，返回需要执行的 API 调用以及是否退出
+func (m *model) handleKey(k string) (*action, bool) {
+	if k == keyCtrlC {
+		return nil, true
+	}
+	if m.adding {
+		return m.handleInput(k), false
+	}
+	if id := m.confirm; id != "" {
+		m.confirm = ""
+		if k == "y" {
+			return &action{method: http.MethodDelete, path: "/tasks/" + id, desc: "canceled " + id}, false
+		}
+		m.status = ""
+		return nil, false
+	}
+
+	switch k {
+	case "q":
+		if m.view == viewDetail {
+			m.view = viewList
+			return nil, false
+		}
+		return nil, true
+	case keyEsc:
+		m.view = viewList
+		return nil, false
+	case keyUp, "k":
+		m.selected = max(m.selected-1, 0)
+		return nil, false
+	case keyDown, "j":
+		m.selected = min(m.selected+1, max(len(m.tasks)-1, 0))
+		return nil, false
+	case "a":
+		m.adding, m.input = true, nil
+		return nil, false
+	}
+
+	t := m.current()
+	if t == nil {
+		return nil, false
+	}
+	switch k {
+	case keyEnter:
+		m.view = viewDetail
+	case "p":
+		return &action{method: http.MethodPost, path: "/tasks/" + t.ID + "/pause", desc: "paused " + t.ID}, false
+	case "r":
+		return &action{method: http.MethodPost, path: "/tasks/" + t.ID + "/resume", desc: "resumed " + t.ID}, false
+	case "x":
+		m.confirm = t.ID
+		m.status = fmt.Sprintf("cancel %s and delete its partial files? (y/n)", t.ID)
+	case "+", "=", "-":
+		priority := t.Priority + 1
+		if k == "-" {
+			priority = t.Priority - 1
+		}
+		return &action{
+			method: http.MethodPatch,
+			path:   "/tasks/" + t.ID,
+			body:   types.TaskPatch{Priority: &priority},
+			desc:   fmt.Sprintf("priority of %s set to %d", t.ID, priority),
+		}, false
+	}
+	return nil, false
+}
+
+// handleInput 处理输入 URL 时的按键
+func (m *model) handleInput(k string) *action {
+	switch k {
+	case keyEnter:
+		u := strings.TrimSpace(string(m.input))
+		m.adding, m.input = false, nil
+		if u == "" {
+			return nil
+		}
+		return &action{method: http.MethodPost, path: "/download", body: types.Request{URL: u}, desc: "added " + u}
+	case keyEsc:
+		m.adding, m.input = false, nil
+	case keyBackspace:
+		if len(m.input) > 0 {
+			m.input = m.input[:len(m.input)-1]
+		}
+	default:
+		if r, size := utf8.DecodeRuneInString(k); size == len(k) && r >= ' ' {
+			m.input = append(m.input, r)
+		}
+	}
+	return nil
+}
+
+// render 生成一帧画面，每行不超过终端宽度
+func (m *model) render() []string {
+	// 最后两行是帮助和状态栏
+	body := max(m.height-2, 1)
+	var lines []string
+	highlight := -1
+	if m.view == viewDetail && m.current() != nil {
+		lines = m.renderDetail(m.current())
+	} else {
+		lines, highlight = m.renderList(body)
+	}
+
+	if len(lines) > body {
+		lines = lines[:body]
+	}
+	for len(lines) < body {
+		lines = append(lines, "")
+	}
+	status := m.status
+	if m.adding {
+		status = "URL: " + string(m.input) + "█"
+	}
+	lines = append(lines, "\033[2m"+fit(helpLine, m.width)+"\033[0m", fit(status, m.width))
+	for i := range lines[:body] {
+		lines[i] = fit(lines[i], m.width)
+	}
+	if highlight >= 0 && highlight < body {
+		// 选中的任务反色显示
+		lines[highlight] = "\033[7m" + lines[highlight] + "\033[0m"
+	}
+	return lines
+}
+
+// renderList 生成任务列表，任务太多时滚动到选中的任务，同时返回选中行的位置
+func (m *model) renderList(height int) ([]string, int) {
+	var speedSum int64
+	running := 0
+	for _, t := range m.tasks {
+		if t.State == service.StateRunning {
+			speedSum += t.Speed
+			running++
+		}
+	}
+	lines := []string{
+		fmt.Sprintf("go-download  %d tasks, %d running  ↓ %s", len(m.tasks), running, speed(speedSum)),
+	}
+	// 固定列之外的宽度分给进度条和文件名
+	barWidth := min(max((m.width-68)/2, 10), 40)
+	lines = append(lines, fmt.Sprintf("%-8s  %-9s %4s  %-*s %6s  %11s  %8s  %s",
+		"ID", "STATE", "PRI", barWidth, "PROGRESS", "", "SPEED", "ETA", "NAME"))
+	if len(m.tasks) == 0 {
+		return append(lines, "", "no tasks, press a to add a download"), -1
+	}
+	rows := max(height-len(lines), 1)
+	first := max(m.selected-rows+1, 0)
+	highlight := len(lines) + m.selected - first
+	for i := first; i < len(m.tasks) && i < first+rows; i++ {
+		t := &m.tasks[i]
+		rate, left := "", ""
+		if t.State == service.StateRunning {
+			rate, left = speed(t.Speed), eta(t.ETA)
+		}
+		lines = append(lines, fmt.Sprintf("%-8s  %-9s %4d  %s %6s  %11s  %8s  %s",
+			short(t.ID), t.State, t.Priority, segmentBar(t, barWidth), percent(t.Downloaded, t.Total), rate, left, taskName(t)))
+	}
+	return lines, highlight
+}
+
+func (m *model) renderDetail(t *service.Task) []string {
+	lines := []string{
+		"Task " + t.ID + "  (esc to go back)",
+		"",
+		"URL:       " + t.URL,
+		fmt.Sprintf("State:     %s", t.State),
+	}
+	if t.Error != "" {
+		lines = append(lines, "Error:     "+t.Error)
+	}
+	where := t.Path
+	if where == "" {
+		where = t.DownloadPath
+	}
+	lines = append(lines,
+		"Path:      "+where,
+		fmt.Sprintf("Priority:  %d    Rate limit: %s", t.Priority, rateLimit(t.RateLimit)),
+		fmt.Sprintf("Progress:  %s (%s / %s)  %s  %d conns  ETA %s",
+			percent(t.Downloaded, t.Total), bytesize(t.Downloaded), bytesize(t.Total), speed(t.Speed), t.Connections, eta(t.ETA)),
+		"           "+segmentBar(t, max(min(m.width-12, 100), 10)),
+		"",
+		fmt.Sprintf("%4s  %-27s %7s  %11s  %-8s  %s", "SEG", "RANGE", "DONE", "SPEED", "STATE", "MIRROR"),
+	)
+	for _, s := range t.Segments {
+		lines = append(lines, fmt.Sprintf("%4d  %-27s %7s  %11s  %-8s  %s",
+			s.ID, fmt.Sprintf("%d-%d", s.Low, s.High), percent(s.Downloaded, s.High-s.Low+1), speed(s.Speed), s.State, host(s.Mirror)))
+	}
+	if len(t.MirrorStats) > 0 {
+		lines = append(lines, "", fmt.Sprintf("%-30s %11s  %11s  %6s  %4s  %4s  %s", "MIRROR", "BYTES", "SPEED", "ACTIVE", "SEGS", "ERRS", "HEALTHY"))
+		for _, ms := range t.MirrorStats {
+			lines = append(lines, fmt.Sprintf("%-30s %11s  %11s  %6d  %4d  %4d  %t",
+				fit(host(ms.URL), 30), bytesize(ms.Bytes), speed(ms.Speed), ms.Active, ms.Segments, ms.Errors, ms.Healthy))
+		}
+	}
+	return lines
+}
+
+// segmentBar 按字节位置绘制进度条，每一格取所在分段的进度，因此能看出各个连接的进度
+func segmentBar(t *service.Task, width int) string {
+	var b strings.Builder
+	for i := 0; i < width; i++ {
+		if cellDone(t, i, width) {
+			b.WriteRune('█')
+		} else {
+			b.WriteRune('░')
+		}
+	}
+	return b.String()
+}
+
+func cellDone(t *service.Task, i, width int) bool {
+	if t.Total <= 0 {
+		return t.State == service.StateCompleted
+	}
+	// 取格子中点对应的字节
+	off := int64(2*i+1) * t.Total / int64(2*width)
+	if len(t.Segments) == 0 {
+		return off < t.Downloaded
+	}
+	for _, s := range t.Segments {
+		if off >= s.Low && off <= s.High {
+			return off < s.Low+s.Downloaded
+		}
+	}
+	return t.State == service.StateCompleted
+}
+
+// taskName 显示下载的文件名，还不知道时取 URL 的最后一段
+func taskName(t *service.Task) string {
+	if t.Path != "" {
+		return filepath.Base(t.Path)
+	}
+	if u, err := url.Parse(t.URL); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+		return path.Base(u.Path)
+	}
+	return t.URL
+}
+
+func host(raw string) string {
+	if u, err := url.Parse(raw); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return raw
+}
+
+func short(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func rateLimit(n int64) string {
+	if n == 0 {
+		return "unlimited"
+	}
+	return speed(n)
+}
+
+// fit 按字符数截断到 width
+func fit(s string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	r := []rune(s)
+	if width == 1 {
+		return string(r[:1])
+	}
+	return string(r[:width-1]) + "…"
+}
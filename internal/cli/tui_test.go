@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-download/internal/core/service"
+	"go-download/internal/core/sse"
+	"go-download/internal/core/types"
+	"go-download/internal/pget"
+)
+
+func TestParseKeys(t *testing.T) {
+	keys := parseKeys([]byte("j\x1b[A\x1b[Bx\r\x1b\x7f\x03\x1b[1;5C好"))
+	assert.Equal(t, []string{"j", keyUp, keyDown, "x", keyEnter, keyEsc, keyBackspace, keyCtrlC, "好"}, keys)
+}
+
+func TestSegmentBar(t *testing.T) {
+	// 两个分段：前一半下载完，后一半下载了四分之一
+	task := &service.Task{
+		State: service.StateRunning,
+		Total: 800,
+		Segments: []pget.SegmentProgress{
+			{ID: 0, Low: 0, High: 399, Downloaded: 400},
+			{ID: 1, Low: 400, High: 799, Downloaded: 100},
+		},
+	}
+	assert.Equal(t, "██████████░░░░░░", segmentBar(task, 16))
+
+	task.Segments = nil
+	task.Downloaded = 400
+	assert.Equal(t, "████░░░░", segmentBar(task, 8))
+
+	unknown := &service.Task{State: service.StateCompleted}
+	assert.Equal(t, "████", segmentBar(unknown, 4))
+}
+
+func TestModelKeys(t *testing.T) {
+	m := &model{width: 120, height: 20}
+	m.setTasks([]service.Task{
+		{ID: "aaaaaaaaaa", URL: "http://example.com/a.iso", State: service.StateRunning},
+		{ID: "bbbbbbbbbb", URL: "http://example.com/b.iso", State: service.StateQueued, Priority: 2},
+	})
+
+	act, quit := m.handleKey("j")
+	assert.Nil(t, act)
+	assert.False(t, quit)
+	assert.Equal(t, "bbbbbbbbbb", m.current().ID)
+
+	act, _ = m.handleKey("+")
+	require.NotNil(t, act)
+	assert.Equal(t, http.MethodPatch, act.method)
+	assert.Equal(t, "/tasks/bbbbbbbbbb", act.path)
+	assert.Equal(t, 3, *act.body.(types.TaskPatch).Priority)
+
+	act, _ = m.handleKey("p")
+	assert.Equal(t, "/tasks/bbbbbbbbbb/pause", act.path)
+
+	// 取消需要确认
+	act, _ = m.handleKey("x")
+	assert.Nil(t, act)
+	act, _ = m.handleKey("n")
+	assert.Nil(t, act)
+	m.handleKey("x")
+	act, _ = m.handleKey("y")
+	assert.Equal(t, http.MethodDelete, act.method)
+
+	// 输入 URL 时按键不触发操作
+	m.handleKey("a")
+	for _, k := range parseKeys([]byte("http://x/q\x7fp")) {
+		act, quit = m.handleKey(k)
+		assert.Nil(t, act)
+		assert.False(t, quit)
+	}
+	act, _ = m.handleKey(keyEnter)
+	require.NotNil(t, act)
+	assert.Equal(t, "/download", act.path)
+	assert.Equal(t, "http://x/p", act.body.(types.Request).URL)
+
+	_, quit = m.handleKey("q")
+	assert.True(t, quit)
+}
+
+func TestModelRender(t *testing.T) {
+	m := &model{width: 120, height: 20}
+	m.setTasks([]service.Task{
+		{ID: "aaaaaaaaaa", URL: "http://example.com/a.iso", State: service.StateRunning, Total: 100},
+	})
+
+	assert.True(t, m.apply(sse.Progress{
+		ID:    "aaaaaaaaaa",
+		State: "running",
+		Progress: pget.Progress{
+			Downloaded: 50, Total: 100, Speed: 2048, ETA: 3,
+			Segments: []pget.SegmentProgress{{ID: 0, Low: 0, High: 99, Downloaded: 50, State: pget.SegmentActive, Mirror: "http://mirror.example.com/a.iso"}},
+			Mirrors:  []pget.MirrorStat{{URL: "http://mirror.example.com/a.iso", Bytes: 50, Healthy: true}},
+		},
+	}))
+	assert.False(t, m.apply(sse.Progress{ID: "unknown"}))
+
+	lines := m.render()
+	require.Len(t, lines, 20)
+	screen := strings.Join(lines, "\n")
+	assert.Contains(t, screen, "1 tasks, 1 running")
+	assert.Contains(t, screen, "aaaaaaaa  running")
+	assert.Contains(t, screen, "50.0%")
+	assert.Contains(t, screen, "2.0 KiB/s")
+	assert.Contains(t, screen, "a.iso")
+
+	m.handleKey(keyEnter)
+	screen = strings.Join(m.render(), "\n")
+	assert.Contains(t, screen, "Task aaaaaaaaaa")
+	assert.Contains(t, screen, "0-99")
+	assert.Contains(t, screen, "mirror.example.com")
+	m.handleKey(keyEsc)
+	assert.Equal(t, viewList, m.view)
+}
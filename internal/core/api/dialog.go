@@ -0,0 +1,26 @@
+//go:build !headless
+
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sqweek/dialog"
+	"go-download/internal/core/util/r"
+	"net/http"
+)
+
+// ChooseDirHandler 处理选择下载目录请求
+func (a *API) ChooseDirHandler(c *gin.Context) {
+	path, err := dialog.Directory().Title("请选择下载目录").Browse()
+	if err != nil {
+		if errors.Is(err, dialog.ErrCancelled) {
+			r.Success(c, struct {
+			}{})
+			return
+		}
+		r.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.Success(c, gin.H{"path": path})
+}
@@ -0,0 +1,14 @@
+//go:build headless
+
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-download/internal/core/util/r"
+	"net/http"
+)
+
+// ChooseDirHandler 无界面的构建中没有目录选择对话框
+func (a *API) ChooseDirHandler(c *gin.Context) {
+	r.Error(c, http.StatusNotImplemented, "choosing a directory is not available in headless mode")
+}
@@ -3,24 +3,31 @@ package api
 import (
 	"errors"
 	"github.com/gin-gonic/gin"
-	"github.com/sqweek/dialog"
+	"go-download/internal/core/auth"
+	"go-download/internal/core/metrics"
 	"go-download/internal/core/service"
 	"go-download/internal/core/sse"
 	"go-download/internal/core/types"
 	"go-download/internal/core/util/r"
+	"log"
 	"net/http"
 )
 
+// 上传的 cookies.txt 的大小上限
+const maxCookiesSize = 4 << 20
+
 // API 把 handler 封装到结构体里，便于测试/依赖注入
 type API struct {
-	svc *service.DownloadService
-	hub *sse.Hub
+	svc   *service.DownloadService
+	hub   *sse.Hub
+	guard *auth.Guard
 }
 
-func NewAPI(svc *service.DownloadService, hub *sse.Hub) *API {
+func NewAPI(svc *service.DownloadService, hub *sse.Hub, guard *auth.Guard) *API {
 	return &API{
-		svc: svc,
-		hub: hub,
+		svc:   svc,
+		hub:   hub,
+		guard: guard,
 	}
 }
 
@@ -36,33 +43,313 @@ func (a *API) DownloadHandler(c *gin.Context) {
 	a.svc.DoDownload(c, req)
 }
 
+// ImportCurlHandler 从 curl 命令创建下载任务
+func (a *API) ImportCurlHandler(c *gin.Context) {
+	var imp types.CurlImport
+	if err := c.ShouldBindJSON(&imp); err != nil {
+		r.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	a.svc.ImportCurl(c, imp)
+}
+
+// MetalinkHandler 从 Metalink 文件或地址创建一组下载任务
+func (a *API) MetalinkHandler(c *gin.Context) {
+	var imp types.MetalinkImport
+	if err := c.ShouldBindJSON(&imp); err != nil {
+		r.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	res, code, err := a.svc.Metalink(imp)
+	if err != nil {
+		r.Error(c, code, err.Error())
+		return
+	}
+	r.Success(c, res)
+}
+
+// BatchHandler 批量创建相互独立的下载任务并归入同一个分组
+func (a *API) BatchHandler(c *gin.Context) {
+	var batch types.BatchRequest
+	if err := c.ShouldBindJSON(&batch); err != nil {
+		r.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	res, code, err := a.svc.Batch(batch)
+	if err != nil {
+		r.Error(c, code, err.Error())
+		return
+	}
+	r.Success(c, res)
+}
+
+// GroupsHandler 查询所有分组的汇总进度
+func (a *API) GroupsHandler(c *gin.Context) {
+	r.Success(c, a.svc.Groups())
+}
+
+// GroupHandler 查询分组的汇总进度和其中的任务
+func (a *API) GroupHandler(c *gin.Context) {
+	group, err := a.svc.Group(c.Param("id"))
+	if err != nil {
+		taskError(c, err)
+		return
+	}
+	r.Success(c, group)
+}
+
+// PauseGroupHandler 暂停分组中排队和运行中的任务
+func (a *API) PauseGroupHandler(c *gin.Context) {
+	group, err := a.svc.PauseGroup(c.Param("id"))
+	if err != nil {
+		taskError(c, err)
+		return
+	}
+	r.Success(c, group)
+}
+
+// ResumeGroupHandler 恢复分组中暂停和失败的任务
+func (a *API) ResumeGroupHandler(c *gin.Context) {
+	group, err := a.svc.ResumeGroup(c.Param("id"))
+	if err != nil {
+		taskError(c, err)
+		return
+	}
+	r.Success(c, group)
+}
+
+// CancelGroupHandler 取消分组中所有未完成的任务
+func (a *API) CancelGroupHandler(c *gin.Context) {
+	group, err := a.svc.CancelGroup(c.Param("id"))
+	if err != nil {
+		taskError(c, err)
+		return
+	}
+	r.Success(c, group)
+}
+
 // ProgressSSE 新增一个 /progress/:id SSE endpoint
 func (a *API) ProgressSSE(c *gin.Context) {
 	id := c.Param("id")
 	// 如果任务不存在，保持原来的行为
-	if _, ok := a.hub.Subs[id]; !ok {
+	if _, ok := a.svc.Task(id); !ok {
 		r.Error(c, http.StatusNoContent, "task finished")
 		return
 	}
 	a.svc.SSEConnect(c, id)
 }
 
-// ChooseDirHandler 处理选择下载目录请求
-func (a *API) ChooseDirHandler(c *gin.Context) {
-	path, err := dialog.Directory().Title("请选择下载目录").Browse()
+// EventsSSE 推送所有任务的进度事件，供终端界面等需要总览的客户端使用
+func (a *API) EventsSSE(c *gin.Context) {
+	a.svc.EventsConnect(c)
+}
+
+// TasksHandler 列出所有任务
+func (a *API) TasksHandler(c *gin.Context) {
+	r.Success(c, a.svc.Tasks())
+}
+
+// TaskHandler 查询单个任务，包含各镜像的统计
+func (a *API) TaskHandler(c *gin.Context) {
+	task, ok := a.svc.Task(c.Param("id"))
+	if !ok {
+		r.Error(c, http.StatusNotFound, "task not found")
+		return
+	}
+	r.Success(c, task)
+}
+
+// PatchTaskHandler 修改任务参数，例如任务限速
+func (a *API) PatchTaskHandler(c *gin.Context) {
+	var patch types.TaskPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		r.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	task, err := a.svc.UpdateTask(c.Param("id"), patch)
 	if err != nil {
-		if errors.Is(err, dialog.ErrCancelled) {
-			r.Success(c, struct {
-			}{})
-			return
-		}
+		taskError(c, err)
+		return
+	}
+	r.Success(c, task)
+}
+
+// PauseTaskHandler 手动暂停任务
+func (a *API) PauseTaskHandler(c *gin.Context) {
+	task, err := a.svc.Pause(c.Param("id"))
+	if err != nil {
+		taskError(c, err)
+		return
+	}
+	r.Success(c, task)
+}
+
+// ResumeTaskHandler 恢复暂停或失败的任务
+func (a *API) ResumeTaskHandler(c *gin.Context) {
+	task, err := a.svc.Resume(c.Param("id"))
+	if err != nil {
+		taskError(c, err)
+		return
+	}
+	r.Success(c, task)
+}
+
+// CancelTaskHandler 取消任务并删除已下载的分段
+func (a *API) CancelTaskHandler(c *gin.Context) {
+	task, err := a.svc.Cancel(c.Param("id"))
+	if err != nil {
+		taskError(c, err)
+		return
+	}
+	r.Success(c, task)
+}
+
+// taskError 把任务操作的错误转换为响应
+func taskError(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrTaskNotFound) || errors.Is(err, service.ErrGroupNotFound) {
+		r.Error(c, http.StatusNotFound, err.Error())
+		return
+	}
+	r.Error(c, http.StatusBadRequest, err.Error())
+}
+
+// LimitsHandler 查询全局限速
+func (a *API) LimitsHandler(c *gin.Context) {
+	r.Success(c, a.svc.Limits())
+}
+
+// SetLimitsHandler 修改全局限速
+func (a *API) SetLimitsHandler(c *gin.Context) {
+	var limits types.Limits
+	if err := c.ShouldBindJSON(&limits); err != nil {
+		r.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := a.svc.SetLimits(limits); err != nil {
+		r.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	r.Success(c, a.svc.Limits())
+}
+
+// ScheduleHandler 查询每周限速计划
+func (a *API) ScheduleHandler(c *gin.Context) {
+	r.Success(c, a.svc.Schedule())
+}
+
+// SetScheduleHandler 替换每周限速计划
+func (a *API) SetScheduleHandler(c *gin.Context) {
+	var profiles []types.SpeedProfile
+	if err := c.ShouldBindJSON(&profiles); err != nil {
+		r.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := a.svc.SetSchedule(profiles); err != nil {
+		r.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	r.Success(c, a.svc.Schedule())
+}
+
+// ProxiesHandler 查询代理池中每个代理的连接数、吞吐和健康状态
+func (a *API) ProxiesHandler(c *gin.Context) {
+	r.Success(c, a.svc.Proxies())
+}
+
+// CookiesHandler 查询共享 jar 中来自文件和上传的 cookie，不返回值
+func (a *API) CookiesHandler(c *gin.Context) {
+	r.Success(c, a.svc.Cookies())
+}
+
+// UploadCookiesHandler 上传 Netscape cookies.txt，replace=true 时替换之前上传的 cookie
+func (a *API) UploadCookiesHandler(c *gin.Context) {
+	n, err := a.svc.UploadCookies(http.MaxBytesReader(c.Writer, c.Request.Body, maxCookiesSize), c.Query("replace") == "true")
+	if err != nil {
+		r.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	r.Success(c, gin.H{"added": n})
+}
+
+// RefreshCookiesHandler 重新读取配置中的 cookies.txt
+func (a *API) RefreshCookiesHandler(c *gin.Context) {
+	if err := a.svc.RefreshCookies(); err != nil {
 		r.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
-	r.Success(c, gin.H{"path": path})
+	r.Success(c, a.svc.Cookies())
+}
+
+// SettingsHandler 查询守护进程的设置，凭据中的密码和令牌被隐去
+func (a *API) SettingsHandler(c *gin.Context) {
+	r.Success(c, a.svc.Settings().Redacted())
+}
+
+// SetSettingsHandler 修改设置并写回配置文件，未提供的字段保持不变，
+// 仍为占位符的密码和令牌保持原值
+func (a *API) SetSettingsHandler(c *gin.Context) {
+	prev := a.svc.Settings()
+	cfg := prev
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		r.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	cfg.RestoreSecrets(prev)
+	if err := a.svc.UpdateSettings(cfg); err != nil {
+		r.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	r.Success(c, a.svc.Settings().Redacted())
+}
+
+// PairRequestHandler 扩展请求配对，配对码展示在本机上
+func (a *API) PairRequestHandler(c *gin.Context) {
+	if err := a.guard.RequestPairing(c.GetHeader("Origin")); err != nil {
+		log.Printf("rejected pairing from %q: %v\n", c.GetHeader("Origin"), err)
+		r.Error(c, http.StatusForbidden, err.Error())
+		return
+	}
+	r.Success(c, struct {
+	}{})
 }
 
+// PairConfirmHandler 扩展提交用户输入的配对码，成功后返回令牌
+func (a *API) PairConfirmHandler(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		r.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	token, err := a.guard.ConfirmPairing(c.GetHeader("Origin"), req.Code)
+	if err != nil {
+		log.Printf("rejected pairing from %q: %v\n", c.GetHeader("Origin"), err)
+		r.Error(c, http.StatusForbidden, err.Error())
+		return
+	}
+	r.Success(c, gin.H{"token": token})
+}
+
+// MetricsHandler 以 Prometheus 文本格式导出运行指标
+func (a *API) MetricsHandler(c *gin.Context) {
+	c.Header("Content-Type", metrics.ContentType)
+	c.Status(http.StatusOK)
+	if err := a.svc.WriteMetrics(c.Writer); err != nil {
+		log.Println("write metrics failed:", err)
+	}
+}
+
+// OpenDirHandler 在文件管理器中显示下载的文件，id 指定任务，path 必须是下载过的文件或其所在目录
 func (a *API) OpenDirHandler(c *gin.Context) {
+	if id := c.Query("id"); id != "" {
+		if err := a.svc.OpenTask(id); err != nil {
+			taskError(c, err)
+			return
+		}
+		r.Success(c, gin.H{"id": id})
+		return
+	}
 	path := c.Query("path")
 	if err := a.svc.OpenInFileManager(path); err != nil {
 		r.Error(c, http.StatusBadRequest, err.Error())
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go-download/internal/core/util/r"
+)
+
+const (
+	pairCodeTTL     = 2 * time.Minute // 配对码的有效期
+	maxPairAttempts = 5               // 配对码允许输错的次数
+)
+
+// 只有允许列表中的浏览器扩展可以发起配对，网页无法伪造这些 Origin
+var extensionSchemes = []string{"chrome-extension://", "moz-extension://"}
+
+var (
+	ErrNotExtension = errors.New("pairing is only allowed from a known browser extension")
+	ErrNoPairing    = errors.New("no pairing in progress, request a new code")
+	ErrWrongCode    = errors.New("wrong pairing code")
+)
+
+// Guard 保护本地 API：Origin 必须在允许列表中，并且要携带本机的令牌。
+// 扩展通过配对流程获得令牌，本机的命令行客户端直接读取令牌文件。
+type Guard struct {
+	token      string
+	origins    func() []string
+	extensions func() []string
+	trust      func(origin string) error
+
+	// OnPairCode 在生成配对码时调用，用于把配对码展示给用户
+	OnPairCode func(origin, code string)
+
+	mu   sync.Mutex
+	pair *pairing
+	now  func() time.Time
+}
+
+type pairing struct {
+	origin   string
+	code     string
+	expires  time.Time
+	attempts int
+}
+
+// NewGuard 创建 Guard，origins 返回当前允许的 Origin，extensions 返回允许发起配对的扩展 ID，
+// trust 在配对成功后记住新的 Origin
+func NewGuard(token string, origins, extensions func() []string, trust func(origin string) error) *Guard {
+	return &Guard{
+		token:      token,
+		origins:    origins,
+		extensions: extensions,
+		trust:      trust,
+		now:        time.Now,
+	}
+}
+
+// LoadToken 读取令牌文件，不存在时生成一个新的令牌，文件只有当前用户可读
+func LoadToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	log.Println("created api token:", path)
+	return token, nil
+}
+
+// Middleware 拒绝来自未知 Origin 或没有有效令牌的请求
+func (g *Guard) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin != "" && !g.originAllowed(origin) {
+			reject(c, http.StatusForbidden, "origin not allowed")
+			return
+		}
+		if !g.validToken(requestToken(c)) {
+			reject(c, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequestPairing 为扩展生成一个新的配对码，之前的配对码作废
+func (g *Guard) RequestPairing(origin string) error {
+	if !g.isExtension(origin) {
+		return ErrNotExtension
+	}
+	code, err := newPairCode()
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.pair = &pairing{origin: origin, code: code, expires: g.now().Add(pairCodeTTL)}
+	g.mu.Unlock()
+
+	log.Printf("pairing requested by %s, code: %s\n", origin, code)
+	if g.OnPairCode != nil {
+		g.OnPairCode(origin, code)
+	}
+	return nil
+}
+
+// ConfirmPairing 校验用户输入的配对码，成功后信任该 Origin 并返回令牌
+func (g *Guard) ConfirmPairing(origin, code string) (string, error) {
+	g.mu.Lock()
+	p := g.pair
+	if p == nil || p.origin != origin || g.now().After(p.expires) {
+		g.pair = nil
+		g.mu.Unlock()
+		return "", ErrNoPairing
+	}
+	if subtle.ConstantTimeCompare([]byte(p.code), []byte(strings.TrimSpace(code))) != 1 {
+		p.attempts++
+		if p.attempts >= maxPairAttempts {
+			g.pair = nil
+		}
+		g.mu.Unlock()
+		return "", ErrWrongCode
+	}
+	g.pair = nil
+	g.mu.Unlock()
+
+	if err := g.trust(origin); err != nil {
+		return "", err
+	}
+	log.Println("paired with", origin)
+	return g.token, nil
+}
+
+func (g *Guard) originAllowed(origin string) bool {
+	for _, o := range g.origins() {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Guard) validToken(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(g.token)) == 1
+}
+
+// requestToken 从 Authorization 头读取令牌；EventSource 不能设置请求头，允许放在 token 参数中
+func requestToken(c *gin.Context) string {
+	if v := c.GetHeader("Authorization"); strings.HasPrefix(v, "Bearer ") {
+		return strings.TrimPrefix(v, "Bearer ")
+	}
+	return c.Query("token")
+}
+
+// isExtension 判断 origin 是否是允许列表中的扩展
+func (g *Guard) isExtension(origin string) bool {
+	for _, scheme := range extensionSchemes {
+		id, ok := strings.CutPrefix(origin, scheme)
+		if !ok || id == "" {
+			continue
+		}
+		for _, allowed := range g.extensions() {
+			if strings.EqualFold(id, allowed) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newPairCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func reject(c *gin.Context, status int, reason string) {
+	log.Printf("rejected %s %s from %s, origin %q: %s\n",
+		c.Request.Method, c.Request.URL.Path, c.ClientIP(), c.GetHeader("Origin"), reason)
+	r.Error(c, status, reason)
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const extOrigin = "chrome-extension://abcdefghijklmnopabcdefghijklmnop"
+
+func newTestGuard(t *testing.T) (*Guard, *[]string) {
+	origins := &[]string{}
+	extensions := func() []string {
+		return []string{"ABCDEFGHIJKLMNOPABCDEFGHIJKLMNOP", "8f2c1e6a-2b7d-4c7e-9a51-0d3f6b9e4a21"}
+	}
+	g := NewGuard("secret", func() []string { return *origins }, extensions, func(origin string) error {
+		*origins = append(*origins, origin)
+		return nil
+	})
+	return g, origins
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	g, origins := newTestGuard(t)
+	*origins = []string{extOrigin}
+
+	router := gin.New()
+	router.Use(g.Middleware())
+	router.GET("/gd/tasks", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	cases := []struct {
+		name   string
+		origin string
+		auth   string
+		query  string
+		want   int
+	}{
+		{"drive-by website", "https://evil.example", "", "", http.StatusForbidden},
+		{"website with stolen token", "https://evil.example", "Bearer secret", "", http.StatusForbidden},
+		{"extension without token", extOrigin, "", "", http.StatusUnauthorized},
+		{"extension with wrong token", extOrigin, "Bearer wrong", "", http.StatusUnauthorized},
+		{"extension", extOrigin, "Bearer secret", "", http.StatusOK},
+		{"event source", extOrigin, "", "?token=secret", http.StatusOK},
+		{"local client", "", "Bearer secret", "", http.StatusOK},
+		{"local client without token", "", "", "", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/gd/tasks"+tc.query, nil)
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tc.want, w.Code, tc.name)
+	}
+}
+
+func TestPairing(t *testing.T) {
+	g, origins := newTestGuard(t)
+	var code string
+	g.OnPairCode = func(_, c string) { code = c }
+
+	assert.ErrorIs(t, g.RequestPairing("https://evil.example"), ErrNotExtension)
+	// 其它扩展不能发起配对
+	assert.ErrorIs(t, g.RequestPairing("chrome-extension://ponmlkjihgfedcbaponmlkjihgfedcba"), ErrNotExtension)
+	assert.ErrorIs(t, g.RequestPairing("chrome-extension://"), ErrNotExtension)
+	assert.NoError(t, g.RequestPairing("moz-extension://8f2c1e6a-2b7d-4c7e-9a51-0d3f6b9e4a21"))
+	_, err := g.ConfirmPairing(extOrigin, "000000")
+	assert.ErrorIs(t, err, ErrNoPairing)
+
+	require.NoError(t, g.RequestPairing(extOrigin))
+	require.Len(t, code, 6)
+
+	// 其他 Origin 不能使用这个配对码
+	_, err = g.ConfirmPairing("chrome-extension://other", code)
+	assert.ErrorIs(t, err, ErrNoPairing)
+
+	require.NoError(t, g.RequestPairing(extOrigin))
+	token, err := g.ConfirmPairing(extOrigin, code)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", token)
+	assert.Equal(t, []string{extOrigin}, *origins)
+
+	// 配对码只能使用一次
+	_, err = g.ConfirmPairing(extOrigin, code)
+	assert.ErrorIs(t, err, ErrNoPairing)
+}
+
+func TestPairingLimits(t *testing.T) {
+	g, origins := newTestGuard(t)
+	var code string
+	g.OnPairCode = func(_, c string) { code = c }
+	now := time.Now()
+	g.now = func() time.Time { return now }
+
+	require.NoError(t, g.RequestPairing(extOrigin))
+	wrong := "x" + code[1:]
+	for i := 0; i < maxPairAttempts; i++ {
+		_, err := g.ConfirmPairing(extOrigin, wrong)
+		assert.ErrorIs(t, err, ErrWrongCode)
+	}
+	_, err := g.ConfirmPairing(extOrigin, code)
+	assert.ErrorIs(t, err, ErrNoPairing, "too many wrong attempts must cancel the pairing")
+
+	require.NoError(t, g.RequestPairing(extOrigin))
+	now = now.Add(pairCodeTTL + time.Second)
+	_, err = g.ConfirmPairing(extOrigin, code)
+	assert.ErrorIs(t, err, ErrNoPairing, "expired code")
+	assert.Empty(t, *origins)
+}
+
+func TestLoadToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "go-download", "token")
+	token, err := LoadToken(path)
+	require.NoError(t, err)
+	assert.Len(t, token, 64)
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	if os.PathSeparator == '/' {
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	again, err := LoadToken(path)
+	require.NoError(t, err)
+	assert.Equal(t, token, again)
+}
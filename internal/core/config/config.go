@@ -0,0 +1,496 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go-download/internal/core/types"
+	"go-download/internal/pget"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"gopkg.in/yaml.v3"
+)
+
+// 轮询配置文件变化的间隔
+var watchInterval = 2 * time.Second
+
+// ExtensionID 是 go-download-ext/manifest.json 中的 key 对应的 Chrome 扩展 ID
+const ExtensionID = "keopaildobhnnelemdpnmkdjkijpgjma"
+
+// Config 是守护进程的全部设置，保存在用户配置目录下的 config.yaml 中。
+// 除 Listen 外的修改都会热加载，Listen 需要重启后生效。
+type Config struct {
+	Listen      string               `yaml:"listen" json:"listen"`
+	DownloadDir string               `yaml:"downloadDir" json:"downloadDir"`
+	Connections int                  `yaml:"connections" json:"connections"` // 每个 URL 的连接数
+	MaxActive   int                  `yaml:"maxActive" json:"maxActive"`     // 同时运行的任务数，0 表示不限制
+	Timeout     Duration             `yaml:"timeout" json:"timeout"`         // 探测请求的超时
+	Proxy       string               `yaml:"proxy" json:"proxy"`             // 请求未指定代理时使用
+	ProxyRules  []pget.ProxyRule     `yaml:"proxyRules" json:"proxyRules"`   // 按域名选择代理，优先于 Proxy
+	ProxyPool   []string             `yaml:"proxyPool" json:"proxyPool"`     // 分段连接轮流使用的代理，优先于 Proxy
+	PoolPolicy  pget.PoolPolicy      `yaml:"poolPolicy" json:"poolPolicy"`   // round-robin 或 least-loaded
+	TLS         []pget.TLSConfig     `yaml:"tls" json:"tls"`                 // 全局和按域名的 TLS 设置
+	Credentials []pget.Credential    `yaml:"credentials" json:"credentials"` // 按域名的认证凭据，任务未指定凭据时使用
+	CookieFiles []string             `yaml:"cookieFiles" json:"cookieFiles"` // 启动和修改时加载的 Netscape cookies.txt
+	AutoVerify  bool                 `yaml:"autoVerify" json:"autoVerify"`   // 任务未设置 autoVerify 时是否查找校验文件
+	SSEThrottle Duration             `yaml:"sseThrottle" json:"sseThrottle"` // SSE 推送的最小间隔
+	RateLimit   int64                `yaml:"rateLimit" json:"rateLimit"`     // 全局限速 bytes/s，0 表示不限速
+	Schedule    []types.SpeedProfile `yaml:"schedule" json:"schedule"`       // 每周限速计划
+
+	// 允许访问本地 API 的 Origin，扩展配对成功后自动加入
+	AllowedOrigins []string `yaml:"allowedOrigins" json:"allowedOrigins"`
+	// 允许发起配对的扩展 ID，即 chrome-extension:// 或 moz-extension:// 之后的部分
+	ExtensionIDs []string `yaml:"extensionIds" json:"extensionIds"`
+	// 允许下载到的目录，为空时只允许 DownloadDir
+	AllowedRoots []string `yaml:"allowedRoots" json:"allowedRoots"`
+	// 任务未设置 extract 时，按规则解压下载完成的压缩包
+	ExtractRules []types.ExtractRule `yaml:"extractRules" json:"extractRules"`
+}
+
+// Roots 返回允许下载到的目录
+func (c Config) Roots() []string {
+	if len(c.AllowedRoots) == 0 {
+		return []string{c.DownloadDir}
+	}
+	return c.AllowedRoots
+}
+
+// Default 返回默认配置
+func Default() Config {
+	dir, _ := filepath.Abs(defaultDownloadsDir())
+	return Config{
+		Listen:      "127.0.0.1:11235", // 只监听本机，避免局域网内的其他机器访问
+		DownloadDir: dir,
+		Connections: 4,
+		Timeout:     Duration{10 * time.Second},
+		SSEThrottle: Duration{100 * time.Millisecond},
+
+		ExtensionIDs: []string{ExtensionID},
+	}
+}
+
+// Validate 检查配置是否合法
+func (c Config) Validate() error {
+	if c.Listen == "" {
+		return fmt.Errorf("listen address is required")
+	}
+	if c.DownloadDir == "" || !filepath.IsAbs(c.DownloadDir) {
+		return fmt.Errorf("downloadDir must be an absolute path")
+	}
+	if c.Connections < 1 || c.Connections > 16 {
+		return fmt.Errorf("connections must be between 1 and 16")
+	}
+	if c.MaxActive < 0 {
+		return fmt.Errorf("maxActive must not be negative")
+	}
+	if c.Timeout.Duration <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	if c.SSEThrottle.Duration < 10*time.Millisecond {
+		return fmt.Errorf("sseThrottle must be at least 10ms")
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("rateLimit must not be negative")
+	}
+	for _, id := range c.ExtensionIDs {
+		if id == "" || strings.ContainsAny(id, ":/ ") {
+			return fmt.Errorf("invalid extension id %q", id)
+		}
+	}
+	for _, root := range c.AllowedRoots {
+		if !filepath.IsAbs(root) {
+			return fmt.Errorf("allowedRoots must be absolute paths: %s", root)
+		}
+	}
+	if !inRoots(c.DownloadDir, c.Roots()) {
+		return fmt.Errorf("downloadDir must be inside allowedRoots")
+	}
+	if c.Proxy != "" {
+		if _, err := pget.ParseProxy(c.Proxy); err != nil {
+			return err
+		}
+	}
+	if err := pget.ValidateProxyRules(c.ProxyRules); err != nil {
+		return err
+	}
+	for _, p := range c.ProxyPool {
+		if _, err := pget.ParseProxy(p); err != nil {
+			return fmt.Errorf("proxyPool: %w", err)
+		}
+	}
+	if err := pget.ValidatePoolPolicy(c.PoolPolicy); err != nil {
+		return err
+	}
+	if err := pget.ValidateTLS(c.TLS); err != nil {
+		return err
+	}
+	if err := pget.ValidateCredentials(c.Credentials); err != nil {
+		return err
+	}
+	for _, f := range c.CookieFiles {
+		if !filepath.IsAbs(f) {
+			return fmt.Errorf("cookieFiles must be absolute paths: %s", f)
+		}
+	}
+	if err := types.ValidateExtractRules(c.ExtractRules); err != nil {
+		return err
+	}
+	return types.ValidateSchedule(c.Schedule)
+}
+
+// Redacted 返回隐去凭据和代理地址中密码和令牌的副本，用于通过 API 返回
+func (c Config) Redacted() Config {
+	creds := make([]pget.Credential, len(c.Credentials))
+	for i, cred := range c.Credentials {
+		creds[i] = cred.Redacted()
+	}
+	c.Credentials = creds
+	c.Proxy = redactProxy(c.Proxy)
+	rules := make([]pget.ProxyRule, len(c.ProxyRules))
+	for i, rule := range c.ProxyRules {
+		rule.Proxy = redactProxy(rule.Proxy)
+		rules[i] = rule
+	}
+	c.ProxyRules = rules
+	pool := make([]string, len(c.ProxyPool))
+	for i, p := range c.ProxyPool {
+		pool[i] = redactProxy(p)
+	}
+	c.ProxyPool = pool
+	return c
+}
+
+// redactProxy 把代理地址中的密码换成占位符
+func redactProxy(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); !ok {
+		return raw
+	}
+	u.User = url.UserPassword(u.User.Username(), pget.RedactedSecret)
+	return u.String()
+}
+
+// restoreProxy 在 raw 的密码是占位符时换回 prev 中隐去密码后与 raw 相同的代理地址
+func restoreProxy(raw string, prev []string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if password, _ := u.User.Password(); password != pget.RedactedSecret {
+		return raw
+	}
+	for _, old := range prev {
+		if old != raw && redactProxy(old) == raw {
+			return old
+		}
+	}
+	return raw
+}
+
+// RestoreSecrets 把仍是占位符的密码和令牌换回 prev 中相同域名和用户名的凭据的值，
+// 代理地址中的密码换回 prev 中除密码外相同的代理的值，这样客户端可以把 Redacted 的结果修改后提交回来
+func (c *Config) RestoreSecrets(prev Config) {
+	for i := range c.Credentials {
+		cred := &c.Credentials[i]
+		for _, old := range prev.Credentials {
+			if !slices.Equal(old.Hosts, cred.Hosts) || old.Username != cred.Username {
+				continue
+			}
+			if cred.Password == pget.RedactedSecret {
+				cred.Password = old.Password
+			}
+			if cred.Token == pget.RedactedSecret {
+				cred.Token = old.Token
+			}
+			break
+		}
+	}
+
+	proxies := append([]string{prev.Proxy}, prev.ProxyPool...)
+	for _, rule := range prev.ProxyRules {
+		proxies = append(proxies, rule.Proxy)
+	}
+	c.Proxy = restoreProxy(c.Proxy, proxies)
+	for i := range c.ProxyRules {
+		c.ProxyRules[i].Proxy = restoreProxy(c.ProxyRules[i].Proxy, proxies)
+	}
+	for i := range c.ProxyPool {
+		c.ProxyPool[i] = restoreProxy(c.ProxyPool[i], proxies)
+	}
+}
+
+// Dir 返回保存配置文件、令牌等数据的目录
+func Dir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "go-download"), nil
+}
+
+// inRoots 只按字面判断，符号链接在下载时由 util.ResolveWithin 检查
+func inRoots(path string, roots []string) bool {
+	for _, root := range roots {
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultPath 返回默认的配置文件路径
+func DefaultPath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.yaml"), nil
+}
+
+// Store 持有当前配置，负责读写配置文件并在变化时通知订阅者
+type Store struct {
+	path string
+
+	mu      sync.RWMutex
+	cfg     Config
+	modTime time.Time
+	size    int64
+	subs    []func(Config)
+}
+
+// Load 读取配置文件，文件不存在时以默认配置创建
+func Load(path string) (*Store, error) {
+	s := &Store{path: path, cfg: Default()}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := s.write(s.cfg); err != nil {
+			return nil, err
+		}
+		log.Println("created config file:", path)
+		return s, nil
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Read 只读取配置文件，不存在时返回默认配置，供命令行客户端使用
+func Read(path string) (Config, error) {
+	cfg := Default()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Path 返回配置文件路径
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Get 返回当前配置的副本
+func (s *Store) Get() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cfg := s.cfg
+	cfg.Schedule = append([]types.SpeedProfile(nil), s.cfg.Schedule...)
+	cfg.AllowedOrigins = append([]string(nil), s.cfg.AllowedOrigins...)
+	cfg.ExtensionIDs = append([]string(nil), s.cfg.ExtensionIDs...)
+	cfg.AllowedRoots = append([]string(nil), s.cfg.AllowedRoots...)
+	cfg.ProxyRules = append([]pget.ProxyRule(nil), s.cfg.ProxyRules...)
+	cfg.ProxyPool = append([]string(nil), s.cfg.ProxyPool...)
+	cfg.TLS = append([]pget.TLSConfig(nil), s.cfg.TLS...)
+	cfg.Credentials = append([]pget.Credential(nil), s.cfg.Credentials...)
+	cfg.CookieFiles = append([]string(nil), s.cfg.CookieFiles...)
+	cfg.ExtractRules = append([]types.ExtractRule(nil), s.cfg.ExtractRules...)
+	return cfg
+}
+
+// OnChange 注册配置变化的回调，回调在修改配置的 goroutine 中同步执行
+func (s *Store) OnChange(fn func(Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs = append(s.subs, fn)
+}
+
+// Update 校验并保存新配置，然后通知订阅者
+func (s *Store) Update(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if err := s.write(cfg); err != nil {
+		return err
+	}
+	s.notify(cfg)
+	return nil
+}
+
+// Watch 轮询配置文件，文件被外部修改时热加载，直到 ctx 结束
+func (s *Store) Watch(ctx context.Context) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(s.path)
+		if err != nil {
+			continue
+		}
+		s.mu.RLock()
+		changed := !fi.ModTime().Equal(s.modTime) || fi.Size() != s.size
+		s.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := s.reload(); err != nil {
+			// 保留旧配置，等待用户修正
+			log.Println("reload config failed, keep the previous one:", err)
+			continue
+		}
+		log.Println("config reloaded:", s.path)
+	}
+}
+
+// reload 从文件读取配置，缺省的字段取默认值
+func (s *Store) reload() error {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	cfg := Default()
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		s.remember(fi)
+		return fmt.Errorf("parse %s: %w", s.path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		s.remember(fi)
+		return fmt.Errorf("invalid %s: %w", s.path, err)
+	}
+	s.remember(fi)
+	s.notify(cfg)
+	return nil
+}
+
+func (s *Store) remember(fi os.FileInfo) {
+	s.mu.Lock()
+	s.modTime, s.size = fi.ModTime(), fi.Size()
+	s.mu.Unlock()
+}
+
+func (s *Store) notify(cfg Config) {
+	s.mu.Lock()
+	s.cfg = cfg
+	subs := make([]func(Config), len(s.subs))
+	copy(subs, s.subs)
+	s.mu.Unlock()
+	for _, fn := range subs {
+		fn(cfg)
+	}
+}
+
+// write 原子地写入配置文件
+func (s *Store) write(cfg Config) error {
+	var buf bytes.Buffer
+	buf.WriteString("# go-download 配置文件，修改后自动生效（listen 需要重启）\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	// 配置中可能有代理密码和认证凭据，只允许当前用户读取
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(s.path); err == nil {
+		s.remember(fi)
+	}
+	return nil
+}
+
+// Duration 在 YAML 和 JSON 中都以 "100ms" 这样的字符串表示
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	return d.parse(v)
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	return d.parse(node.Value)
+}
+
+func (d *Duration) parse(v string) error {
+	parsed, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func defaultDownloadsDir() string {
+	// 简单且通常有效的做法：用 home + "Downloads"
+	// 更严格的实现可以在 Linux 读取 ~/.config/user-dirs.dirs 中 XDG_DOWNLOAD_DIR
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "." // 兜底
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		// Windows 的 Downloads 通常在 %USERPROFILE%\Downloads
+		return filepath.Join(home, "Downloads")
+	case "darwin":
+		return filepath.Join(home, "Downloads")
+	default: // linux / other
+		// 尝试读取 XDG 配置（更完善），这里先用 home/Downloads
+		return filepath.Join(home, "Downloads")
+	}
+}
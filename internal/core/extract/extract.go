@@ -0,0 +1,342 @@
+// Package extract 把下载完成的压缩包解压到同级目录，支持的格式见 Formats。
+// archiver v3 不支持 7z，要求解压 7z 时返回 ErrUnsupported。
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mholt/archiver"
+	"github.com/pkg/errors"
+)
+
+const (
+	StateExtracting = "extracting"
+	StateDone       = "done"
+	StateFailed     = "failed"
+)
+
+var ErrUnsupported = errors.New("unsupported archive format")
+
+// Formats 是支持解压的格式
+var Formats = []string{"zip", "tar", "tar.gz", "tar.xz", "tar.bz2", "tar.lz4", "tar.sz", "rar"}
+
+// Progress 是解压的进度，Done 和 Total 是已读取和全部的压缩包字节数
+type Progress struct {
+	State string `json:"state"`         // extracting、done 或 failed
+	Dir   string `json:"dir,omitempty"` // 解压到的目录
+	Done  int64  `json:"done"`
+	Total int64  `json:"total"`
+	Files int    `json:"files"` // 已解压的文件数
+	Error string `json:"error,omitempty"`
+}
+
+// archiveExts 是支持的扩展名，较长的在前，用于从文件名中去掉扩展名
+var archiveExts = []string{
+	".tar.gz", ".tar.xz", ".tar.bz2", ".tar.lz4", ".tar.sz",
+	".tgz", ".txz", ".tbz2", ".tlz4", ".tsz", ".tar", ".zip", ".rar",
+}
+
+// unsupportedExts 是能识别但不能解压的压缩包
+var unsupportedExts = []string{".7z"}
+
+// Supported 根据文件名判断是否是支持的压缩包
+func Supported(name string) bool {
+	return Check(name) == nil
+}
+
+// Check 根据文件名检查是否是支持的压缩包，不支持时返回的错误中列出支持的格式
+func Check(name string) error {
+	_, err := reader(name)
+	return err
+}
+
+// Archive 根据文件名判断是否是压缩包，包括不支持解压的 7z
+func Archive(name string) bool {
+	if Supported(name) {
+		return true
+	}
+	lower := strings.ToLower(name)
+	for _, ext := range unsupportedExts {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func reader(name string) (archiver.Reader, error) {
+	// archiver 按小写的扩展名判断
+	a, err := archiver.ByExtension(strings.ToLower(filepath.Base(name)))
+	if err != nil {
+		return nil, unsupported(name)
+	}
+	r, ok := a.(archiver.Reader)
+	if !ok {
+		// .gz、.xz 等单个文件的压缩格式
+		return nil, unsupported(name)
+	}
+	return r, nil
+}
+
+func unsupported(name string) error {
+	return errors.Wrapf(ErrUnsupported, "cannot extract %s (supported: %s)", filepath.Base(name), strings.Join(Formats, ", "))
+}
+
+// Destination 返回与压缩包同级、以去掉扩展名的文件名命名且尚不存在的目录，
+// 例如 app-1.0.tar.gz 解压到 app-1.0，已存在时依次尝试 app-1.0-1、app-1.0-2
+func Destination(archive string) string {
+	base := filepath.Base(archive)
+	for _, ext := range archiveExts {
+		if len(base) > len(ext) && strings.HasSuffix(strings.ToLower(base), ext) {
+			base = base[:len(base)-len(ext)]
+			break
+		}
+	}
+	dir := filepath.Join(filepath.Dir(archive), base)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(dir); os.IsNotExist(err) {
+			return dir
+		}
+		dir = filepath.Join(filepath.Dir(archive), base+"-"+strconv.Itoa(i))
+	}
+}
+
+// Extract 把压缩包解压到 dest，dest 不能已经存在。拒绝指向 dest 之外的路径和链接（zip slip），
+// 跳过设备文件等特殊文件，失败或取消时删除已解压的内容。progress 在每个文件解压后调用。
+func Extract(ctx context.Context, archive, dest string, progress func(Progress)) (Progress, error) {
+	p := Progress{State: StateExtracting, Dir: dest}
+	if _, err := os.Lstat(dest); err == nil {
+		p.State, p.Error = StateFailed, dest+" already exists"
+		return p, errors.New(p.Error)
+	}
+	if err := extract(ctx, archive, dest, &p, progress); err != nil {
+		// archiver 用 %v 包装错误，取消时直接返回 ctx 的错误
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		p.State, p.Error = StateFailed, err.Error()
+		os.RemoveAll(dest)
+		return p, err
+	}
+	p.State, p.Done = StateDone, p.Total
+	return p, nil
+}
+
+func extract(ctx context.Context, archive, dest string, p *Progress, progress func(Progress)) error {
+	r, err := reader(archive)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	p.Total = info.Size()
+	in := &countingReader{ctx: ctx, f: f}
+
+	if err := os.Mkdir(dest, 0755); err != nil {
+		return err
+	}
+	// dest 的上级目录可能是符号链接，用真实路径判断条目是否在 dest 中
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+	if err := r.Open(in, info.Size()); err != nil {
+		return errors.Wrap(err, "open archive")
+	}
+	defer r.Close()
+
+	for {
+		file, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "read archive")
+		}
+		err = extractFile(root, file)
+		if file.ReadCloser != nil {
+			file.Close()
+		}
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p.Files++
+		p.Done = min(atomic.LoadInt64(&in.n), p.Total)
+		if progress != nil {
+			progress(*p)
+		}
+	}
+}
+
+// extractFile 解压一个条目，链接和普通文件写入前都检查其真实路径是否仍在 root 中
+func extractFile(root string, file archiver.File) error {
+	name := entryName(file)
+	target, err := within(root, name)
+	if err != nil {
+		return err
+	}
+	if target == root {
+		return nil
+	}
+	mode := file.Mode()
+	if file.IsDir() {
+		return os.MkdirAll(target, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// 上级目录可能是前面的条目创建的符号链接
+	parent, err := filepath.EvalSymlinks(filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	if !inside(root, parent) {
+		return errors.Errorf("illegal path in archive: %s", name)
+	}
+	target = filepath.Join(parent, filepath.Base(target))
+	// 不跟随已存在的同名链接
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if h, ok := file.Header.(*tar.Header); ok && h.Typeflag == tar.TypeLink {
+		src, err := within(root, h.Linkname)
+		if err != nil {
+			return err
+		}
+		real, err := filepath.EvalSymlinks(src)
+		if err != nil || !inside(root, real) {
+			return errors.Errorf("illegal hard link in archive: %s -> %s", name, h.Linkname)
+		}
+		return os.Link(real, target)
+	}
+
+	switch {
+	case mode&os.ModeSymlink != 0:
+		link, err := linkTarget(file)
+		if err != nil {
+			return err
+		}
+		if !safeLink(root, parent, link) {
+			return errors.Errorf("illegal symlink in archive: %s -> %s", name, link)
+		}
+		return os.Symlink(link, target)
+	case mode.IsRegular():
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm()|0600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, file); err != nil {
+			out.Close()
+			return errors.Wrapf(err, "extract %s", name)
+		}
+		return out.Close()
+	}
+	// 设备文件、管道等不解压
+	return nil
+}
+
+// entryName 返回条目在压缩包中的完整路径，tar 和 zip 的 FileInfo.Name 只有文件名
+func entryName(file archiver.File) string {
+	switch h := file.Header.(type) {
+	case *tar.Header:
+		return h.Name
+	case zip.FileHeader:
+		return h.Name
+	}
+	return file.Name()
+}
+
+func linkTarget(file archiver.File) (string, error) {
+	switch h := file.Header.(type) {
+	case *tar.Header:
+		return h.Linkname, nil
+	case zip.FileHeader:
+		// zip 中链接的目标保存在内容中
+		b, err := io.ReadAll(io.LimitReader(file, 4096))
+		return string(b), err
+	}
+	return "", errors.Errorf("unsupported symlink %s", file.Name())
+}
+
+// within 把压缩包中的路径转换为 root 下的路径，拒绝绝对路径和跳出 root 的 ".."
+func within(root, name string) (string, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", errors.Errorf("illegal path in archive: %s", name)
+	}
+	if clean := path.Clean(name); clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", errors.Errorf("illegal path in archive: %s", name)
+	}
+	return filepath.Join(root, filepath.FromSlash(path.Clean(name))), nil
+}
+
+// safeLink 判断 parent 中指向 link 的符号链接是否留在 root 中。link 开头的 ".." 相对于
+// parent 的真实路径，其它组件之后的 ".." 可能经过另一个链接跳出，一律拒绝。
+func safeLink(root, parent, link string) bool {
+	if link == "" || path.IsAbs(filepath.ToSlash(link)) || filepath.IsAbs(link) {
+		return false
+	}
+	named := false
+	for _, c := range strings.Split(filepath.ToSlash(link), "/") {
+		switch c {
+		case "..":
+			if named {
+				return false
+			}
+		case ".", "":
+		default:
+			named = true
+		}
+	}
+	return inside(root, filepath.Join(parent, link))
+}
+
+func inside(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// countingReader 统计从压缩包读取的字节数，取消时中止读取。zip 通过 ReadAt 读取。
+type countingReader struct {
+	ctx context.Context
+	f   *os.File
+	n   int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.f.Read(b)
+	atomic.AddInt64(&r.n, int64(n))
+	return n, err
+}
+
+func (r *countingReader) ReadAt(b []byte, off int64) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.f.ReadAt(b, off)
+	atomic.AddInt64(&r.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,189 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mholt/archiver"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sample 创建包含子目录的目录。archiver v3 写入的符号链接不正确，链接在 TestExtractLinks 中单独测试
+func sample(t *testing.T) string {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "app")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "bin"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "README"), []byte("readme"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "bin", "app"), []byte("#!/bin/sh\n"), 0755))
+	return src
+}
+
+func TestExtract(t *testing.T) {
+	src := sample(t)
+	for _, name := range []string{"app-1.0.zip", "app-1.0.tar.gz", "app-1.0.tar.xz", "app-1.0.tar.bz2"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, name)
+			require.NoError(t, archiver.Archive([]string{src}, archive))
+			require.True(t, Supported(archive))
+
+			dest := Destination(archive)
+			assert.Equal(t, filepath.Join(dir, "app-1.0"), dest)
+			var events []Progress
+			p, err := Extract(context.Background(), archive, dest, func(p Progress) { events = append(events, p) })
+			require.NoError(t, err)
+			assert.Equal(t, StateDone, p.State)
+			assert.Equal(t, p.Total, p.Done)
+			require.NotEmpty(t, events)
+			assert.Equal(t, StateExtracting, events[0].State)
+
+			data, err := os.ReadFile(filepath.Join(dest, "app", "README"))
+			require.NoError(t, err)
+			assert.Equal(t, "readme", string(data))
+			info, err := os.Stat(filepath.Join(dest, "app", "bin", "app"))
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+			// 目录已存在时换一个名字
+			assert.Equal(t, filepath.Join(dir, "app-1.0-1"), Destination(archive))
+		})
+	}
+}
+
+func TestSupported(t *testing.T) {
+	for name, want := range map[string]bool{
+		"a.zip": true, "a.TAR.GZ": true, "a.tgz": true, "a.tar.xz": true, "a.rar": true,
+		"a.7z": false, "a.gz": false, "a.iso": false,
+	} {
+		assert.Equal(t, want, Supported(name), name)
+	}
+	assert.True(t, Archive("a.7z"))
+	assert.True(t, Archive("a.zip"))
+	assert.False(t, Archive("a.iso"))
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "app.7z")
+	require.NoError(t, os.WriteFile(archive, []byte("7z\xbc\xaf\x27\x1c"), 0644))
+	dest := Destination(archive)
+	p, err := Extract(context.Background(), archive, dest, nil)
+	assert.Equal(t, ErrUnsupported, errors.Cause(err))
+	assert.EqualError(t, err, "cannot extract app.7z (supported: zip, tar, tar.gz, tar.xz, tar.bz2, tar.lz4, tar.sz, rar): unsupported archive format")
+	assert.Equal(t, StateFailed, p.State)
+	assert.NoDirExists(t, dest)
+}
+
+type entry struct {
+	name, link string
+	typ        byte
+}
+
+func writeTar(t *testing.T, path string, entries []entry) {
+	t.Helper()
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Linkname: e.link, Typeflag: e.typ, Mode: 0644}
+		if e.typ == tar.TypeReg {
+			h.Size = 4
+		}
+		require.NoError(t, tw.WriteHeader(h))
+		if e.typ == tar.TypeReg {
+			_, err := tw.Write([]byte("evil"))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+}
+
+func TestExtractLinks(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "links.tar")
+	writeTar(t, archive, []entry{
+		{name: "bin/app", typ: tar.TypeReg},
+		{name: "run", link: "bin/app", typ: tar.TypeSymlink},
+		{name: "bin/up", link: "../run", typ: tar.TypeSymlink},
+		{name: "copy", link: "bin/app", typ: tar.TypeLink},
+		{name: "fifo", typ: tar.TypeFifo},
+	})
+	dest := Destination(archive)
+	p, err := Extract(context.Background(), archive, dest, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 5, p.Files)
+	for _, name := range []string{"run", "bin/up", "copy"} {
+		data, err := os.ReadFile(filepath.Join(dest, name))
+		require.NoError(t, err, name)
+		assert.Equal(t, "evil", string(data))
+	}
+	assert.NoFileExists(t, filepath.Join(dest, "fifo"))
+}
+
+func TestZipSlip(t *testing.T) {
+	for name, entries := range map[string][]entry{
+		"dot dot":          {{name: "../evil", typ: tar.TypeReg}},
+		"nested dot dot":   {{name: "a/../../evil", typ: tar.TypeReg}},
+		"absolute":         {{name: "/tmp/evil", typ: tar.TypeReg}},
+		"symlink out":      {{name: "out", link: "..", typ: tar.TypeSymlink}, {name: "out/evil", typ: tar.TypeReg}},
+		"absolute symlink": {{name: "out", link: "/tmp", typ: tar.TypeSymlink}},
+		"symlink chain":    {{name: "x", link: ".", typ: tar.TypeSymlink}, {name: "y", link: "x/../..", typ: tar.TypeSymlink}},
+		"hard link out":    {{name: "passwd", link: "../../etc/passwd", typ: tar.TypeLink}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "evil.tar")
+			writeTar(t, archive, entries)
+			dest := filepath.Join(dir, "sub", "evil")
+			require.NoError(t, os.Mkdir(filepath.Dir(dest), 0755))
+
+			p, err := Extract(context.Background(), archive, dest, nil)
+			require.Error(t, err)
+			assert.Equal(t, StateFailed, p.State)
+			assert.NoDirExists(t, dest)
+			assert.NoFileExists(t, filepath.Join(dir, "evil"))
+			assert.NoFileExists(t, filepath.Join(dir, "sub", "evil"))
+		})
+	}
+}
+
+func TestZipSlipZip(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "evil.zip")
+	f, err := os.Create(archive)
+	require.NoError(t, err)
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("../evil")
+	require.NoError(t, err)
+	w.Write([]byte("evil"))
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	dest := filepath.Join(dir, "sub", "evil")
+	require.NoError(t, os.Mkdir(filepath.Dir(dest), 0755))
+	_, err = Extract(context.Background(), archive, dest, nil)
+	assert.ErrorContains(t, err, "illegal path")
+	assert.NoFileExists(t, filepath.Join(dir, "sub", "evil"))
+}
+
+func TestExtractCanceled(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "app.tar.gz")
+	require.NoError(t, archiver.Archive([]string{sample(t)}, archive))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Extract(ctx, archive, Destination(archive), nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NoDirExists(t, filepath.Join(dir, "app"))
+
+	// 不覆盖已存在的目录
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "app"), 0755))
+	_, err = Extract(context.Background(), archive, filepath.Join(dir, "app"), nil)
+	assert.Error(t, err)
+	assert.DirExists(t, filepath.Join(dir, "app"))
+}
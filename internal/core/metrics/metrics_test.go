@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	bytes := r.Counter("gd_bytes_total", "Bytes downloaded.", "host")
+	tasks := r.Gauge("gd_tasks", "Tasks by state.", "state")
+	merge := r.Histogram("gd_merge_seconds", "Merge duration.", []float64{1, 0.1})
+
+	bytes.Add(100, "example.com")
+	bytes.Add(50, "example.com")
+	bytes.Add(7, `we"ird`)
+	tasks.Set(2, "running")
+	merge.Observe(0.05)
+	merge.Observe(0.5)
+	merge.Observe(5)
+
+	var b strings.Builder
+	_, err := r.WriteTo(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP gd_bytes_total Bytes downloaded.
+# TYPE gd_bytes_total counter
+gd_bytes_total{host="example.com"} 150
+gd_bytes_total{host="we\"ird"} 7
+# HELP gd_tasks Tasks by state.
+# TYPE gd_tasks gauge
+gd_tasks{state="running"} 2
+# HELP gd_merge_seconds Merge duration.
+# TYPE gd_merge_seconds histogram
+gd_merge_seconds_bucket{le="0.1"} 1
+gd_merge_seconds_bucket{le="1"} 2
+gd_merge_seconds_bucket{le="+Inf"} 3
+gd_merge_seconds_sum 5.55
+gd_merge_seconds_count 3
+`, b.String())
+}
@@ -3,24 +3,58 @@ package route
 import (
 	"github.com/gin-gonic/gin"
 	"go-download/internal/core/api"
+	"go-download/internal/core/auth"
 	"go-download/internal/core/service"
 	"go-download/internal/core/sse"
 )
 
 // SetupRouter 在这里集中注册所有路由
-// 注入 hub 与 service（依赖注入）
-func SetupRouter(hub *sse.Hub, svc *service.DownloadService) *gin.Engine {
+// 注入 hub、service 与 guard（依赖注入）
+func SetupRouter(hub *sse.Hub, svc *service.DownloadService, guard *auth.Guard) *gin.Engine {
 	r := gin.Default()
 
-	apiHandler := api.NewAPI(svc, hub)
+	apiHandler := api.NewAPI(svc, hub, guard)
+
+	// 配对接口不需要令牌，只接受扩展的 Origin
+	pairGroup := r.Group("/gd/pair")
+	{
+		pairGroup.POST("/request", apiHandler.PairRequestHandler)
+		pairGroup.POST("/confirm", apiHandler.PairConfirmHandler)
+	}
 
 	// router group
-	routerGroup := r.Group("/gd")
+	routerGroup := r.Group("/gd", guard.Middleware())
 	{
 		routerGroup.GET("/choose-dir", apiHandler.ChooseDirHandler)
 		routerGroup.GET("/open-dir", apiHandler.OpenDirHandler)
 		routerGroup.POST("/download", apiHandler.DownloadHandler)
+		routerGroup.POST("/import/curl", apiHandler.ImportCurlHandler)
+		routerGroup.POST("/metalink", apiHandler.MetalinkHandler)
+		routerGroup.POST("/batch", apiHandler.BatchHandler)
+		routerGroup.GET("/groups", apiHandler.GroupsHandler)
+		routerGroup.GET("/groups/:id", apiHandler.GroupHandler)
+		routerGroup.DELETE("/groups/:id", apiHandler.CancelGroupHandler)
+		routerGroup.POST("/groups/:id/pause", apiHandler.PauseGroupHandler)
+		routerGroup.POST("/groups/:id/resume", apiHandler.ResumeGroupHandler)
 		routerGroup.GET("/progress/:id", apiHandler.ProgressSSE)
+		routerGroup.GET("/events", apiHandler.EventsSSE)
+		routerGroup.GET("/tasks", apiHandler.TasksHandler)
+		routerGroup.GET("/tasks/:id", apiHandler.TaskHandler)
+		routerGroup.PATCH("/tasks/:id", apiHandler.PatchTaskHandler)
+		routerGroup.DELETE("/tasks/:id", apiHandler.CancelTaskHandler)
+		routerGroup.POST("/tasks/:id/pause", apiHandler.PauseTaskHandler)
+		routerGroup.POST("/tasks/:id/resume", apiHandler.ResumeTaskHandler)
+		routerGroup.GET("/limits", apiHandler.LimitsHandler)
+		routerGroup.PUT("/limits", apiHandler.SetLimitsHandler)
+		routerGroup.GET("/schedule", apiHandler.ScheduleHandler)
+		routerGroup.PUT("/schedule", apiHandler.SetScheduleHandler)
+		routerGroup.GET("/metrics", apiHandler.MetricsHandler)
+		routerGroup.GET("/proxies", apiHandler.ProxiesHandler)
+		routerGroup.GET("/cookies", apiHandler.CookiesHandler)
+		routerGroup.POST("/cookies", apiHandler.UploadCookiesHandler)
+		routerGroup.POST("/cookies/refresh", apiHandler.RefreshCookiesHandler)
+		routerGroup.GET("/settings", apiHandler.SettingsHandler)
+		routerGroup.PUT("/settings", apiHandler.SetSettingsHandler)
 	}
 
 	return r
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go-download/internal/core/types"
+	"log"
+	"net/http"
+	"sync"
+)
+
+var ErrGroupNotFound = errors.New("group not found")
+
+const (
+	maxBatchItems = 1000 // 一次批量下载的最大文件数
+	batchProbes   = 4    // 同时探测的地址数
+)
+
+// Group 是一次批量下载的汇总进度
+type Group struct {
+	ID          string    `json:"id"`
+	State       TaskState `json:"state"` // 有运行中的任务时为 running，其次为 queued、paused、failed
+	Files       int       `json:"files"`
+	FilesDone   int       `json:"filesDone"`
+	FilesFailed int       `json:"filesFailed"`
+	Total       int64     `json:"total"`
+	Downloaded  int64     `json:"downloaded"`
+	Speed       int64     `json:"speed"`
+	Tasks       []Task    `json:"tasks,omitempty"`
+}
+
+// BatchItem 是批量下载中一项的结果，创建失败时只有 URL 和 Error
+type BatchItem struct {
+	ID    string    `json:"id,omitempty"`
+	URL   string    `json:"url"`
+	Size  int64     `json:"size,omitempty"`
+	State TaskState `json:"state,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// BatchResult 是批量下载的结果
+type BatchResult struct {
+	Group string      `json:"group"`
+	Items []BatchItem `json:"items"`
+}
+
+// Batch 为每一项创建独立的任务并归入同一个分组。单项失败不影响其它项，
+// 全部失败时返回第一个错误和对应的 HTTP 状态码。
+func (s *DownloadService) Batch(batch types.BatchRequest) (BatchResult, int, error) {
+	if len(batch.Items) == 0 {
+		return BatchResult{}, http.StatusBadRequest, errors.New("items is required")
+	}
+	if len(batch.Items) > maxBatchItems {
+		return BatchResult{}, http.StatusBadRequest, errors.Errorf("at most %d items are allowed", maxBatchItems)
+	}
+	res := BatchResult{Group: uuid.New().String(), Items: make([]BatchItem, len(batch.Items))}
+	codes := make([]int, len(batch.Items))
+
+	// 探测可能很慢，并发创建，结果按请求中的顺序返回
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchProbes)
+	for i, item := range batch.Items {
+		wg.Add(1)
+		go func(i int, req types.Request) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			res.Items[i].URL = req.URL
+			task, code, err := s.createTask(req, res.Group)
+			if err != nil {
+				res.Items[i].Error, codes[i] = err.Error(), code
+				return
+			}
+			res.Items[i].ID, res.Items[i].Size, res.Items[i].State = task.ID, task.Total, task.State
+		}(i, mergeRequest(batch.Defaults, item))
+	}
+	wg.Wait()
+
+	created := 0
+	for _, item := range res.Items {
+		if item.Error == "" {
+			created++
+		}
+	}
+	log.Printf("batch %s: created %d of %d tasks\n", res.Group, created, len(res.Items))
+	if created == 0 {
+		return res, codes[0], errors.New(res.Items[0].Error)
+	}
+	return res, 0, nil
+}
+
+// mergeRequest 用 defaults 填充 item 中未设置的字段，请求头逐个合并，同名时以 item 为准
+func mergeRequest(defaults, item types.Request) types.Request {
+	if item.DownloadPath == "" {
+		item.DownloadPath = defaults.DownloadPath
+	}
+	if item.ProxyUrl == "" {
+		item.ProxyUrl = defaults.ProxyUrl
+	}
+	if item.RateLimit == 0 {
+		item.RateLimit = defaults.RateLimit
+	}
+	if item.Procs == 0 {
+		item.Procs = defaults.Procs
+	}
+	if item.Priority == 0 {
+		item.Priority = defaults.Priority
+	}
+	if item.Auth == nil {
+		item.Auth = defaults.Auth
+	}
+	if item.StartAfter == nil {
+		item.StartAfter = defaults.StartAfter
+	}
+	if item.Window == nil {
+		item.Window = defaults.Window
+	}
+	if item.AutoVerify == nil {
+		item.AutoVerify = defaults.AutoVerify
+	}
+	if item.Extract == nil {
+		item.Extract = defaults.Extract
+	}
+	if len(defaults.Headers) > 0 {
+		headers := make(map[string]string, len(defaults.Headers)+len(item.Headers))
+		for k, v := range defaults.Headers {
+			headers[k] = v
+		}
+		for k, v := range item.Headers {
+			headers[k] = v
+		}
+		item.Headers = headers
+	}
+	return item
+}
+
+// Groups 返回所有分组的汇总进度，不包含任务列表
+func (s *DownloadService) Groups() []Group {
+	var (
+		groups []Group
+		index  = map[string]int{}
+	)
+	for _, t := range s.tasks.list() {
+		if t.Group == "" {
+			continue
+		}
+		i, ok := index[t.Group]
+		if !ok {
+			i = len(groups)
+			index[t.Group] = i
+			groups = append(groups, Group{ID: t.Group})
+		}
+		groups[i].add(t)
+	}
+	for i := range groups {
+		groups[i].Tasks = nil
+	}
+	if groups == nil {
+		return []Group{}
+	}
+	return groups
+}
+
+// Group 返回分组的汇总进度和其中的任务
+func (s *DownloadService) Group(id string) (Group, error) {
+	g := Group{ID: id}
+	for _, t := range s.tasks.list() {
+		if t.Group == id {
+			g.add(t)
+		}
+	}
+	if g.Files == 0 {
+		return g, ErrGroupNotFound
+	}
+	return g, nil
+}
+
+// PauseGroup 暂停分组中排队和运行中的任务
+func (s *DownloadService) PauseGroup(id string) (Group, error) {
+	return s.groupAction(id, s.Pause)
+}
+
+// ResumeGroup 恢复分组中暂停和失败的任务
+func (s *DownloadService) ResumeGroup(id string) (Group, error) {
+	return s.groupAction(id, s.Resume)
+}
+
+// CancelGroup 取消分组中未完成的任务并删除它们的分段
+func (s *DownloadService) CancelGroup(id string) (Group, error) {
+	return s.groupAction(id, s.Cancel)
+}
+
+// groupAction 对分组中的每个任务执行 action，状态不适用的任务被跳过
+func (s *DownloadService) groupAction(id string, action func(id string) (Task, error)) (Group, error) {
+	g, err := s.Group(id)
+	if err != nil {
+		return g, err
+	}
+	for _, t := range g.Tasks {
+		_, _ = action(t.ID)
+	}
+	return s.Group(id)
+}
+
+// add 把任务计入汇总
+func (g *Group) add(t Task) {
+	g.Tasks = append(g.Tasks, t)
+	g.Files++
+	g.Total += t.Total
+	g.Downloaded += t.Downloaded
+	g.Speed += t.Speed
+	switch t.State {
+	case StateCompleted:
+		g.FilesDone++
+	case StateFailed:
+		g.FilesFailed++
+	}
+	if groupStateRank[t.State] > groupStateRank[g.State] {
+		g.State = t.State
+	}
+}
+
+// groupStateRank 决定分组的状态：有任何任务在运行时分组在运行，全部结束时以失败优先
+var groupStateRank = map[TaskState]int{
+	StateCanceled:  1,
+	StateCompleted: 2,
+	StateFailed:    3,
+	StatePaused:    4,
+	StateQueued:    5,
+	StateRunning:   6,
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-download/internal/core/sse"
+	"go-download/internal/core/types"
+)
+
+func TestBatch(t *testing.T) {
+	data := bytes.Repeat([]byte("batch"), 40*1024)
+	var (
+		mu  sync.Mutex
+		got http.Header
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.bin" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Path == "/c.bin" {
+			mu.Lock()
+			got = r.Header.Clone()
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, filepath.Base(r.URL.Path), time.Now(), bytes.NewReader(data))
+	}))
+	t.Cleanup(ts.Close)
+
+	dir := t.TempDir()
+	s := NewDownloadService(sse.NewHub())
+	cfg := s.Settings()
+	cfg.AllowedRoots = []string{dir}
+	cfg.DownloadDir = dir
+	require.NoError(t, s.UpdateSettings(cfg))
+
+	_, code, err := s.Batch(types.BatchRequest{})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	sub := filepath.Join(dir, "attachments")
+	res, _, err := s.Batch(types.BatchRequest{
+		Defaults: types.Request{DownloadPath: sub, Headers: map[string]string{"Referer": "https://page", "X-A": "1"}},
+		Items: []types.Request{
+			{URL: ts.URL + "/a.bin"},
+			{URL: ts.URL + "/missing.bin"},
+			{URL: ts.URL + "/b.bin", DownloadPath: dir},
+			{URL: ts.URL + "/c.bin", Headers: map[string]string{"X-A": "2"}},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Items, 4)
+	assert.Contains(t, res.Items[1].Error, "404")
+	for _, i := range []int{0, 2, 3} {
+		assert.NotEmpty(t, res.Items[i].ID)
+		assert.Equal(t, int64(len(data)), res.Items[i].Size)
+	}
+
+	waitFor(t, "group completed", func() bool {
+		g, err := s.Group(res.Group)
+		return err == nil && g.State == StateCompleted
+	})
+	g, err := s.Group(res.Group)
+	require.NoError(t, err)
+	assert.Equal(t, 3, g.Files)
+	assert.Equal(t, 3, g.FilesDone)
+	assert.Equal(t, int64(3*len(data)), g.Total)
+	assert.Equal(t, g.Total, g.Downloaded)
+	for _, p := range []string{filepath.Join(sub, "a.bin"), filepath.Join(dir, "b.bin"), filepath.Join(sub, "c.bin")} {
+		_, err := os.Stat(p)
+		assert.NoError(t, err, p)
+	}
+	mu.Lock()
+	assert.Equal(t, "https://page", got.Get("Referer"))
+	assert.Equal(t, "2", got.Get("X-A"))
+	mu.Unlock()
+
+	groups := s.Groups()
+	require.Len(t, groups, 1)
+	assert.Empty(t, groups[0].Tasks)
+
+	_, err = s.Group("nope")
+	assert.ErrorIs(t, err, ErrGroupNotFound)
+	_, code, err = s.Batch(types.BatchRequest{Items: []types.Request{{URL: ts.URL + "/missing.bin"}}})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotAcceptable, code)
+}
+
+func TestGroupActions(t *testing.T) {
+	data := make([]byte, 256*1024)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "slow.bin", time.Now(), bytes.NewReader(data))
+	}))
+	t.Cleanup(ts.Close)
+
+	dir := t.TempDir()
+	s := NewDownloadService(sse.NewHub())
+	cfg := s.Settings()
+	cfg.DownloadDir = dir
+	require.NoError(t, s.UpdateSettings(cfg))
+
+	res, _, err := s.Batch(types.BatchRequest{
+		Defaults: types.Request{RateLimit: 16 * 1024, Procs: 1},
+		Items: []types.Request{
+			{URL: ts.URL + "/1/slow.bin", DownloadPath: filepath.Join(dir, "1")},
+			{URL: ts.URL + "/2/slow.bin", DownloadPath: filepath.Join(dir, "2")},
+		},
+	})
+	require.NoError(t, err)
+	waitFor(t, "group running", func() bool {
+		g, _ := s.Group(res.Group)
+		return g.State == StateRunning && g.Downloaded > 0
+	})
+
+	g, err := s.PauseGroup(res.Group)
+	require.NoError(t, err)
+	assert.Equal(t, StatePaused, g.State)
+	for _, task := range g.Tasks {
+		assert.Equal(t, StatePaused, task.State)
+	}
+
+	g, err = s.ResumeGroup(res.Group)
+	require.NoError(t, err)
+	assert.Equal(t, StateRunning, g.State)
+
+	g, err = s.CancelGroup(res.Group)
+	require.NoError(t, err)
+	assert.Equal(t, StateCanceled, g.State)
+	assert.Equal(t, 0, g.FilesDone)
+
+	_, err = s.PauseGroup("nope")
+	assert.ErrorIs(t, err, ErrGroupNotFound)
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"go-download/internal/pget"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// cookieStore 是所有任务共享的 cookie jar。jar 的内容来自配置中的 cookies.txt 和通过 API 上传的 cookie，
+// 探测和下载响应中的 Set-Cookie 也会写入。刷新时整体替换 jar，已创建的 http.Client 仍然使用 cookieStore。
+type cookieStore struct {
+	mu       sync.Mutex
+	jar      *cookiejar.Jar
+	files    []string      // 配置中的 cookies.txt
+	uploaded []pget.Cookie // 通过 API 上传的 cookie
+	path     string        // 保存上传的 cookie 的文件，为空时不持久化
+}
+
+// WithCookieFile 把通过 API 上传的 cookie 保存到 path，启动时从 path 加载
+func WithCookieFile(path string) Option {
+	return func(s *DownloadService) {
+		s.cookies.path = path
+	}
+}
+
+func newCookieStore() *cookieStore {
+	return &cookieStore{jar: pget.NewCookieJar()}
+}
+
+func (c *cookieStore) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	c.mu.Lock()
+	jar := c.jar
+	c.mu.Unlock()
+	jar.SetCookies(u, cookies)
+}
+
+func (c *cookieStore) Cookies(u *url.URL) []*http.Cookie {
+	c.mu.Lock()
+	jar := c.jar
+	c.mu.Unlock()
+	return jar.Cookies(u)
+}
+
+// restore 读取上次上传的 cookie
+func (c *cookieStore) restore() {
+	if c.path == "" {
+		return
+	}
+	cookies, err := readCookieFile(c.path)
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		log.Println("load uploaded cookies failed:", err)
+		return
+	}
+	c.mu.Lock()
+	c.uploaded = cookies
+	pget.SetCookies(c.jar, cookies)
+	c.mu.Unlock()
+}
+
+// reload 用 files 和上传的 cookie 重建 jar，之前响应中设置的 cookie 被丢弃。
+// 读取失败的文件被跳过，返回第一个错误。
+func (c *cookieStore) reload(files []string) error {
+	var (
+		loaded   [][]pget.Cookie
+		firstErr error
+	)
+	for _, f := range files {
+		cookies, err := readCookieFile(f)
+		if err != nil {
+			log.Println("load cookies failed:", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		loaded = append(loaded, cookies)
+	}
+	jar := pget.NewCookieJar()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cookies := range loaded {
+		pget.SetCookies(jar, cookies)
+	}
+	// 上传的 cookie 优先于文件中的同名 cookie
+	pget.SetCookies(jar, c.uploaded)
+	c.jar = jar
+	c.files = append([]string(nil), files...)
+	return firstErr
+}
+
+// upload 加入上传的 cookie，replace 为 true 时丢弃之前上传的 cookie 并重建 jar
+func (c *cookieStore) upload(cookies []pget.Cookie, replace bool) error {
+	c.mu.Lock()
+	if replace {
+		c.uploaded = nil
+	}
+	c.uploaded = mergeCookies(c.uploaded, cookies)
+	uploaded := append([]pget.Cookie(nil), c.uploaded...)
+	files := c.files
+	c.mu.Unlock()
+
+	if err := c.save(uploaded); err != nil {
+		return err
+	}
+	if replace {
+		return c.reload(files)
+	}
+	pget.SetCookies(c, cookies)
+	return nil
+}
+
+// save 原子地把上传的 cookie 写入文件，文件中有会话凭据，只有当前用户可读
+func (c *cookieStore) save(cookies []pget.Cookie) error {
+	if c.path == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := pget.WriteCookies(&buf, cookies); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// list 返回配置文件和上传的 cookie，值被隐去
+func (c *cookieStore) list() []pget.Cookie {
+	c.mu.Lock()
+	files := c.files
+	cookies := append([]pget.Cookie(nil), c.uploaded...)
+	c.mu.Unlock()
+	for _, f := range files {
+		loaded, err := readCookieFile(f)
+		if err != nil {
+			continue
+		}
+		cookies = mergeCookies(loaded, cookies)
+	}
+	for i := range cookies {
+		cookies[i].Value = ""
+	}
+	return cookies
+}
+
+// Cookies 返回从配置文件加载和通过 API 上传的 cookie，不包含值
+func (s *DownloadService) Cookies() []pget.Cookie {
+	return s.cookies.list()
+}
+
+// UploadCookies 解析 Netscape cookies.txt 并加入共享的 jar，返回加入的 cookie 数。
+// replace 为 true 时丢弃之前上传的 cookie 和响应中设置的 cookie。
+func (s *DownloadService) UploadCookies(r io.Reader, replace bool) (int, error) {
+	cookies, err := pget.ParseCookies(r)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.cookies.upload(cookies, replace); err != nil {
+		log.Println("save cookies failed:", err)
+		return 0, err
+	}
+	return len(cookies), nil
+}
+
+// RefreshCookies 重新读取配置中的 cookies.txt 并重建 jar，响应中设置的 cookie 被丢弃
+func (s *DownloadService) RefreshCookies() error {
+	return s.cookies.reload(s.config().CookieFiles)
+}
+
+func readCookieFile(path string) ([]pget.Cookie, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	cookies, err := pget.ParseCookies(f)
+	if err != nil {
+		return nil, errors.Wrap(err, path)
+	}
+	return cookies, nil
+}
+
+// mergeCookies 把 add 合并到 base，域名、路径和名称相同的 cookie 被替换
+func mergeCookies(base, add []pget.Cookie) []pget.Cookie {
+	type key struct{ domain, path, name string }
+	index := make(map[key]int, len(base))
+	merged := append([]pget.Cookie(nil), base...)
+	for i, c := range merged {
+		index[key{c.Domain, c.Path, c.Name}] = i
+	}
+	for _, c := range add {
+		k := key{c.Domain, c.Path, c.Name}
+		if i, ok := index[k]; ok {
+			merged[i] = c
+			continue
+		}
+		index[k] = len(merged)
+		merged = append(merged, c)
+	}
+	return merged
+}
@@ -0,0 +1,417 @@
+package service
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go-download/internal/core/types"
+	"go-download/internal/core/util/r"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// curl 中不影响下载的开关，解析时忽略
+var curlIgnoredFlags = map[string]bool{
+	"-L": true, "--location": true, "--compressed": true, "-s": true, "--silent": true,
+	"-S": true, "--show-error": true, "-v": true, "--verbose": true, "-f": true, "--fail": true,
+	"-g": true, "--globoff": true, "-O": true, "--remote-name": true, "-J": true,
+	"--remote-header-name": true, "-k": true, "--insecure": true, "-N": true, "--no-buffer": true,
+	"-#": true, "--progress-bar": true, "--http1.1": true, "--http2": true, "--http2-prior-knowledge": true,
+	"-i": true, "--include": true,
+}
+
+// curl 中带参数但不影响下载的选项，解析时连同参数一起忽略
+var curlIgnoredOptions = map[string]bool{
+	"-o": true, "--output": true, "--connect-timeout": true, "-m": true, "--max-time": true,
+	"--retry": true, "--max-redirs": true, "-w": true, "--write-out": true,
+}
+
+// curl 中不带参数的短选项，可以合写为 -sSL
+var curlShortFlags = "LsSvfgOJkNi#G"
+
+// ParseCurl 把 curl 命令解析为下载请求，支持 bash 的引号、$'...' 和续行。
+// 支持 URL、-H、-b、-X、-d 系列、-G、-A、-e、-x、-u 及认证方式选项，
+// 不会读取本地文件，-d @file 和 -b <file> 会返回错误。
+func ParseCurl(command string) (types.Request, error) {
+	var req types.Request
+	args, err := splitShell(command)
+	if err != nil {
+		return req, err
+	}
+	if len(args) > 0 && args[0] == "curl" {
+		args = args[1:]
+	}
+
+	var (
+		header  = http.Header{}
+		cookies []string
+		data    []string
+		get     bool
+		scheme  string
+	)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			if req.URL != "" {
+				return req, errors.Errorf("only one URL is supported, got %q", arg)
+			}
+			req.URL = arg
+			continue
+		}
+		if curlIgnoredFlags[arg] {
+			continue
+		}
+		name, value, attached := splitCurlOption(arg)
+		if name == "" {
+			// -sSL 这样合写的开关
+			if strings.Contains(arg[1:], "G") {
+				get = true
+			}
+			continue
+		}
+		switch name {
+		case "-G", "--get":
+			get = true
+			continue
+		case "--basic", "--digest", "--anyauth":
+			scheme = strings.TrimPrefix(name, "--")
+			continue
+		}
+		if !attached {
+			if i+1 >= len(args) {
+				return req, errors.Errorf("curl option %s requires a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		switch name {
+		case "--url":
+			req.URL = value
+		case "-H", "--header":
+			k, v, ok := strings.Cut(value, ":")
+			if !ok {
+				// curl 中 "Name;" 表示发送空的请求头
+				if k, ok = strings.CutSuffix(value, ";"); !ok {
+					return req, errors.Errorf("invalid header %q", value)
+				}
+			} else if v = strings.TrimSpace(v); v == "" {
+				// "Name:" 在 curl 中表示去掉该请求头
+				continue
+			}
+			k = strings.TrimSpace(k)
+			if strings.EqualFold(k, "Cookie") {
+				cookies = append(cookies, v)
+				continue
+			}
+			header.Add(k, v)
+		case "-b", "--cookie":
+			if !strings.Contains(value, "=") {
+				return req, errors.New("reading cookies from a file is not supported, upload it to /gd/cookies instead")
+			}
+			cookies = append(cookies, value)
+		case "-X", "--request":
+			req.Method = strings.ToUpper(value)
+		case "-d", "--data", "--data-ascii", "--data-binary":
+			if strings.HasPrefix(value, "@") {
+				return req, errors.New("reading request data from a file is not supported")
+			}
+			if name != "--data-binary" {
+				value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+			}
+			data = append(data, value)
+		case "--data-raw":
+			data = append(data, value)
+		case "--data-urlencode":
+			v, err := urlencodeCurlData(value)
+			if err != nil {
+				return req, err
+			}
+			data = append(data, v)
+		case "-A", "--user-agent":
+			header.Set("User-Agent", value)
+		case "-e", "--referer":
+			header.Set("Referer", strings.TrimSuffix(value, ";auto"))
+		case "-x", "--proxy":
+			if !strings.Contains(value, "://") {
+				// 与 curl 相同，没有协议时是 HTTP 代理
+				value = "http://" + value
+			}
+			req.ProxyUrl = value
+		case "-u", "--user":
+			username, password, ok := strings.Cut(value, ":")
+			if !ok {
+				// curl 会交互地询问密码，这里无法询问
+				return req, errors.Errorf("%s %s: password is required, use user:password", name, value)
+			}
+			if req.Auth == nil {
+				req.Auth = &types.Auth{}
+			}
+			req.Auth.Username, req.Auth.Password = username, password
+		case "--oauth2-bearer":
+			req.Auth = &types.Auth{Token: value}
+		default:
+			if curlIgnoredOptions[name] {
+				continue
+			}
+			return req, errors.Errorf("unsupported curl option %s", name)
+		}
+	}
+	if req.URL == "" {
+		return req, errors.New("no URL in curl command")
+	}
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return req, errors.Errorf("invalid URL %q", req.URL)
+	}
+	if req.Auth != nil && req.Auth.Token == "" && scheme != "anyauth" {
+		req.Auth.Scheme = scheme
+	}
+
+	if len(data) > 0 {
+		body := strings.Join(data, "&")
+		if get {
+			// -G 把数据作为查询参数
+			sep := "?"
+			if strings.Contains(req.URL, "?") {
+				sep = "&"
+			}
+			req.URL += sep + body
+		} else {
+			req.Body = body
+			if req.Method == "" {
+				req.Method = http.MethodPost
+			}
+			if header.Get("Content-Type") == "" {
+				header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+		}
+	}
+	if req.Method == http.MethodGet {
+		req.Method = ""
+	}
+	if len(cookies) > 0 {
+		header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+	if len(header) > 0 {
+		req.Headers = make(map[string]string, len(header))
+		for k, v := range header {
+			req.Headers[k] = strings.Join(v, ", ")
+		}
+	}
+	return req, nil
+}
+
+// splitCurlOption 拆分选项和紧跟的值，例如 -XPOST、-HAccept:*/*。
+// 合写的短开关返回空的 name。
+func splitCurlOption(arg string) (name, value string, attached bool) {
+	if strings.HasPrefix(arg, "--") || len(arg) == 2 {
+		return arg, "", false
+	}
+	if strings.Trim(arg[1:], curlShortFlags) == "" {
+		return "", "", false
+	}
+	return arg[:2], arg[2:], true
+}
+
+// urlencodeCurlData 按 curl --data-urlencode 的规则编码 content、=content 和 name=content
+func urlencodeCurlData(value string) (string, error) {
+	name, content, ok := strings.Cut(value, "=")
+	if !ok {
+		// @file 和 name@file 从文件读取
+		if strings.Contains(value, "@") {
+			return "", errors.New("reading request data from a file is not supported")
+		}
+		return url.QueryEscape(value), nil
+	}
+	if name == "" {
+		return url.QueryEscape(content), nil
+	}
+	return name + "=" + url.QueryEscape(content), nil
+}
+
+// splitShell 按 bash 的规则拆分命令行：单引号、双引号、$'...'、反斜杠转义和行尾的续行
+func splitShell(s string) ([]string, error) {
+	var (
+		args   []string
+		cur    strings.Builder
+		inWord bool
+		runes  = []rune(s)
+		n      = len(runes)
+		flush  = func() {
+			if inWord {
+				args = append(args, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		}
+	)
+	for i := 0; i < n; i++ {
+		c := runes[i]
+		switch {
+		case c == '\\' && i+1 < n && (runes[i+1] == '\n' || runes[i+1] == '\r'):
+			// 续行
+			i++
+			if runes[i] == '\r' && i+1 < n && runes[i+1] == '\n' {
+				i++
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			flush()
+		case c == '\\':
+			inWord = true
+			if i+1 < n {
+				i++
+				cur.WriteRune(runes[i])
+			}
+		case c == '\'':
+			inWord = true
+			end := indexRune(runes, i+1, '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			cur.WriteString(string(runes[i+1 : end]))
+			i = end
+		case c == '$' && i+1 < n && runes[i+1] == '\'':
+			inWord = true
+			end, err := ansiCQuote(runes, i+2, &cur)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		case c == '"':
+			inWord = true
+			i++
+			for ; i < n && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < n && strings.ContainsRune("\\\"$`\n", runes[i+1]) {
+					i++
+					if runes[i] == '\n' {
+						continue
+					}
+				}
+				cur.WriteRune(runes[i])
+			}
+			if i >= n {
+				return nil, errors.New("unterminated double quote")
+			}
+		default:
+			inWord = true
+			cur.WriteRune(c)
+		}
+	}
+	flush()
+	return args, nil
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// ansiCQuote 解码从 start 开始的 $'...' 内容，返回结束引号的位置
+func ansiCQuote(runes []rune, start int, out *strings.Builder) (int, error) {
+	simple := map[rune]string{'n': "\n", 't': "\t", 'r': "\r", '\\': "\\", '\'': "'", '"': "\"", 'a': "\a",
+		'b': "\b", 'e': "\x1b", 'f': "\f", 'v': "\v", '?': "?"}
+	for i := start; i < len(runes); i++ {
+		c := runes[i]
+		if c == '\'' {
+			return i, nil
+		}
+		if c != '\\' || i+1 >= len(runes) {
+			out.WriteRune(c)
+			continue
+		}
+		i++
+		if s, ok := simple[runes[i]]; ok {
+			out.WriteString(s)
+			continue
+		}
+		var digits, base, max int
+		switch runes[i] {
+		case 'x':
+			digits, base, max = 2, 16, 2
+		case 'u':
+			digits, base, max = 4, 16, 4
+		case 'U':
+			digits, base, max = 8, 16, 8
+		default:
+			if runes[i] >= '0' && runes[i] <= '7' {
+				i--
+				digits, base, max = 3, 8, 3
+			}
+		}
+		if digits == 0 {
+			out.WriteRune('\\')
+			out.WriteRune(runes[i])
+			continue
+		}
+		j := i + 1
+		for j < len(runes) && j-i-1 < max && isDigit(runes[j], base) {
+			j++
+		}
+		if j == i+1 {
+			out.WriteRune('\\')
+			out.WriteRune(runes[i])
+			continue
+		}
+		v, _ := strconv.ParseUint(string(runes[i+1:j]), base, 32)
+		if runes[i] == 'x' || base == 8 {
+			// \xHH 和八进制是字节，可能是 UTF-8 的一部分
+			out.WriteByte(byte(v))
+		} else if utf8.ValidRune(rune(v)) {
+			out.WriteRune(rune(v))
+		}
+		i = j - 1
+	}
+	return 0, errors.New("unterminated $' quote")
+}
+
+func isDigit(r rune, base int) bool {
+	if base == 8 {
+		return r >= '0' && r <= '7'
+	}
+	return (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
+
+// ImportCurl 解析 curl 命令并按正常流程创建任务，imp.Request 中非零的设置覆盖命令中的值
+func (s *DownloadService) ImportCurl(c *gin.Context, imp types.CurlImport) {
+	req, err := ParseCurl(imp.Command)
+	if err != nil {
+		r.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.DoDownload(c, overrideCurl(req, imp.Request))
+}
+
+// overrideCurl 用 o 中非零的设置覆盖从 curl 命令解析出的 req，o 中的镜像追加到 req 之后
+func overrideCurl(req, o types.Request) types.Request {
+	req.Mirrors = append(req.Mirrors, o.Mirrors...)
+	if o.DownloadPath != "" {
+		req.DownloadPath = o.DownloadPath
+	}
+	if o.ProxyUrl != "" {
+		req.ProxyUrl = o.ProxyUrl
+	}
+	if o.Procs != 0 {
+		req.Procs = o.Procs
+	}
+	if o.Checksum != "" {
+		req.Checksum = o.Checksum
+	}
+	if o.RateLimit != 0 {
+		req.RateLimit = o.RateLimit
+	}
+	if o.Priority != 0 {
+		req.Priority = o.Priority
+	}
+	if o.StartAfter != nil {
+		req.StartAfter = o.StartAfter
+	}
+	if o.Window != nil {
+		req.Window = o.Window
+	}
+	return req
+}
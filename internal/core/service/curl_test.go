@@ -0,0 +1,91 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-download/internal/core/types"
+)
+
+func TestParseCurl(t *testing.T) {
+	// Chrome 开发者工具 "Copy as cURL (bash)" 的格式
+	cmd := `curl 'https://files.example.com/export?id=7' \
+  -H 'accept: */*' \
+  -H 'cookie: sid=abc; theme=dark' \
+  -H $'x-note: it\'s é' \
+  -b 'extra=1' \
+  -A "Mozilla/5.0 \"test\"" \
+  -e https://example.com/page \
+  --data-raw 'a=1&b=2' \
+  -x 127.0.0.1:3128 \
+  -u bob:p:w --digest \
+  --compressed -sSL`
+	req, err := ParseCurl(cmd)
+	require.NoError(t, err)
+	assert.Equal(t, types.Request{
+		URL:      "https://files.example.com/export?id=7",
+		ProxyUrl: "http://127.0.0.1:3128",
+		Method:   "POST",
+		Body:     "a=1&b=2",
+		Auth:     &types.Auth{Scheme: "digest", Username: "bob", Password: "p:w"},
+		Headers: map[string]string{
+			"Accept":       "*/*",
+			"Cookie":       "sid=abc; theme=dark; extra=1",
+			"X-Note":       "it's é",
+			"User-Agent":   `Mozilla/5.0 "test"`,
+			"Referer":      "https://example.com/page",
+			"Content-Type": "application/x-www-form-urlencoded",
+		},
+	}, req)
+
+	// -G 把数据放到查询参数，-X GET 等同于默认方法
+	req, err = ParseCurl(`curl -G -X GET --data-urlencode 'q=a b' -d x=1 "http://example.com/s?p=1"`)
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/s?p=1&q=a+b&x=1", req.URL)
+	assert.Empty(t, req.Method)
+	assert.Empty(t, req.Body)
+
+	req, err = ParseCurl(`curl -XPUT -HContent-Type:application/json -d '{"a":1}' https://example.com/x`)
+	require.NoError(t, err)
+	assert.Equal(t, "PUT", req.Method)
+	assert.Equal(t, `{"a":1}`, req.Body)
+	assert.Equal(t, map[string]string{"Content-Type": "application/json"}, req.Headers)
+
+	for _, bad := range []string{
+		`curl`,
+		`curl ftp://example.com/a`,
+		`curl 'http://example.com/a`,
+		`curl -d @secrets.txt http://example.com/a`,
+		`curl -b cookies.txt http://example.com/a`,
+		`curl --data-urlencode name@file http://example.com/a`,
+		`curl --upload-file a http://example.com/a`,
+		`curl http://example.com/a http://example.com/b`,
+		`curl http://example.com/a -H`,
+		`curl -u bob http://example.com/a`,
+	} {
+		_, err := ParseCurl(bad)
+		assert.Error(t, err, bad)
+	}
+	assert.Error(t, validateRequest(types.Request{Method: "DELETE"}))
+}
+
+func TestOverrideCurl(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	req := types.Request{URL: "https://example.com/a", RateLimit: 1024, Priority: 3, StartAfter: &at, Procs: 4}
+
+	// 零值不覆盖命令中的设置
+	assert.Equal(t, req, overrideCurl(req, types.Request{}))
+
+	got := overrideCurl(req, types.Request{DownloadPath: "/data", RateLimit: 2048, Priority: -1, Mirrors: []string{"https://m.example.com/a"}})
+	assert.Equal(t, types.Request{
+		URL:          "https://example.com/a",
+		Mirrors:      []string{"https://m.example.com/a"},
+		DownloadPath: "/data",
+		RateLimit:    2048,
+		Priority:     -1,
+		StartAfter:   &at,
+		Procs:        4,
+	}, got)
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"go-download/internal/core/extract"
+	"go-download/internal/core/types"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// extractOption 返回任务的解压设置：任务中的设置优先，其次是第一条匹配的解压规则，不解压时返回 nil
+func extractOption(req types.Request, rules []types.ExtractRule, path string) *types.Extract {
+	if req.Extract != nil {
+		if !req.Extract.Enabled {
+			return nil
+		}
+		return req.Extract
+	}
+	// 规则按文件名匹配，不是压缩包时不解压；匹配到不支持的 7z 时在解压时报错
+	if !extract.Archive(path) {
+		return nil
+	}
+	var host string
+	if u, err := url.Parse(req.URL); err == nil {
+		host = u.Hostname()
+	}
+	for _, r := range rules {
+		if r.Match(host, filepath.Base(path)) {
+			return &types.Extract{Enabled: true, DeleteArchive: r.DeleteArchive}
+		}
+	}
+	return nil
+}
+
+// extractArchive 把下载完成的压缩包解压到同级目录，并通过 SSE 推送解压进度。
+// 不支持的格式（例如 7z）使任务失败；其它解压失败只记录在任务中，下载本身仍然成功；
+// 暂停或取消时返回 ctx 的错误。
+func (s *DownloadService) extractArchive(ctx context.Context, id string, opt *types.Extract, path string) error {
+	if err := extract.Check(path); err != nil {
+		log.Printf("extract failed, id: %s: %v\n", id, err)
+		s.tasks.update(id, func(t *Task) { t.Extraction = &extract.Progress{State: extract.StateFailed, Error: err.Error()} })
+		return err
+	}
+	dest := extract.Destination(path)
+	progress := func(p extract.Progress) {
+		s.tasks.update(id, func(t *Task) { t.Extraction = &p })
+		s.publish(id)
+	}
+	progress(extract.Progress{State: extract.StateExtracting, Dir: dest})
+
+	p, err := extract.Extract(ctx, path, dest, progress)
+	if err != nil {
+		if ctx.Err() != nil {
+			s.tasks.update(id, func(t *Task) { t.Extraction = nil })
+			return ctx.Err()
+		}
+		log.Printf("extract failed, id: %s: %v\n", id, err)
+		s.tasks.update(id, func(t *Task) { t.Extraction = &p })
+		return nil
+	}
+	log.Printf("extracted %d files, id: %s, dir: %s\n", p.Files, id, dest)
+	deleted := false
+	if opt.DeleteArchive {
+		if err := os.Remove(path); err != nil {
+			log.Printf("failed to delete archive %s: %v\n", path, err)
+		} else {
+			deleted = true
+		}
+	}
+	s.tasks.update(id, func(t *Task) {
+		t.Extraction = &p
+		// 压缩包已删除时任务的路径指向解压的目录
+		if deleted {
+			t.Path = dest
+		}
+	})
+	return nil
+}
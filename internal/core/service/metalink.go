@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"go-download/internal/core/types"
+	"go-download/internal/pget"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxMetalinkSize 是 Metalink 文件的最大字节数
+const maxMetalinkSize = 16 << 20
+
+// Metalink 解析 Metalink 并把其中的每个文件作为批量下载中的一项：第一个地址为主地址，
+// 其余为镜像，同时设置预期大小、摘要和分块摘要
+func (s *DownloadService) Metalink(imp types.MetalinkImport) (BatchResult, int, error) {
+	if (imp.URL == "") == (imp.Content == "") {
+		return BatchResult{}, http.StatusBadRequest, errors.New("exactly one of url and content is required")
+	}
+	content := imp.Content
+	if imp.URL != "" {
+		data, err := s.fetchFile(imp.URL, imp.Defaults, maxMetalinkSize)
+		if err != nil {
+			return BatchResult{}, http.StatusNotAcceptable, errors.Wrap(err, "failed to fetch metalink")
+		}
+		content = string(data)
+	}
+	if len(content) > maxMetalinkSize {
+		return BatchResult{}, http.StatusBadRequest, errors.Errorf("metalink is larger than %d bytes", maxMetalinkSize)
+	}
+	files, err := pget.ParseMetalink(strings.NewReader(content))
+	if err != nil {
+		return BatchResult{}, http.StatusBadRequest, err
+	}
+
+	batch := types.BatchRequest{Defaults: imp.Defaults}
+	for _, f := range files {
+		item := types.Request{
+			URL:      f.URLs[0],
+			Mirrors:  f.URLs[1:],
+			Checksum: f.Checksum,
+			Size:     f.Size,
+		}
+		if f.Pieces != nil {
+			item.Pieces = &types.Pieces{Algorithm: f.Pieces.Algorithm, Length: f.Pieces.Length, Hashes: f.Pieces.Hashes}
+		}
+		batch.Items = append(batch.Items, item)
+	}
+	log.Printf("metalink: %d files\n", len(files))
+	return s.Batch(batch)
+}
+
+// fetchFile 使用与下载相同的代理、Cookie 和凭据下载 Metalink、签名等小文件，
+// 多读一个字节，超出 limit 时由调用方报错
+func (s *DownloadService) fetchFile(url string, req types.Request, limit int64) ([]byte, error) {
+	cfg := s.config()
+	client, err := s.headClient(req, cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout.Duration)
+	defer cancel()
+	ctx = pget.ContextWithCredential(ctx, credential(req.Auth), url)
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range req.Headers {
+		r.Header.Set(name, value)
+	}
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %q", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, limit+1))
+}
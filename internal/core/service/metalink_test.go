@@ -0,0 +1,91 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-download/internal/core/sse"
+	"go-download/internal/core/types"
+)
+
+func TestMetalink(t *testing.T) {
+	data := bytes.Repeat([]byte("metalink"), 32*1024)
+	sum := sha256.Sum256(data)
+	var meta4 string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/file.meta4" {
+			w.Write([]byte(meta4))
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Now(), bytes.NewReader(data))
+	}))
+	t.Cleanup(ts.Close)
+
+	var pieces bytes.Buffer
+	const length = 64 * 1024
+	for off := 0; off < len(data); off += length {
+		s := sha256.Sum256(data[off:min(off+length, len(data))])
+		fmt.Fprintf(&pieces, "<hash>%x</hash>", s)
+	}
+	meta4 = fmt.Sprintf(`<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+  <file name="file.bin">
+    <size>%d</size>
+    <hash type="sha-256">%s</hash>
+    <pieces length="%d" type="sha-256">%s</pieces>
+    <url priority="1">%s/a/file.bin</url>
+    <url priority="2">%s/b/file.bin</url>
+  </file>
+</metalink>`, len(data), hex.EncodeToString(sum[:]), length, pieces.String(), ts.URL, ts.URL)
+
+	dir := t.TempDir()
+	s := NewDownloadService(sse.NewHub())
+	cfg := s.Settings()
+	cfg.AllowedRoots = []string{dir}
+	cfg.DownloadDir = dir
+	require.NoError(t, s.UpdateSettings(cfg))
+
+	res, _, err := s.Metalink(types.MetalinkImport{URL: ts.URL + "/file.meta4", Defaults: types.Request{DownloadPath: filepath.Join(dir, "url")}})
+	require.NoError(t, err)
+	require.Len(t, res.Items, 1)
+	require.Empty(t, res.Items[0].Error)
+	task, ok := s.Task(res.Items[0].ID)
+	require.True(t, ok)
+	assert.Equal(t, ts.URL+"/a/file.bin", task.URL)
+
+	res2, _, err := s.Metalink(types.MetalinkImport{Content: meta4, Defaults: types.Request{DownloadPath: filepath.Join(dir, "content")}})
+	require.NoError(t, err)
+
+	for _, r := range []BatchResult{res, res2} {
+		waitFor(t, "metalink group completed", func() bool {
+			g, err := s.Group(r.Group)
+			return err == nil && g.State == StateCompleted
+		})
+	}
+	for _, sub := range []string{"url", "content"} {
+		got, err := os.ReadFile(filepath.Join(dir, sub, "file.bin"))
+		require.NoError(t, err)
+		assert.Equal(t, data, got)
+	}
+
+	// 服务端给出的大小与 Metalink 不一致
+	_, code, err := s.Metalink(types.MetalinkImport{Content: fmt.Sprintf(`<metalink><file name="x"><size>1</size><url>%s/x</url></file></metalink>`, ts.URL)})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotAcceptable, code)
+
+	_, code, err = s.Metalink(types.MetalinkImport{})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code, err = s.Metalink(types.MetalinkImport{Content: "<metalink/>"})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
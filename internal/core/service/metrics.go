@@ -0,0 +1,99 @@
+package service
+
+import (
+	"go-download/internal/core/metrics"
+	"io"
+	"strconv"
+	"time"
+)
+
+// serviceMetrics 汇总服务层和 pget 下载循环的运行指标，同时实现 pget.Observer
+type serviceMetrics struct {
+	registry   *metrics.Registry
+	tasks      *metrics.Gauge
+	throughput *metrics.Gauge
+	bytes      *metrics.Counter
+	hostBytes  *metrics.Counter
+	retries    *metrics.Counter
+	responses  *metrics.Counter
+	merge      *metrics.Histogram
+
+	proxySpeed   *metrics.Gauge
+	proxyActive  *metrics.Gauge
+	proxyHealthy *metrics.Gauge
+}
+
+func newServiceMetrics() *serviceMetrics {
+	r := metrics.NewRegistry()
+	return &serviceMetrics{
+		registry:   r,
+		tasks:      r.Gauge("gd_tasks", "Number of tasks by state.", "state"),
+		throughput: r.Gauge("gd_throughput_bytes_per_second", "Current download throughput of all running tasks."),
+		bytes:      r.Counter("gd_downloaded_bytes_total", "Bytes downloaded by all tasks."),
+		hostBytes:  r.Counter("gd_host_downloaded_bytes_total", "Bytes downloaded per host.", "host"),
+		retries:    r.Counter("gd_segment_retries_total", "Segments retried on another mirror, per failing host.", "host"),
+		responses:  r.Counter("gd_http_responses_total", "HTTP responses received by segment requests, per status code.", "code"),
+		merge: r.Histogram("gd_merge_duration_seconds", "Time spent merging segments into the final file.",
+			[]float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60}),
+		proxySpeed:   r.Gauge("gd_proxy_throughput_bytes_per_second", "Smoothed throughput per pooled proxy.", "proxy"),
+		proxyActive:  r.Gauge("gd_proxy_active_connections", "Segment connections currently using each pooled proxy.", "proxy"),
+		proxyHealthy: r.Gauge("gd_proxy_healthy", "Whether each pooled proxy is in rotation (1) or evicted (0).", "proxy"),
+	}
+}
+
+func (m *serviceMetrics) Bytes(host string, n int64) {
+	m.bytes.Add(float64(n))
+	m.hostBytes.Add(float64(n), host)
+}
+
+func (m *serviceMetrics) Response(_ string, status int) {
+	m.responses.Inc(strconv.Itoa(status))
+}
+
+func (m *serviceMetrics) Retry(host string, _ error) {
+	m.retries.Inc(host)
+}
+
+func (m *serviceMetrics) Merged(d time.Duration) {
+	m.merge.Observe(d.Seconds())
+}
+
+// WriteMetrics 以 Prometheus 文本格式写出指标，任务数和吞吐在导出时计算
+func (s *DownloadService) WriteMetrics(w io.Writer) error {
+	counts := map[TaskState]int{
+		StateQueued:    0,
+		StateRunning:   0,
+		StatePaused:    0,
+		StateCompleted: 0,
+		StateFailed:    0,
+		StateCanceled:  0,
+	}
+	var speed int64
+	for _, t := range s.tasks.list() {
+		counts[t.State]++
+		if t.State == StateRunning {
+			speed += t.Speed
+		}
+	}
+	for state, n := range counts {
+		s.metrics.tasks.Set(float64(n), string(state))
+	}
+	s.metrics.throughput.Set(float64(speed))
+
+	// 代理池可能被重建，每次导出时重新生成代理的序列
+	s.metrics.proxySpeed.Reset()
+	s.metrics.proxyActive.Reset()
+	s.metrics.proxyHealthy.Reset()
+	for _, p := range s.Proxies() {
+		healthy := 0.0
+		if p.Healthy {
+			healthy = 1
+		}
+		s.metrics.proxySpeed.Set(float64(p.Speed), p.Proxy)
+		s.metrics.proxyActive.Set(float64(p.Active), p.Proxy)
+		s.metrics.proxyHealthy.Set(healthy, p.Proxy)
+	}
+
+	_, err := s.metrics.registry.WriteTo(w)
+	return err
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"github.com/goccy/go-json"
+	"go-download/internal/core/types"
+	"go-download/internal/pget"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// savedTask 是写入状态文件的任务记录，附带原始请求以便重启后续传
+type savedTask struct {
+	Task
+	Request types.Request `json:"request"`
+}
+
+// WithStateFile 启动时从 path 恢复任务，Shutdown 时把任务写回 path
+func WithStateFile(path string) Option {
+	return func(s *DownloadService) {
+		s.statePath = path
+	}
+}
+
+// restore 读取状态文件。退出前运行中的任务已被置为排队，按时间约束重新开始并从分段文件续传。
+func (s *DownloadService) restore() {
+	data, err := os.ReadFile(s.statePath)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Println("read task state failed:", err)
+		return
+	}
+	var saved []savedTask
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Println("parse task state failed:", err)
+		return
+	}
+	for _, st := range saved {
+		t := st.Task
+		t.req = st.Request
+		t.limiter = pget.NewLimiter(t.RateLimit)
+		t.Speed, t.Connections = 0, 0
+		if t.State == StateRunning {
+			t.State = StateQueued
+		}
+		s.tasks.restore(&t)
+		s.hub.NewTask(t.ID)
+	}
+	log.Printf("restored %d tasks from %s\n", len(saved), s.statePath)
+	s.admit()
+}
+
+// SaveTasks 原子地把所有任务写入状态文件
+func (s *DownloadService) SaveTasks() error {
+	if s.statePath == "" {
+		return nil
+	}
+	tasks := s.tasks.list()
+	saved := make([]savedTask, len(tasks))
+	for i, t := range tasks {
+		saved[i] = savedTask{Task: t, Request: t.req}
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.statePath), 0700); err != nil {
+		return err
+	}
+	tmp := s.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.statePath)
+}
+
+// Shutdown 中断运行中的任务（置为排队，下次启动时续传），等它们退出后保存任务状态
+func (s *DownloadService) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	var running []chan struct{}
+	for _, t := range s.tasks.list() {
+		if t.State != StateRunning {
+			continue
+		}
+		if err := s.stop(t.ID, StateQueued); err == nil && t.done != nil {
+			running = append(running, t.done)
+		}
+	}
+	for _, done := range running {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			log.Println("timed out waiting for downloads to stop")
+			return s.SaveTasks()
+		}
+	}
+	return s.SaveTasks()
+}
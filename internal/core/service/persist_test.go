@@ -0,0 +1,76 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-download/internal/core/sse"
+	"go-download/internal/core/types"
+)
+
+func TestShutdownAndRestore(t *testing.T) {
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(2)).Read(data)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "state.bin", time.Now(), bytes.NewReader(data))
+	}))
+	t.Cleanup(ts.Close)
+
+	dir := t.TempDir()
+	statePath := filepath.Join(t.TempDir(), "tasks.json")
+	newService := func() *DownloadService {
+		s := NewDownloadService(sse.NewHub(), WithStateFile(statePath))
+		cfg := s.Settings()
+		cfg.DownloadDir = dir
+		require.NoError(t, s.UpdateSettings(cfg))
+		return s
+	}
+
+	s := newService()
+	s.addTask("running", types.Request{URL: ts.URL + "/state.bin", DownloadPath: dir, RateLimit: 32 * 1024}, int64(len(data)), "")
+	s.addTask("paused", types.Request{URL: ts.URL + "/paused.bin", DownloadPath: dir, StartAfter: ptr(time.Now().Add(time.Hour))}, int64(len(data)), "")
+	_, err := s.Pause("paused")
+	require.NoError(t, err)
+	waitFor(t, "some progress", func() bool {
+		task, _ := s.Task("running")
+		return task.Downloaded > 0
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	task, _ := s.Task("running")
+	assert.Equal(t, StateQueued, task.State)
+	_, err = os.Stat(filepath.Join(dir, "_state.bin.4"))
+	assert.NoError(t, err, "partial files are kept for resuming")
+
+	// 重启后运行中的任务继续下载，手动暂停的保持暂停
+	restored := newService()
+	_, err = restored.UpdateTask("running", types.TaskPatch{RateLimit: new(int64)})
+	require.NoError(t, err)
+	waitFor(t, "completion after restart", func() bool {
+		task, _ := restored.Task("running")
+		return task.State == StateCompleted
+	})
+	got, err := os.ReadFile(filepath.Join(dir, "state.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	paused, ok := restored.Task("paused")
+	require.True(t, ok)
+	assert.Equal(t, StatePaused, paused.State)
+	assert.Equal(t, ts.URL+"/paused.bin", paused.req.URL)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
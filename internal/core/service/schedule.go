@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"go-download/internal/core/types"
+	"log"
+	"sort"
+	"time"
+)
+
+// 调度器检查时间窗口的间隔
+const scheduleInterval = time.Second
+
+// Clock 抽象时间来源，测试时可以替换
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// WithClock 替换调度使用的时钟
+func WithClock(clock Clock) Option {
+	return func(s *DownloadService) {
+		s.clock = clock
+	}
+}
+
+// allowed 判断任务在 now 时刻是否允许下载
+func allowed(t *Task, now time.Time) bool {
+	if t.StartAfter != nil && now.Before(*t.StartAfter) {
+		return false
+	}
+	if t.Window != nil && !t.Window.Contains(now) {
+		return false
+	}
+	return true
+}
+
+// Schedule 返回每周限速计划
+func (s *DownloadService) Schedule() []types.SpeedProfile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]types.SpeedProfile(nil), s.profiles...)
+}
+
+// SetSchedule 替换每周限速计划，按顺序匹配，第一条命中的生效
+func (s *DownloadService) SetSchedule(profiles []types.SpeedProfile) error {
+	if err := types.ValidateSchedule(profiles); err != nil {
+		return err
+	}
+	if s.store != nil {
+		cfg := s.store.Get()
+		cfg.Schedule = append([]types.SpeedProfile(nil), profiles...)
+		return s.store.Update(cfg)
+	}
+	s.mu.Lock()
+	s.profiles = append([]types.SpeedProfile(nil), profiles...)
+	s.mu.Unlock()
+	s.applyLimits(s.clock.Now())
+	return nil
+}
+
+// applyLimits 根据限速计划计算当前生效的全局限速
+func (s *DownloadService) applyLimits(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rate, name := s.baseRate, ""
+	for i := range s.profiles {
+		if s.profiles[i].Contains(now) {
+			rate, name = s.profiles[i].Limit, s.profiles[i].Name
+			break
+		}
+	}
+	if name != s.profile {
+		log.Printf("speed profile changed: %q -> %q, rate limit %d\n", s.profile, name, rate)
+	}
+	s.profile = name
+	s.limiter.SetRate(rate)
+}
+
+// RunScheduler 周期性地在时间窗口边界暂停、恢复任务并切换限速计划，直到 ctx 结束
+func (s *DownloadService) RunScheduler(ctx context.Context) {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		s.schedule()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// schedule 执行一次调度
+func (s *DownloadService) schedule() {
+	now := s.clock.Now()
+	s.applyLimits(now)
+
+	for _, t := range s.tasks.list() {
+		if t.State == StateRunning && !allowed(&t, now) {
+			log.Println("time window closed, pause task:", t.ID)
+			s.stop(t.ID, StateQueued)
+		}
+	}
+	s.admit()
+}
+
+// admit 按优先级（相同时先创建的优先）启动可以开始的排队任务，
+// 同时运行的任务数不超过 MaxActive。已经运行的任务不会被抢占。
+func (s *DownloadService) admit() {
+	s.admitMu.Lock()
+	defer s.admitMu.Unlock()
+	s.mu.Lock()
+	closed, max := s.closed, s.cfg.MaxActive
+	s.mu.Unlock()
+	if closed {
+		return
+	}
+
+	now := s.clock.Now()
+	tasks := s.tasks.list()
+	running := 0
+	for _, t := range tasks {
+		if t.State == StateRunning {
+			running++
+		}
+	}
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].Priority > tasks[j].Priority
+	})
+	for i := range tasks {
+		t := &tasks[i]
+		if t.State != StateQueued || !allowed(t, now) {
+			continue
+		}
+		if max > 0 && running >= max {
+			return
+		}
+		if t.StartAfter != nil || t.Window != nil {
+			log.Println("time window opened, start task:", t.ID)
+		}
+		s.launch(t.ID)
+		running++
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-download/internal/core/sse"
+	"go-download/internal/core/types"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// 2024-01-01 是周一
+func at(day int, clock string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", "2024-01-01 "+clock, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return t.AddDate(0, 0, day)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	night := &types.TimeWindow{Start: "01:00", End: "07:00"}
+	overnight := &types.TimeWindow{Start: "22:00", End: "06:00", Days: []time.Weekday{time.Friday}}
+	require.NoError(t, night.Validate())
+	require.NoError(t, overnight.Validate())
+
+	cases := []struct {
+		window *types.TimeWindow
+		at     time.Time
+		want   bool
+	}{
+		{night, at(0, "00:30"), false},
+		{night, at(0, "01:00"), true},
+		{night, at(0, "06:59"), true},
+		{night, at(0, "07:00"), false},
+		{overnight, at(4, "23:00"), true},  // 周五晚上
+		{overnight, at(5, "03:00"), true},  // 周五开始的窗口延续到周六凌晨
+		{overnight, at(5, "23:00"), false}, // 周六开始的窗口不在计划内
+		{overnight, at(4, "03:00"), false}, // 周四开始的窗口不在计划内
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, tc.window.Contains(tc.at), "%s-%s at %s", tc.window.Start, tc.window.End, tc.at)
+	}
+
+	assert.Error(t, (&types.TimeWindow{Start: "25:00", End: "07:00"}).Validate())
+	assert.Error(t, (&types.TimeWindow{Start: "07:00", End: "07:00"}).Validate())
+}
+
+func TestScheduleSpeedProfiles(t *testing.T) {
+	clock := &fakeClock{now: at(0, "10:00")}
+	s := NewDownloadService(sse.NewHub(), WithClock(clock), WithGlobalRateLimit(512*1024))
+
+	workdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	require.NoError(t, s.SetSchedule([]types.SpeedProfile{
+		{Name: "work", TimeWindow: types.TimeWindow{Start: "09:00", End: "18:00", Days: workdays}, Limit: 2 << 20},
+		{Name: "night", TimeWindow: types.TimeWindow{Start: "23:00", End: "07:00"}, Limit: 0},
+	}))
+
+	assert.Equal(t, types.Limits{Global: 512 * 1024, Effective: 2 << 20, Profile: "work"}, s.Limits())
+
+	clock.Set(at(0, "23:30"))
+	s.schedule()
+	assert.Equal(t, types.Limits{Global: 512 * 1024, Effective: 0, Profile: "night"}, s.Limits())
+
+	// 周六白天不命中任何计划，回到基础限速
+	clock.Set(at(5, "10:00"))
+	s.schedule()
+	assert.Equal(t, types.Limits{Global: 512 * 1024, Effective: 512 * 1024}, s.Limits())
+}
+
+func TestScheduleTaskWindow(t *testing.T) {
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "night.bin", time.Now(), bytes.NewReader(data))
+	}))
+	t.Cleanup(ts.Close)
+
+	clock := &fakeClock{now: at(0, "00:30")}
+	s := NewDownloadService(sse.NewHub(), WithClock(clock))
+	dir := t.TempDir()
+	cfg := s.Settings()
+	cfg.DownloadDir = dir
+	require.NoError(t, s.UpdateSettings(cfg))
+
+	task := s.addTask("night", types.Request{
+		URL:          ts.URL + "/night.bin",
+		DownloadPath: dir,
+		RateLimit:    32 * 1024,
+		Window:       &types.TimeWindow{Start: "01:00", End: "07:00"},
+	}, int64(len(data)), "")
+	assert.Equal(t, StateQueued, task.State)
+
+	s.schedule()
+	task, _ = s.Task("night")
+	assert.Equal(t, StateQueued, task.State, "must not start before the window opens")
+
+	clock.Set(at(0, "01:00"))
+	s.schedule()
+	waitFor(t, "some progress", func() bool {
+		task, _ := s.Task("night")
+		return task.State == StateRunning && task.Downloaded > 0
+	})
+
+	clock.Set(at(0, "07:00"))
+	s.schedule()
+	task, _ = s.Task("night")
+	assert.Equal(t, StateQueued, task.State, "must pause when the window closes")
+
+	clock.Set(at(1, "01:00"))
+	s.schedule()
+	_, err := s.UpdateTask("night", types.TaskPatch{RateLimit: new(int64)})
+	require.NoError(t, err)
+	waitFor(t, "completion", func() bool {
+		task, _ := s.Task("night")
+		return task.State == StateCompleted
+	})
+
+	task, _ = s.Task("night")
+	assert.Equal(t, filepath.Join(dir, "night.bin"), task.Path)
+	got, err := os.ReadFile(task.Path)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	var metrics strings.Builder
+	require.NoError(t, s.WriteMetrics(&metrics))
+	assert.Contains(t, metrics.String(), `gd_tasks{state="completed"} 1`)
+	assert.Contains(t, metrics.String(), fmt.Sprintf("gd_downloaded_bytes_total %d\n", len(data)))
+	assert.Contains(t, metrics.String(), `gd_http_responses_total{code="206"}`)
+	assert.Contains(t, metrics.String(), "gd_merge_duration_seconds_count 1\n")
+}
+
+func TestMaxActivePriority(t *testing.T) {
+	data := make([]byte, 256*1024)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "slow.bin", time.Now(), bytes.NewReader(data))
+	}))
+	t.Cleanup(ts.Close)
+
+	s := NewDownloadService(sse.NewHub())
+	dir := t.TempDir()
+	cfg := s.Settings()
+	cfg.DownloadDir = dir
+	cfg.MaxActive = 1
+	require.NoError(t, s.UpdateSettings(cfg))
+
+	add := func(id string, priority int) Task {
+		return s.addTask(id, types.Request{
+			URL:          ts.URL + "/" + id,
+			DownloadPath: filepath.Join(dir, id),
+			RateLimit:    1024,
+			Priority:     priority,
+		}, int64(len(data)), "")
+	}
+	state := func(id string) TaskState {
+		task, _ := s.Task(id)
+		return task.State
+	}
+	assert.Equal(t, StateRunning, add("a", 0).State)
+	assert.Equal(t, StateQueued, add("b", 0).State)
+	assert.Equal(t, StateQueued, add("c", 5).State)
+
+	// 调高优先级不会抢占正在运行的任务
+	_, err := s.UpdateTask("b", types.TaskPatch{Priority: ptr(10)})
+	require.NoError(t, err)
+	assert.Equal(t, StateRunning, state("a"))
+	assert.Equal(t, StateQueued, state("b"))
+
+	_, err = s.Pause("a")
+	require.NoError(t, err)
+	assert.Equal(t, StateRunning, state("b"))
+	assert.Equal(t, StateQueued, state("c"))
+
+	_, err = s.Cancel("b")
+	require.NoError(t, err)
+	assert.Equal(t, StateRunning, state("c"))
+
+	// 恢复的任务在没有名额时排队
+	task, err := s.Resume("a")
+	require.NoError(t, err)
+	assert.Equal(t, StateQueued, task.State)
+
+	require.NoError(t, s.Shutdown(context.Background()))
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/goccy/go-json"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"go-download/internal/core/config"
 	"go-download/internal/core/sse"
 	"go-download/internal/core/types"
 	"go-download/internal/core/util"
@@ -14,95 +15,875 @@ import (
 	"go-download/internal/pget"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 // DownloadService 把下载相关逻辑封装到结构体
 type DownloadService struct {
-	hub *sse.Hub
+	hub     *sse.Hub
+	tasks   *taskStore
+	limiter *pget.Limiter // 全局限速，所有任务的所有连接共享
+	clock   Clock
+	metrics *serviceMetrics
+	store   *config.Store // 为 nil 时使用默认配置且不持久化
+	cookies *cookieStore  // 所有任务共享的 cookie
+
+	statePath string // 保存任务状态的文件，为空时不持久化
+	keyring   string // 信任的公钥所在目录，校验签名时读取
+
+	admitMu sync.Mutex // 保证同时只有一次 admit，避免超出 MaxActive
+
+	mu       sync.Mutex
+	closed   bool // Shutdown 之后不再启动新的任务
+	cfg      config.Config
+	pool     *pget.ProxyPool      // 由 cfg.ProxyPool 创建，为 nil 时不使用代理池
+	baseRate int64                // 未命中限速计划时的全局限速
+	profiles []types.SpeedProfile // 每周限速计划
+	profile  string               // 当前命中的计划名
+}
+
+type Option func(s *DownloadService)
+
+// WithGlobalRateLimit 设置初始的全局限速，单位 bytes/s
+func WithGlobalRateLimit(rate int64) Option {
+	return func(s *DownloadService) {
+		s.baseRate = rate
+	}
+}
+
+// WithConfig 从配置文件读取默认值，配置变化时（包括文件被外部修改）立即生效
+func WithConfig(store *config.Store) Option {
+	return func(s *DownloadService) {
+		s.store = store
+	}
+}
+
+func NewDownloadService(hub *sse.Hub, opts ...Option) *DownloadService {
+	s := &DownloadService{
+		hub:     hub,
+		tasks:   newTaskStore(),
+		limiter: pget.NewLimiter(0),
+		clock:   realClock{},
+		metrics: newServiceMetrics(),
+		cfg:     config.Default(),
+		cookies: newCookieStore(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.cookies.restore()
+	if s.store != nil {
+		s.applyConfig(s.store.Get())
+		s.store.OnChange(s.applyConfig)
+	}
+	s.applyLimits(s.clock.Now())
+	if s.statePath != "" {
+		s.restore()
+	}
+	return s
+}
+
+// applyConfig 应用新的配置，限速和限速计划立即生效，其余设置对之后的下载生效
+func (s *DownloadService) applyConfig(cfg config.Config) {
+	s.mu.Lock()
+	if cfg.Listen != s.cfg.Listen && s.cfg.Listen != "" {
+		log.Printf("listen address changed to %s, restart to take effect\n", cfg.Listen)
+	}
+	if !reflect.DeepEqual(cfg.ProxyPool, s.cfg.ProxyPool) || cfg.PoolPolicy != s.cfg.PoolPolicy ||
+		!reflect.DeepEqual(cfg.ProxyRules, s.cfg.ProxyRules) || !reflect.DeepEqual(cfg.TLS, s.cfg.TLS) {
+		s.pool = nil
+		if len(cfg.ProxyPool) > 0 {
+			// 配置已经校验过，这里只会因为证书文件在校验之后被修改而失败
+			pool, err := pget.NewProxyPool(cfg.ProxyPool, cfg.PoolPolicy, s.transportConfig(cfg, ""))
+			if err != nil {
+				log.Println("invalid proxy pool:", err)
+			}
+			s.pool = pool
+		}
+	}
+	cookiesChanged := !reflect.DeepEqual(cfg.CookieFiles, s.cfg.CookieFiles)
+	s.cfg = cfg
+	s.baseRate = cfg.RateLimit
+	s.profiles = cfg.Schedule
+	s.mu.Unlock()
+	if cookiesChanged {
+		_ = s.cookies.reload(cfg.CookieFiles)
+	}
+	s.applyLimits(s.clock.Now())
+}
+
+// config 返回当前配置
+func (s *DownloadService) config() config.Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg := s.cfg
+	cfg.AllowedOrigins = append([]string(nil), s.cfg.AllowedOrigins...)
+	cfg.AllowedRoots = append([]string(nil), s.cfg.AllowedRoots...)
+	cfg.ProxyRules = append([]pget.ProxyRule(nil), s.cfg.ProxyRules...)
+	cfg.ProxyPool = append([]string(nil), s.cfg.ProxyPool...)
+	cfg.TLS = append([]pget.TLSConfig(nil), s.cfg.TLS...)
+	cfg.CookieFiles = append([]string(nil), s.cfg.CookieFiles...)
+	return cfg
+}
+
+// transportConfig 返回经过 proxy 的连接设置，代理规则、TLS 设置和凭据来自配置，cookie 来自共享的 jar
+func (s *DownloadService) transportConfig(cfg config.Config, proxy string) pget.TransportConfig {
+	return pget.TransportConfig{
+		Proxy:       proxy,
+		ProxyRules:  cfg.ProxyRules,
+		TLS:         cfg.TLS,
+		Credentials: cfg.Credentials,
+		Jar:         s.cookies,
+	}
+}
+
+// credential 把任务的认证凭据转换成 pget 的凭据，没有凭据时为 nil
+func credential(auth *types.Auth) *pget.Credential {
+	if auth == nil {
+		return nil
+	}
+	return &pget.Credential{
+		Scheme:   pget.AuthScheme(auth.Scheme),
+		Username: auth.Username,
+		Password: auth.Password,
+		Token:    auth.Token,
+	}
+}
+
+// moveURLCredential 把下载地址中的 user:pass@ 移到 Auth，镜像地址中的移到 MirrorAuth，
+// 避免凭据出现在任务列表和日志中。凭据按域名区分，同一域名的地址带有不同的凭据时返回错误。
+func moveURLCredential(req *types.Request) error {
+	var host string
+	if u, err := url.Parse(req.URL); err == nil {
+		host = u.Hostname()
+		if u.User != nil {
+			if req.Auth == nil {
+				req.Auth = userAuth(u.User)
+			}
+			u.User = nil
+			req.URL = u.String()
+		}
+	}
+	mirrors := append([]string(nil), req.Mirrors...)
+	hosts := map[string]*types.Auth{}
+	for i, m := range mirrors {
+		u, err := url.Parse(m)
+		if err != nil || u.User == nil {
+			continue
+		}
+		auth := userAuth(u.User)
+		u.User = nil
+		// 与主地址同一域名时使用主地址的凭据
+		if u.Hostname() == host {
+			if req.Auth == nil || *req.Auth != *auth {
+				return errors.Errorf("mirror %s has different credentials than %s on the same host", u, req.URL)
+			}
+		} else if prev, ok := hosts[u.Hostname()]; ok {
+			if *prev != *auth {
+				return errors.Errorf("mirror %s has different credentials than another mirror on the same host", u)
+			}
+		} else {
+			hosts[u.Hostname()] = auth
+			if req.MirrorAuth == nil {
+				req.MirrorAuth = make(map[string]*types.Auth)
+			}
+			if _, ok := req.MirrorAuth[u.Host]; !ok {
+				req.MirrorAuth[u.Host] = auth
+			}
+		}
+		mirrors[i] = u.String()
+		// 不修改调用方共用的切片，例如批量任务的 Defaults
+		req.Mirrors = mirrors
+	}
+	return nil
+}
+
+func userAuth(user *url.Userinfo) *types.Auth {
+	password, _ := user.Password()
+	return &types.Auth{Username: user.Username(), Password: password}
+}
+
+// withMirrorAuth 让发往镜像的请求使用镜像地址中的凭据，优先于任务的 Auth
+func withMirrorAuth(ctx context.Context, req types.Request) context.Context {
+	for host, auth := range req.MirrorAuth {
+		ctx = pget.ContextWithCredential(ctx, credential(auth), "//"+host)
+	}
+	return ctx
+}
+
+// proxyPool 返回当前的代理池，没有配置时为 nil
+func (s *DownloadService) proxyPool() *pget.ProxyPool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pool
+}
+
+// Proxies 返回代理池中每个代理的统计，没有配置代理池时为空
+func (s *DownloadService) Proxies() []pget.ProxyStat {
+	pool := s.proxyPool()
+	if pool == nil {
+		return []pget.ProxyStat{}
+	}
+	return pool.Stats()
+}
+
+// Settings 返回当前设置
+func (s *DownloadService) Settings() config.Config {
+	if s.store != nil {
+		return s.store.Get()
+	}
+	return s.config()
+}
+
+// TrustOrigin 把 origin 加入允许访问本地 API 的列表
+func (s *DownloadService) TrustOrigin(origin string) error {
+	cfg := s.Settings()
+	for _, o := range cfg.AllowedOrigins {
+		if o == origin {
+			return nil
+		}
+	}
+	cfg.AllowedOrigins = append(cfg.AllowedOrigins, origin)
+	log.Println("trust origin:", origin)
+	return s.UpdateSettings(cfg)
+}
+
+// UpdateSettings 校验并保存设置，所有客户端共享同一份配置文件
+func (s *DownloadService) UpdateSettings(cfg config.Config) error {
+	if s.store != nil {
+		return s.store.Update(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	s.applyConfig(cfg)
+	return nil
+}
+
+// Tasks 返回所有任务
+func (s *DownloadService) Tasks() []Task {
+	return s.tasks.list()
+}
+
+// Task 返回指定任务
+func (s *DownloadService) Task(id string) (Task, bool) {
+	return s.tasks.get(id)
+}
+
+// UpdateTask 修改任务参数，限速调整对正在进行的下载立即生效
+func (s *DownloadService) UpdateTask(id string, patch types.TaskPatch) (Task, error) {
+	if patch.RateLimit != nil && *patch.RateLimit < 0 {
+		return Task{}, errors.New("rate limit must not be negative")
+	}
+	ok := s.tasks.update(id, func(t *Task) {
+		if patch.RateLimit != nil {
+			t.RateLimit = *patch.RateLimit
+			t.limiter.SetRate(t.RateLimit)
+		}
+		if patch.Priority != nil {
+			t.Priority = *patch.Priority
+			t.req.Priority = t.Priority
+		}
+	})
+	if !ok {
+		return Task{}, ErrTaskNotFound
+	}
+	if patch.Priority != nil {
+		s.admit()
+	}
+	task, _ := s.tasks.get(id)
+	return task, nil
+}
+
+// Limits 返回当前的全局限速
+func (s *DownloadService) Limits() types.Limits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return types.Limits{
+		Global:    s.baseRate,
+		Effective: s.limiter.Rate(),
+		Profile:   s.profile,
+	}
 }
 
-func NewDownloadService(hub *sse.Hub) *DownloadService {
-	return &DownloadService{hub: hub}
+// SetLimits 修改全局限速，对正在进行的下载立即生效；限速计划命中时以计划为准
+func (s *DownloadService) SetLimits(limits types.Limits) error {
+	if limits.Global < 0 {
+		return errors.New("rate limit must not be negative")
+	}
+	log.Println("global rate limit set to", limits.Global)
+	if s.store != nil {
+		cfg := s.store.Get()
+		cfg.RateLimit = limits.Global
+		return s.store.Update(cfg)
+	}
+	s.mu.Lock()
+	s.baseRate = limits.Global
+	s.mu.Unlock()
+	s.applyLimits(s.clock.Now())
+	return nil
 }
 
 func (s *DownloadService) DoDownload(c *gin.Context, req types.Request) {
+	task, code, err := s.createTask(req, "")
+	if err != nil {
+		r.Error(c, code, err.Error())
+		return
+	}
+	// 3. 马上返回成功
+	r.Success(c, gin.H{
+		"id":    task.ID,
+		"size":  task.Total,
+		"state": task.State,
+	})
+}
+
+// createTask 校验请求、查询文件大小并登记任务，失败时返回对应的 HTTP 状态码
+func (s *DownloadService) createTask(req types.Request, group string) (Task, int, error) {
+	if req.RateLimit < 0 {
+		return Task{}, http.StatusBadRequest, errors.New("rate limit must not be negative")
+	}
+	if err := req.Window.Validate(); err != nil {
+		return Task{}, http.StatusBadRequest, err
+	}
+	if err := moveURLCredential(&req); err != nil {
+		return Task{}, http.StatusBadRequest, err
+	}
+	if err := validateRequest(req); err != nil {
+		return Task{}, http.StatusBadRequest, err
+	}
+	cfg := s.config()
+	// 固定连接数，之后修改配置也不会打乱已有分段的续传
+	if req.Procs == 0 {
+		req.Procs = cfg.Connections
+	}
+	if req.DownloadPath == "" {
+		req.DownloadPath = cfg.DownloadDir
+	}
+	dir, err := util.ResolveWithin(req.DownloadPath, cfg.Roots())
+	if err != nil {
+		log.Println("rejected download path:", err)
+		return Task{}, http.StatusForbidden, err
+	}
+	// 目录必须存在，否则 pget 会把最后一级当作文件名
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Task{}, http.StatusInternalServerError, err
+	}
+	req.DownloadPath = dir
+
 	id := uuid.New().String()
 	s.hub.NewTask(id) // 同步注册任务，避免竞态
 	log.Println("start download, id:", id)
 
-	res, err := doHeadRequest(req)
+	client, err := s.headClient(req, cfg)
 	if err != nil {
-		r.Error(c, http.StatusNotAcceptable, err.Error())
+		return Task{}, http.StatusBadRequest, err
+	}
+	target, err := probe(req, client, cfg.Timeout.Duration)
+	if err != nil {
+		return Task{}, http.StatusNotAcceptable, err
+	}
+	size := target.ContentLength
+	if req.Size > 0 && size != req.Size {
+		return Task{}, http.StatusNotAcceptable, errors.Errorf("expected %d bytes, but the server reports %d", req.Size, size)
+	}
+	advertised, from := adoptAdvertised(&req, target)
+	if req.Checksum == "" && (req.AutoVerify != nil && *req.AutoVerify || req.AutoVerify == nil && cfg.AutoVerify) {
+		from = findChecksum(&req, client, target, cfg.Timeout.Duration)
+	}
+	task := s.addTask(id, req, size, group)
+	if from != "" || advertised != nil {
+		s.tasks.update(id, func(t *Task) { t.ChecksumFrom, t.Advertised = from, advertised })
+		task.ChecksumFrom, task.Advertised = from, advertised
+	}
+	return task, 0, nil
+}
+
+// validateRequest 检查请求中的下载参数
+func validateRequest(req types.Request) error {
+	if req.Procs < 0 || req.Procs > 16 {
+		return errors.New("procs must be between 1 and 16")
+	}
+	for name := range req.Headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			return errors.Errorf("invalid header name %q", name)
+		}
+	}
+	if req.Checksum != "" {
+		if err := pget.ValidateChecksum(req.Checksum); err != nil {
+			return err
+		}
+	}
+	if req.ProxyUrl != "" {
+		if _, err := pget.ParseProxy(req.ProxyUrl); err != nil {
+			return err
+		}
+	}
+	if cred := credential(req.Auth); cred != nil {
+		if err := cred.Validate(); err != nil {
+			return err
+		}
+	}
+	for host, auth := range req.MirrorAuth {
+		if cred := credential(auth); cred != nil {
+			if err := cred.Validate(); err != nil {
+				return errors.Wrapf(err, "mirror %s", host)
+			}
+		}
+	}
+	switch req.Method {
+	case "", http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return errors.Errorf("unsupported method %q", req.Method)
+	}
+	if req.Size < 0 {
+		return errors.New("size must not be negative")
+	}
+	if p := pieces(req.Pieces); p != nil {
+		if err := p.Validate(req.Size); err != nil {
+			return err
+		}
+	}
+	if sig := req.Signature; sig != nil {
+		if sig.URL != "" && sig.Content != "" {
+			return errors.New("signature url and content cannot be used together")
+		}
+		if u, err := url.Parse(sig.URL); sig.URL != "" && (err != nil || u.Scheme != "http" && u.Scheme != "https") {
+			return errors.Errorf("invalid signature url %q", sig.URL)
+		}
+	}
+	return nil
+}
+
+// pieces 把分块摘要转换为 pget 的类型
+func pieces(p *types.Pieces) *pget.PieceHashes {
+	if p == nil {
+		return nil
+	}
+	return &pget.PieceHashes{Algorithm: p.Algorithm, Length: p.Length, Hashes: p.Hashes}
+}
+
+// body 把请求体转换为 pget 的请求体，空字符串表示没有请求体
+func body(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	return []byte(s)
+}
+
+// addTask 登记任务，时间约束允许时立即开始，否则排队等待调度器。group 为空时不属于任何批次。
+func (s *DownloadService) addTask(id string, req types.Request, size int64, group string) Task {
+	s.tasks.add(id, req)
+	s.tasks.update(id, func(t *Task) {
+		t.Total = size
+		t.Group = group
+	})
+	s.admit()
+	t, _ := s.tasks.get(id)
+	if t.State == StateQueued {
+		log.Println("task queued, id:", id)
+	}
+	return t
+}
+
+// Pause 手动暂停任务，已下载的分段保留在磁盘上
+func (s *DownloadService) Pause(id string) (Task, error) {
+	if err := s.stop(id, StatePaused); err != nil {
+		return Task{}, err
+	}
+	s.admit()
+	t, _ := s.tasks.get(id)
+	return t, nil
+}
+
+// Resume 恢复暂停或失败的任务；不在时间窗口内或没有空闲名额时转为排队
+func (s *DownloadService) Resume(id string) (Task, error) {
+	task, ok := s.tasks.get(id)
+	if !ok {
+		return Task{}, ErrTaskNotFound
+	}
+	switch task.State {
+	case StatePaused, StateFailed, StateQueued:
+	default:
+		return Task{}, errors.Errorf("cannot resume a %s task", task.State)
+	}
+	s.tasks.update(id, func(t *Task) {
+		t.State = StateQueued
+		t.Error = ""
+	})
+	s.admit()
+	t, _ := s.tasks.get(id)
+	if t.State == StateQueued {
+		s.publish(id)
+	}
+	return t, nil
+}
+
+// Cancel 取消未完成的任务并删除已下载的分段
+func (s *DownloadService) Cancel(id string) (Task, error) {
+	var (
+		err  error
+		done chan struct{}
+		path string
+	)
+	ok := s.tasks.update(id, func(t *Task) {
+		if t.State == StateCompleted || t.State == StateCanceled {
+			err = errors.Errorf("cannot cancel a %s task", t.State)
+			return
+		}
+		t.State = StateCanceled
+		t.Speed = 0
+		t.Connections = 0
+		t.ETA = 0
+		if t.cancel != nil {
+			t.cancel()
+		}
+		done, path = t.done, t.Path
+	})
+	if !ok {
+		return Task{}, ErrTaskNotFound
+	}
+	if err != nil {
+		return Task{}, err
+	}
+	log.Println("task canceled, id:", id)
+	s.publish(id)
+	s.admit()
+
+	// 等本次运行退出后再删除分段，避免和写入冲突
+	go func() {
+		if done != nil {
+			<-done
+		}
+		removePartials(path)
+	}()
+	t, _ := s.tasks.get(id)
+	return t, nil
+}
+
+// removePartials 删除 path 对应的分段目录（pget 的 _<name>.<procs>）
+func removePartials(path string) {
+	if path == "" {
+		return
+	}
+	dir, prefix := filepath.Dir(path), "_"+filepath.Base(path)+"."
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		suffix, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || !e.IsDir() {
+			continue
+		}
+		if _, err := strconv.Atoi(suffix); err != nil {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			log.Println("remove partial files failed:", err)
+		}
+	}
+}
+
+// stop 中断排队或运行中的任务并置为 state
+func (s *DownloadService) stop(id string, state TaskState) error {
+	var err error
+	ok := s.tasks.update(id, func(t *Task) {
+		if t.State != StateRunning && t.State != StateQueued {
+			err = errors.Errorf("cannot pause a %s task", t.State)
+			return
+		}
+		t.State = state
+		t.Speed = 0
+		t.Connections = 0
+		if t.cancel != nil {
+			t.cancel()
+		}
+	})
+	if !ok {
+		return ErrTaskNotFound
+	}
+	if err == nil {
+		s.publish(id)
+	}
+	return err
+}
+
+// launch 启动（或续传）排队中的任务。新的运行会等上一次运行完全退出后才开始，
+// 避免两次运行同时写同一组分段文件。
+func (s *DownloadService) launch(id string) {
+	var (
+		ctx     context.Context
+		prev    chan struct{}
+		done    = make(chan struct{})
+		run     int
+		req     types.Request
+		limiter *pget.Limiter
+	)
+	started := false
+	s.tasks.update(id, func(t *Task) {
+		// admit 之后任务可能已被暂停或取消
+		if t.State != StateQueued {
+			return
+		}
+		started = true
+		ctx, t.cancel = context.WithCancel(context.Background())
+		prev, t.done = t.done, done
+		t.run++
+		run = t.run
+		t.State = StateRunning
+		t.Error = ""
+		t.Signature, t.Extraction = nil, nil
+		req, limiter = t.req, t.limiter
+	})
+	if !started {
 		return
 	}
+
 	// 2. 异步调用 pget
-	go func(url string) {
-		cli := pget.New()
-		cli.ProgressFn = func(downloaded, total, speed int64) {
-			//percent := int(float64(downloaded) / float64(total) * 100)
-			s.hub.Publish(id, sse.Progress{
-				Downloaded: downloaded,
-				Total:      total,
-				Speed:      speed,
-			})
-		}
-		ags := util.ToPgetArgs(url, req)
-		if err := cli.Run(context.Background(), types.Version, ags); err != nil {
-			if cli.Trace {
-				fmt.Fprintf(os.Stderr, "Error:\n%+v\n", err)
+	go func() {
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		err := s.download(ctx, id, req, limiter)
+		s.tasks.update(id, func(t *Task) {
+			// 已被暂停或重新启动，状态由对方负责
+			if t.run != run || t.State != StateRunning {
+				return
+			}
+			t.Speed = 0
+			t.Connections = 0
+			if err != nil {
+				t.State = StateFailed
+				t.Error = err.Error()
 			} else {
-				fmt.Fprintf(os.Stderr, "Error:\n  %v\n", err)
+				t.State = StateCompleted
+				t.ETA = 0
+			}
+		})
+		s.publish(id)
+		// 空出了名额，启动下一个排队的任务
+		s.admit()
+	}()
+}
+
+// publish 把任务的最新状态推送给 SSE 订阅者
+func (s *DownloadService) publish(id string) {
+	if t, ok := s.tasks.get(id); ok {
+		s.hub.Publish(id, t.progress())
+	}
+}
+
+// download 执行一次下载，已存在的分段文件会被续传
+func (s *DownloadService) download(ctx context.Context, id string, req types.Request, limiter *pget.Limiter) error {
+	// 允许的目录可能已经修改，或者目录被替换成了符号链接，每次运行前重新检查
+	cfg := s.config()
+	if _, err := util.ResolveWithin(req.DownloadPath, cfg.Roots()); err != nil {
+		return err
+	}
+	// 任务指定的代理优先，其次是代理池，最后是默认代理；代理规则对三者都生效
+	proxy := req.ProxyUrl
+	var pool *pget.ProxyPool
+	if proxy == "" {
+		proxy = cfg.Proxy
+		pool = s.proxyPool()
+	}
+	procs := req.Procs
+	if procs <= 0 {
+		procs = cfg.Connections
+	}
+	output := req.DownloadPath
+	if output == "" {
+		output = cfg.DownloadDir
+	}
+	header := make(http.Header, len(req.Headers))
+	for name, value := range req.Headers {
+		header.Set(name, value)
+	}
+
+	events := make(chan pget.Event)
+	client, err := pget.NewClient(
+		pget.WithProxy(proxy),
+		pget.WithProxyRules(cfg.ProxyRules),
+		pget.WithTLS(cfg.TLS),
+		pget.WithCredentials(cfg.Credentials),
+		pget.WithCookieJar(s.cookies),
+		pget.WithProxyPool(pool),
+		pget.WithTimeout(cfg.Timeout.Duration),
+		pget.WithAgent("Pget/"+types.Version),
+		pget.WithEvents(events),
+		pget.WithDownloadOptions(pget.WithObserver(s.metrics)),
+	)
+	if err != nil {
+		return err
+	}
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		for ev := range events {
+			s.handleEvent(id, ev)
+		}
+	}()
+	res, err := client.Download(withMirrorAuth(ctx, req), &pget.Request{
+		URLs:     append([]string{req.URL}, req.Mirrors...),
+		Output:   output,
+		Procs:    procs,
+		Header:   header,
+		Checksum: req.Checksum,
+		Limiters: []*pget.Limiter{s.limiter, limiter},
+		Auth:     credential(req.Auth),
+		Method:   req.Method,
+		Body:     body(req.Body),
+		Size:     req.Size,
+		Pieces:   pieces(req.Pieces),
+	})
+	close(events)
+	<-handled
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("download failed, id: %s: %v\n", id, err)
+		}
+		return err
+	}
+	s.tasks.update(id, func(t *Task) {
+		t.Path = res.Path
+		if res.Checksum != "" {
+			t.Checksum, t.Verified = res.Checksum, true
+			if res.ChecksumFrom != "" {
+				t.ChecksumFrom = res.ChecksumFrom
 			}
 		}
-	}(req.URL)
+	})
+	if req.Signature != nil {
+		if err := s.verifySignature(id, req, res.Path); err != nil {
+			return err
+		}
+	}
+	if opt := extractOption(req, cfg.ExtractRules, res.Path); opt != nil {
+		return s.extractArchive(ctx, id, opt, res.Path)
+	}
+	return nil
+}
 
-	// 3. 马上返回成功
-	r.Success(c, gin.H{
-		"id":   id,
-		"size": res.ContentLength,
+// handleEvent 把 pget 的下载事件同步到任务并推送给订阅者
+func (s *DownloadService) handleEvent(id string, ev pget.Event) {
+	p := ev.Progress
+	s.tasks.update(id, func(t *Task) {
+		t.Path = p.Path
+		t.Total = p.Total
+		if ev.Type != pget.EventProgress {
+			return
+		}
+		t.Downloaded = p.Downloaded
+		t.Speed = p.Speed
+		t.ETA = p.ETA
+		t.Connections = p.Connections
+		t.Segments = p.Segments
+		t.MirrorStats = p.Mirrors
 	})
+	s.publish(id)
 }
 
-func doHeadRequest(req types.Request) (*http.Response, error) {
-	// 查询文件大小
-	client := pget.NewClientByProxy(16, req.ProxyUrl)
-	r, err := http.NewRequest("HEAD", req.URL, nil)
-	if err != nil {
-		log.Println("new request failed:", err)
+// headClient 按与下载相同的优先级选择探测请求使用的代理
+func (s *DownloadService) headClient(req types.Request, cfg config.Config) (*http.Client, error) {
+	if req.ProxyUrl == "" {
+		if pool := s.proxyPool(); pool != nil {
+			return pool.Client(), nil
+		}
+		return pget.NewHTTPClient(16, s.transportConfig(cfg, cfg.Proxy))
+	}
+	return pget.NewHTTPClient(16, s.transportConfig(cfg, req.ProxyUrl))
+}
+
+// probe 探测主地址的文件大小和服务端给出的镜像、摘要，与下载使用相同的请求方法、请求头和凭据
+func probe(req types.Request, client *http.Client, timeout time.Duration) (*pget.Target, error) {
+	ctx := pget.ContextWithCredential(context.Background(), credential(req.Auth), req.URL)
+	header := make(http.Header, len(req.Headers))
+	for name, value := range req.Headers {
+		header.Set(name, value)
 	}
-	res, err := client.Do(r)
+	target, err := pget.Check(ctx, &pget.CheckConfig{
+		URLs:    []string{req.URL},
+		Timeout: timeout,
+		Client:  client,
+		Header:  header,
+		Method:  req.Method,
+		Body:    body(req.Body),
+	})
 	if err != nil {
-		log.Println("failed to head request:", err)
-		return res, err
+		log.Println("probe failed:", err)
+		return nil, err
 	}
+	return target, nil
+}
 
-	if res.Header.Get("Accept-Ranges") != "bytes" {
-		return res, errors.New("does not support range request")
+// adoptAdvertised 没有指定摘要时使用服务端在响应头中给出的摘要，返回服务端通过 Link 头给出的镜像
+// 和摘要来自哪个响应头。镜像可能在第三方的域名，不加入 req.Mirrors，下载时重新探测，
+// 不带任务的凭据和请求头
+func adoptAdvertised(req *types.Request, target *pget.Target) ([]string, string) {
+	known := map[string]bool{req.URL: true}
+	for _, m := range req.Mirrors {
+		known[m] = true
 	}
-	if res.ContentLength <= 0 {
-		return res, errors.New("invalid content length")
+	var advertised []string
+	for _, m := range target.Discovered {
+		if !known[m] {
+			known[m] = true
+			advertised = append(advertised, m)
+			log.Println("found advertised mirror:", m)
+		}
 	}
-	return res, nil
+	if req.Checksum != "" || target.Checksum == "" {
+		return advertised, ""
+	}
+	req.Checksum = target.Checksum
+	log.Printf("using checksum from the %s header: %s\n", target.ChecksumFrom, target.Checksum)
+	return advertised, target.ChecksumFrom
 }
 
-const throttleInterval = 100 * time.Millisecond
+// findChecksum 在同目录下查找校验文件，找到时设置 req.Checksum 并返回校验文件的地址
+func findChecksum(req *types.Request, client *http.Client, target *pget.Target, timeout time.Duration) string {
+	ctx := pget.ContextWithCredential(context.Background(), credential(req.Auth), req.URL)
+	header := make(http.Header, len(req.Headers))
+	for name, value := range req.Headers {
+		header.Set(name, value)
+	}
+	sum, from, err := pget.FindChecksum(ctx, &pget.CheckConfig{
+		URLs:    []string{req.URL},
+		Timeout: timeout,
+		Client:  client,
+		Header:  header,
+	}, target.Filename)
+	if err != nil {
+		log.Printf("failed to look for checksum files of %s: %v\n", req.URL, err)
+		return ""
+	}
+	if sum == "" {
+		log.Println("no checksum file found for", req.URL)
+		return ""
+	}
+	req.Checksum = sum
+	return from
+}
 
 func (s *DownloadService) SSEConnect(c *gin.Context, id string) {
 	ch := s.hub.Subscribe(id)
 	defer s.hub.Unsubscribe(id, ch)
 
-	// SSE headers
-	c.Writer.Header().Set("Content-Type", "text/event-stream")
-	c.Writer.Header().Set("Cache-Control", "no-cache")
-	c.Writer.Header().Set("Connection", "keep-alive")
+	setSSEHeaders(c)
 
-	// 用 ticker 做节流，间隔 50ms
-	throttle := time.NewTicker(throttleInterval)
+	// 用 ticker 做节流，间隔取自配置
+	throttle := time.NewTicker(s.config().SSEThrottle.Duration)
 	defer throttle.Stop()
 
 	// 缓存最近接收到但还未发送的进度
@@ -112,20 +893,16 @@ func (s *DownloadService) SSEConnect(c *gin.Context, id string) {
 	ctx := c.Request.Context()
 
 	// helper: 立即发送一次数据
-	send := func(p sse.Progress) {
-		data, err := json.Marshal(p)
-		if err != nil {
-			log.Println("marshal progress failed:", err)
-			return
-		}
-		_, err = fmt.Fprintf(c.Writer, "data: %s\n\n", data)
-		if err != nil {
-			log.Println("send progress failed:", err)
+	send := func(p sse.Progress) { sendEvent(c, p) }
+
+	// 先发送一次当前状态，任务已经结束时直接返回
+	task, ok := s.tasks.get(id)
+	if ok {
+		lastProg, pending = task.progress(), true
+		if task.Terminal() {
+			send(lastProg)
 			return
 		}
-		if f, ok := c.Writer.(http.Flusher); ok {
-			f.Flush()
-		}
 	}
 
 	for {
@@ -142,14 +919,10 @@ func (s *DownloadService) SSEConnect(c *gin.Context, id string) {
 				return
 			}
 			// 收到新的进度，缓存起来（不立即发送，等待 ticker）
-			if prog.Speed > 0 {
-				lastProg = prog
-			} else {
-				lastProg.Downloaded = prog.Downloaded
-				lastProg.Total = prog.Total
-			}
+			lastProg = prog
 			pending = true
-			if lastProg.Downloaded >= lastProg.Total {
+			// 任务结束（完成、失败或取消）时立即发送并关闭连接
+			if t := (Task{State: TaskState(prog.State)}); t.Terminal() {
 				send(lastProg)
 				log.Println("download finished, id:", id)
 				return
@@ -164,17 +937,110 @@ func (s *DownloadService) SSEConnect(c *gin.Context, id string) {
 	}
 }
 
+// EventsConnect 推送所有任务的进度事件，每个任务按配置的间隔节流，状态变化立即发送
+func (s *DownloadService) EventsConnect(c *gin.Context) {
+	ch := s.hub.SubscribeAll()
+	defer s.hub.UnsubscribeAll(ch)
+
+	setSSEHeaders(c)
+	// 没有任务在下载时也让客户端立即确认连接成功
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+
+	throttle := time.NewTicker(s.config().SSEThrottle.Duration)
+	defer throttle.Stop()
+
+	// 每个任务最近一次还未发送的进度，以及最近发送的状态
+	pending := make(map[string]sse.Progress)
+	states := make(map[string]string)
+
+	ctx := c.Request.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case prog := <-ch:
+			if prog.State != states[prog.ID] {
+				states[prog.ID] = prog.State
+				delete(pending, prog.ID)
+				sendEvent(c, prog)
+				continue
+			}
+			pending[prog.ID] = prog
+		case <-throttle.C:
+			for id, prog := range pending {
+				sendEvent(c, prog)
+				delete(pending, id)
+			}
+		}
+	}
+}
+
+func setSSEHeaders(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+}
+
+// sendEvent 立即发送一条 SSE 事件
+func sendEvent(c *gin.Context, p sse.Progress) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		log.Println("marshal progress failed:", err)
+		return
+	}
+	if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", data); err != nil {
+		log.Println("send progress failed:", err)
+		return
+	}
+	if f, ok := c.Writer.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// OpenTask 在文件管理器中显示已完成任务下载的文件
+func (s *DownloadService) OpenTask(id string) error {
+	task, ok := s.tasks.get(id)
+	if !ok {
+		return ErrTaskNotFound
+	}
+	if task.State != StateCompleted || task.Path == "" {
+		return errors.Errorf("task %s has not completed", id)
+	}
+	return s.OpenInFileManager(task.Path)
+}
+
+// revealable 只允许显示本服务下载完成的文件或它们所在的目录
+func (s *DownloadService) revealable(p string) bool {
+	for _, t := range s.tasks.list() {
+		if t.State != StateCompleted || t.Path == "" {
+			continue
+		}
+		file, err := util.Canonical(t.Path)
+		if err != nil {
+			continue
+		}
+		if p == file || p == filepath.Dir(file) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *DownloadService) OpenInFileManager(path string) error {
 	if path == "" {
 		return fmt.Errorf("empty path")
 	}
 
-	// 绝对化并清理路径
-	p, err := filepath.Abs(path)
+	// 绝对化、清理路径并解析符号链接
+	p, err := util.Canonical(path)
 	if err != nil {
 		return err
 	}
-	p = filepath.Clean(p)
+	if !s.revealable(p) {
+		log.Println("rejected open-dir for a path not downloaded by go-download:", path)
+		return fmt.Errorf("%s was not downloaded by go-download", path)
+	}
 
 	// 检查是否存在
 	fi, err := os.Stat(p)
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"github.com/pkg/errors"
+	"go-download/internal/core/signature"
+	"go-download/internal/core/types"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// maxSignatureSize 是签名文件的最大字节数
+	maxSignatureSize = 64 << 10
+	// quarantineDir 是签名校验失败的文件所在的目录，位于下载目录中
+	quarantineDir = ".quarantine"
+)
+
+// signatureSuffixes 是未指定签名地址时依次尝试的后缀
+var signatureSuffixes = []string{".minisig", ".asc", ".sig"}
+
+// WithKeyring 设置信任的公钥所在的目录，每次校验签名时重新读取，新增的公钥无需重启
+func WithKeyring(dir string) Option {
+	return func(s *DownloadService) {
+		s.keyring = dir
+	}
+}
+
+// verifySignature 校验下载完成的文件的分离签名并记录结果，失败时把文件移入隔离目录
+func (s *DownloadService) verifySignature(id string, req types.Request, path string) error {
+	res, err := s.checkSignature(req, path)
+	if err == nil {
+		log.Printf("signature verified, id: %s, format: %s, signer: %s\n", id, res.Format, res.Signer)
+		s.tasks.update(id, func(t *Task) { t.Signature = &res })
+		return nil
+	}
+	res.Verified, res.Error = false, err.Error()
+	quarantined, qerr := quarantine(path)
+	if qerr != nil {
+		log.Printf("failed to quarantine %s: %v\n", path, qerr)
+	} else {
+		log.Printf("signature verification failed, id: %s, quarantined to %s: %v\n", id, quarantined, err)
+	}
+	s.tasks.update(id, func(t *Task) {
+		t.Signature = &res
+		if quarantined != "" {
+			t.Path = quarantined
+		}
+	})
+	return errors.Wrap(err, "signature verification failed")
+}
+
+func (s *DownloadService) checkSignature(req types.Request, path string) (signature.Result, error) {
+	sig, err := s.signature(req)
+	if err != nil {
+		return signature.Result{}, err
+	}
+	keyring, err := signature.LoadKeyring(s.keyring)
+	if err != nil {
+		return signature.Result{}, errors.Wrap(err, "failed to load keyring")
+	}
+	return keyring.Verify(path, sig)
+}
+
+// signature 返回请求中的签名：直接给出的内容、指定的地址，或者依次尝试下载地址加上
+// .minisig、.asc、.sig 后缀
+func (s *DownloadService) signature(req types.Request) ([]byte, error) {
+	if req.Signature.Content != "" {
+		return []byte(req.Signature.Content), nil
+	}
+	urls := []string{req.Signature.URL}
+	if req.Signature.URL == "" {
+		urls = siblingSignatures(req.URL)
+	}
+	var lastErr error
+	for _, u := range urls {
+		data, err := s.fetchFile(u, req, maxSignatureSize)
+		if err == nil && len(data) > maxSignatureSize {
+			err = errors.New("signature file is too large")
+		}
+		if err == nil {
+			return data, nil
+		}
+		lastErr = errors.Wrapf(err, "failed to fetch signature %s", u)
+	}
+	if lastErr == nil {
+		lastErr = errors.New("cannot guess the signature url")
+	}
+	return nil, lastErr
+}
+
+// siblingSignatures 返回下载地址加上签名后缀的地址，去掉查询参数
+func siblingSignatures(raw string) []string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return nil
+	}
+	var urls []string
+	for _, suffix := range signatureSuffixes {
+		v := *u
+		v.RawQuery, v.Fragment, v.RawPath = "", "", ""
+		v.Path += suffix
+		urls = append(urls, v.String())
+	}
+	return urls
+}
+
+// quarantine 把文件移入所在目录下的 .quarantine 目录并去掉执行权限，返回新的路径
+func quarantine(path string) (string, error) {
+	dir := filepath.Join(filepath.Dir(path), quarantineDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(dir, filepath.Base(path))
+	if err := os.Rename(path, dst); err != nil {
+		return "", err
+	}
+	return dst, os.Chmod(dst, 0600)
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"go-download/internal/core/extract"
+	"go-download/internal/core/signature"
+	"go-download/internal/core/sse"
+	"go-download/internal/core/types"
+	"go-download/internal/pget"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrTaskNotFound = errors.New("task not found")
+
+type TaskState string
+
+const (
+	StateQueued    TaskState = "queued" // 等待时间窗口或空闲的下载名额
+	StateRunning   TaskState = "running"
+	StatePaused    TaskState = "paused" // 手动暂停，调度器不会自动恢复
+	StateCompleted TaskState = "completed"
+	StateFailed    TaskState = "failed"
+	StateCanceled  TaskState = "canceled" // 已取消，分段文件已删除
+)
+
+// Task 记录一个下载任务的当前状态，供 /gd/tasks 查询
+type Task struct {
+	ID           string                 `json:"id"`
+	Group        string                 `json:"group,omitempty"` // 批量下载的分组 id
+	URL          string                 `json:"url"`
+	Mirrors      []string               `json:"mirrors,omitempty"`
+	Advertised   []string               `json:"advertised,omitempty"` // 服务端通过 Link 头给出的镜像，不带任务的凭据和请求头
+	DownloadPath string                 `json:"downloadPath"`
+	Path         string                 `json:"path,omitempty"` // 下载完成后的文件路径
+	State        TaskState              `json:"state"`
+	Error        string                 `json:"error,omitempty"`
+	Total        int64                  `json:"total"`
+	Downloaded   int64                  `json:"downloaded"`
+	Speed        int64                  `json:"speed"`
+	ETA          int64                  `json:"eta"`
+	Connections  int                    `json:"connections"`
+	RateLimit    int64                  `json:"rateLimit"`
+	Priority     int                    `json:"priority"`
+	StartAfter   *time.Time             `json:"startAfter,omitempty"`
+	Window       *types.TimeWindow      `json:"window,omitempty"`
+	Checksum     string                 `json:"checksum,omitempty"`
+	ChecksumFrom string                 `json:"checksumFrom,omitempty"` // 摘要来自服务端的哪个响应头或校验文件的地址，为空时来自请求
+	Verified     bool                   `json:"verified,omitempty"`     // 下载完成后摘要校验通过
+	Signature    *signature.Result      `json:"signature,omitempty"`    // 签名校验的结果
+	Extraction   *extract.Progress      `json:"extraction,omitempty"`   // 解压的进度和结果
+	Segments     []pget.SegmentProgress `json:"segments,omitempty"`
+	MirrorStats  []pget.MirrorStat      `json:"mirrorStats,omitempty"`
+	CreatedAt    time.Time              `json:"createdAt"`
+	UpdatedAt    time.Time              `json:"updatedAt"`
+
+	req     types.Request
+	limiter *pget.Limiter // 任务限速器，调整 RateLimit 时同步修改
+
+	// 每次启动（含恢复）都是一次新的运行
+	run    int
+	cancel context.CancelFunc
+	done   chan struct{} // 本次运行完全退出后关闭
+}
+
+// Terminal 任务是否已经结束
+func (t *Task) Terminal() bool {
+	return t.State == StateCompleted || t.State == StateFailed || t.State == StateCanceled
+}
+
+// progress 生成推送给 SSE 订阅者的事件
+func (t *Task) progress() sse.Progress {
+	return sse.Progress{
+		ID:    t.ID,
+		Group: t.Group,
+		Progress: pget.Progress{
+			Path:        t.Path,
+			Downloaded:  t.Downloaded,
+			Total:       t.Total,
+			Speed:       t.Speed,
+			ETA:         t.ETA,
+			Connections: t.Connections,
+			Segments:    t.Segments,
+			Mirrors:     t.MirrorStats,
+		},
+		State:      string(t.State),
+		Error:      t.Error,
+		Signature:  t.Signature,
+		Extraction: t.Extraction,
+	}
+}
+
+// taskStore 保存所有任务，所有读写都经过锁
+type taskStore struct {
+	mu    sync.RWMutex
+	tasks map[string]*Task
+}
+
+func newTaskStore() *taskStore {
+	return &taskStore{tasks: make(map[string]*Task)}
+}
+
+func (ts *taskStore) add(id string, req types.Request) *Task {
+	now := time.Now()
+	t := &Task{
+		ID:           id,
+		URL:          req.URL,
+		Mirrors:      req.Mirrors,
+		DownloadPath: req.DownloadPath,
+		State:        StateQueued,
+		RateLimit:    req.RateLimit,
+		Priority:     req.Priority,
+		StartAfter:   req.StartAfter,
+		Window:       req.Window,
+		Checksum:     req.Checksum,
+		req:          req,
+		limiter:      pget.NewLimiter(req.RateLimit),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.tasks[id] = t
+	return t
+}
+
+// restore 放回从状态文件读取的任务
+func (ts *taskStore) restore(t *Task) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.tasks[t.ID] = t
+}
+
+// update 在锁内修改任务
+func (ts *taskStore) update(id string, fn func(t *Task)) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	t, ok := ts.tasks[id]
+	if ok {
+		fn(t)
+		t.UpdatedAt = time.Now()
+	}
+	return ok
+}
+
+// get 返回任务的副本
+func (ts *taskStore) get(id string) (Task, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	t, ok := ts.tasks[id]
+	if !ok {
+		return Task{}, false
+	}
+	return *t, true
+}
+
+// list 按创建时间返回所有任务的副本
+func (ts *taskStore) list() []Task {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	list := make([]Task, 0, len(ts.tasks))
+	for _, t := range ts.tasks {
+		list = append(list, *t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
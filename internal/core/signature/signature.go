@@ -0,0 +1,312 @@
+// Package signature 用本地信任的公钥校验下载文件的分离签名，支持 OpenPGP（.asc、.sig）、
+// minisign（.minisig）和 cosign 风格的 ed25519 签名（base64 编码的原始签名）。
+package signature
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	FormatOpenPGP  = "openpgp"
+	FormatMinisign = "minisign"
+	FormatEd25519  = "ed25519"
+)
+
+const (
+	maxKeySize = 1 << 20 // 公钥文件的最大字节数
+	// 非预哈希的 minisign 和 ed25519 签名需要把整个文件读入内存
+	maxPureSize = 512 << 20
+)
+
+var (
+	ErrNoKeys        = errors.New("no trusted public keys")
+	ErrUnknownFormat = errors.New("unrecognized signature format")
+	ErrBadSignature  = errors.New("signature does not match any trusted key")
+)
+
+// Result 是签名校验的结果
+type Result struct {
+	Format   string `json:"format,omitempty"` // openpgp、minisign 或 ed25519
+	Signer   string `json:"signer,omitempty"` // OpenPGP 的用户 ID 或公钥文件名
+	Verified bool   `json:"verified"`
+	Error    string `json:"error,omitempty"`
+}
+
+type minisignKey struct {
+	name string
+	id   [8]byte
+	key  ed25519.PublicKey
+}
+
+type ed25519Key struct {
+	name string
+	key  ed25519.PublicKey
+}
+
+// Keyring 是信任的公钥
+type Keyring struct {
+	pgp      openpgp.EntityList
+	minisign []minisignKey
+	ed25519  []ed25519Key
+}
+
+// LoadKeyring 读取目录中的所有公钥：OpenPGP 公钥（armored 或二进制）、minisign 公钥
+// 和 PEM 格式的 ed25519 公钥。目录不存在时返回空的公钥集合，无法识别的文件被忽略。
+func LoadKeyring(dir string) (*Keyring, error) {
+	k := &Keyring{}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if err := k.load(filepath.Join(dir, e.Name())); err != nil {
+			log.Printf("skip public key %s: %v", e.Name(), err)
+		}
+	}
+	return k, nil
+}
+
+// Len 返回公钥的数量
+func (k *Keyring) Len() int {
+	return len(k.pgp) + len(k.minisign) + len(k.ed25519)
+}
+
+func (k *Keyring) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxKeySize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxKeySize {
+		return errors.New("file is too large")
+	}
+	name := filepath.Base(path)
+	text := bytes.TrimSpace(data)
+
+	switch {
+	case bytes.Contains(text, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")):
+		keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(text))
+		if err != nil {
+			return err
+		}
+		k.pgp = append(k.pgp, keys...)
+	case bytes.HasPrefix(text, []byte("-----BEGIN PUBLIC KEY-----")):
+		block, _ := pem.Decode(text)
+		if block == nil {
+			return errors.New("invalid PEM")
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return errors.Errorf("unsupported public key type %T", pub)
+		}
+		k.ed25519 = append(k.ed25519, ed25519Key{name: name, key: key})
+	case len(text) > 0 && text[0]&0x80 != 0:
+		keys, err := openpgp.ReadKeyRing(bytes.NewReader(text))
+		if err != nil {
+			return err
+		}
+		k.pgp = append(k.pgp, keys...)
+	default:
+		key, err := parseMinisignKey(text)
+		if err != nil {
+			return err
+		}
+		key.name = name
+		k.minisign = append(k.minisign, key)
+	}
+	return nil
+}
+
+// parseMinisignKey 解析 minisign 公钥：可选的 "untrusted comment:" 行和 base64 编码的
+// "Ed" + 8 字节密钥 id + 32 字节公钥
+func parseMinisignKey(text []byte) (minisignKey, error) {
+	lines := strings.Split(strings.TrimSpace(string(text)), "\n")
+	if strings.HasPrefix(lines[0], "untrusted comment:") {
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		return minisignKey{}, errors.New("unrecognized public key")
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[0]))
+	if err != nil || len(b) != 2+8+ed25519.PublicKeySize || string(b[:2]) != "Ed" {
+		return minisignKey{}, errors.New("unrecognized public key")
+	}
+	key := minisignKey{key: ed25519.PublicKey(b[10:])}
+	copy(key.id[:], b[2:10])
+	return key, nil
+}
+
+// Detect 判断签名的格式，无法识别时返回空字符串
+func Detect(sig []byte) string {
+	text := bytes.TrimSpace(sig)
+	switch {
+	case bytes.HasPrefix(text, []byte("untrusted comment:")):
+		return FormatMinisign
+	case bytes.Contains(text, []byte("-----BEGIN PGP SIGNATURE-----")):
+		return FormatOpenPGP
+	case len(text) > 0 && text[0]&0x80 != 0:
+		return FormatOpenPGP // 二进制的 .sig
+	}
+	if b, err := base64.StdEncoding.DecodeString(string(text)); err == nil && len(b) == ed25519.SignatureSize {
+		return FormatEd25519
+	}
+	return ""
+}
+
+// Verify 用信任的公钥校验文件的分离签名。校验失败时 Result.Error 记录原因，同时返回错误。
+func (k *Keyring) Verify(path string, sig []byte) (Result, error) {
+	res := Result{Format: Detect(sig)}
+	var err error
+	switch {
+	case res.Format == "":
+		err = ErrUnknownFormat
+	case k.Len() == 0:
+		err = ErrNoKeys
+	case res.Format == FormatOpenPGP:
+		res.Signer, err = k.verifyOpenPGP(path, sig)
+	case res.Format == FormatMinisign:
+		res.Signer, err = k.verifyMinisign(path, sig)
+	default:
+		res.Signer, err = k.verifyEd25519(path, sig)
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return res, err
+	}
+	res.Verified = true
+	return res, nil
+}
+
+func (k *Keyring) verifyOpenPGP(path string, sig []byte) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var signer *openpgp.Entity
+	if bytes.Contains(sig, []byte("-----BEGIN PGP SIGNATURE-----")) {
+		signer, err = openpgp.CheckArmoredDetachedSignature(k.pgp, f, bytes.NewReader(sig), nil)
+	} else {
+		signer, err = openpgp.CheckDetachedSignature(k.pgp, f, bytes.NewReader(sig), nil)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	if id := signer.PrimaryIdentity(); id != nil {
+		return id.Name, nil
+	}
+	return signer.PrimaryKey.KeyIdString(), nil
+}
+
+// verifyMinisign 校验 minisign 签名：第二行是对文件（"ED" 时为文件的 BLAKE2b-512）的签名，
+// 第四行是对签名和第三行 trusted comment 的全局签名
+func (k *Keyring) verifyMinisign(path string, sig []byte) (string, error) {
+	lines := strings.Split(strings.TrimSpace(string(sig)), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return "", errors.New("malformed minisign signature")
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(b) != 2+8+ed25519.SignatureSize {
+		return "", errors.New("malformed minisign signature")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return "", errors.New("malformed minisign global signature")
+	}
+	alg, id, signature := string(b[:2]), b[2:10], b[10:]
+	comment := strings.TrimSuffix(strings.TrimPrefix(lines[2], "trusted comment: "), "\r")
+
+	var msg []byte
+	switch alg {
+	case "ED":
+		h, _ := blake2b.New512(nil)
+		if err := hashFile(path, h); err != nil {
+			return "", err
+		}
+		msg = h.Sum(nil)
+	case "Ed":
+		if msg, err = readFile(path); err != nil {
+			return "", err
+		}
+	default:
+		return "", errors.Errorf("unsupported minisign algorithm %q", alg)
+	}
+
+	for _, key := range k.minisign {
+		if !bytes.Equal(key.id[:], id) {
+			continue
+		}
+		if !ed25519.Verify(key.key, msg, signature) {
+			return "", ErrBadSignature
+		}
+		if !ed25519.Verify(key.key, append(append([]byte(nil), signature...), comment...), global) {
+			return "", fmt.Errorf("%w: trusted comment", ErrBadSignature)
+		}
+		return key.name, nil
+	}
+	return "", fmt.Errorf("%w: no trusted minisign key with this key id", ErrBadSignature)
+}
+
+func (k *Keyring) verifyEd25519(path string, sig []byte) (string, error) {
+	signature, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	msg, err := readFile(path)
+	if err != nil {
+		return "", err
+	}
+	for _, key := range k.ed25519 {
+		if ed25519.Verify(key.key, msg, signature) {
+			return key.name, nil
+		}
+	}
+	return "", ErrBadSignature
+}
+
+func hashFile(path string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// readFile 读入整个文件，用于不支持流式校验的签名
+func readFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxPureSize {
+		return nil, errors.Errorf("file is too large for a non-prehashed signature (%d bytes)", info.Size())
+	}
+	return os.ReadFile(path)
+}
@@ -0,0 +1,153 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+)
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, data, 0600))
+}
+
+// minisign 生成 minisign 公钥和对 data 的签名，prehash 时使用 "ED" 算法
+func minisign(t *testing.T, data []byte, prehash bool) (pub, sig []byte) {
+	t.Helper()
+	pk, sk, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	id := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	pub = []byte("untrusted comment: minisign public key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), id...), pk...)) + "\n")
+
+	alg, msg := "Ed", data
+	if prehash {
+		h := blake2b.Sum512(data)
+		alg, msg = "ED", h[:]
+	}
+	signature := ed25519.Sign(sk, msg)
+	comment := "timestamp:1700000000\tfile:app.tar.gz"
+	global := ed25519.Sign(sk, append(append([]byte(nil), signature...), comment...))
+	sig = []byte("untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(alg), id...), signature...)) + "\n" +
+		"trusted comment: " + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n")
+	return pub, sig
+}
+
+func TestVerify(t *testing.T) {
+	data := bytes.Repeat([]byte("release"), 1024)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.tar.gz")
+	writeFile(t, file, data)
+	keys := filepath.Join(dir, "keys")
+	require.NoError(t, os.Mkdir(keys, 0700))
+
+	// OpenPGP
+	entity, err := openpgp.NewEntity("Release Signing", "", "release@example.com", nil)
+	require.NoError(t, err)
+	var pub, asc, bin bytes.Buffer
+	require.NoError(t, entity.Serialize(&pub))
+	require.NoError(t, openpgp.ArmoredDetachSign(&asc, entity, bytes.NewReader(data), nil))
+	require.NoError(t, openpgp.DetachSign(&bin, entity, bytes.NewReader(data), nil))
+	writeFile(t, filepath.Join(keys, "release.gpg"), pub.Bytes())
+
+	// minisign
+	miniPub, miniSig := minisign(t, data, true)
+	legacyPub, legacySig := minisign(t, data, false)
+	writeFile(t, filepath.Join(keys, "minisign.pub"), miniPub)
+
+	// ed25519
+	pk, sk, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(pk)
+	require.NoError(t, err)
+	writeFile(t, filepath.Join(keys, "cosign.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	edSig := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(sk, data)))
+
+	writeFile(t, filepath.Join(keys, "README"), []byte("not a key"))
+	writeFile(t, filepath.Join(keys, ".hidden"), legacyPub)
+
+	k, err := LoadKeyring(keys)
+	require.NoError(t, err)
+	assert.Equal(t, 3, k.Len())
+
+	for _, tt := range []struct {
+		name, format, signer string
+		sig                  []byte
+	}{
+		{"armored openpgp", FormatOpenPGP, "Release Signing <release@example.com>", asc.Bytes()},
+		{"binary openpgp", FormatOpenPGP, "Release Signing <release@example.com>", bin.Bytes()},
+		{"minisign", FormatMinisign, "minisign.pub", miniSig},
+		{"ed25519", FormatEd25519, "cosign.pem", edSig},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := k.Verify(file, tt.sig)
+			require.NoError(t, err)
+			assert.Equal(t, Result{Format: tt.format, Signer: tt.signer, Verified: true}, res)
+		})
+	}
+
+	// 不受信任的公钥
+	res, err := k.Verify(file, legacySig)
+	assert.ErrorIs(t, err, ErrBadSignature)
+	assert.Equal(t, FormatMinisign, res.Format)
+	assert.False(t, res.Verified)
+	assert.NotEmpty(t, res.Error)
+
+	// 加入公钥后也支持旧的非预哈希签名
+	writeFile(t, filepath.Join(keys, "legacy.pub"), legacyPub)
+	k, err = LoadKeyring(keys)
+	require.NoError(t, err)
+	res, err = k.Verify(file, legacySig)
+	require.NoError(t, err)
+	assert.Equal(t, "legacy.pub", res.Signer)
+
+	// 文件被篡改
+	writeFile(t, file, append(data, '!'))
+	for _, sig := range [][]byte{asc.Bytes(), miniSig, edSig} {
+		_, err := k.Verify(file, sig)
+		assert.ErrorIs(t, err, ErrBadSignature)
+	}
+
+	_, err = k.Verify(file, []byte("hello"))
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestVerifyMinisignTrustedComment(t *testing.T) {
+	data := []byte("release")
+	file := filepath.Join(t.TempDir(), "app.tar.gz")
+	writeFile(t, file, data)
+	keys := t.TempDir()
+	pub, sig := minisign(t, data, true)
+	writeFile(t, filepath.Join(keys, "minisign.pub"), pub)
+	k, err := LoadKeyring(keys)
+	require.NoError(t, err)
+
+	// 篡改 trusted comment 时全局签名不再匹配
+	forged := bytes.Replace(sig, []byte("file:app.tar.gz"), []byte("file:other.tar"), 1)
+	_, err = k.Verify(file, forged)
+	assert.ErrorIs(t, err, ErrBadSignature)
+}
+
+func TestLoadKeyringMissing(t *testing.T) {
+	k, err := LoadKeyring(filepath.Join(t.TempDir(), "keys"))
+	require.NoError(t, err)
+	assert.Zero(t, k.Len())
+
+	f := filepath.Join(t.TempDir(), "f")
+	writeFile(t, f, []byte("data"))
+	_, err = k.Verify(f, []byte(base64.StdEncoding.EncodeToString(make([]byte, ed25519.SignatureSize))))
+	assert.ErrorIs(t, err, ErrNoKeys)
+}
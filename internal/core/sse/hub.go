@@ -1,6 +1,9 @@
 package sse
 
 import (
+	"go-download/internal/core/extract"
+	"go-download/internal/core/signature"
+	"go-download/internal/pget"
 	"sync"
 )
 
@@ -8,22 +11,114 @@ const (
 	cacheSize = 16
 )
 
+// Progress 推送给订阅者的进度事件：pget 的进度快照加上任务状态
 type Progress struct {
-	Downloaded int64 `json:"downloaded"`
-	Total      int64 `json:"total"`
-	Speed      int64 `json:"speed"` // bytes per second
+	ID    string `json:"id"`
+	Group string `json:"group,omitempty"` // 批量下载的分组 id
+	pget.Progress
+	State      string            `json:"state"`
+	Error      string            `json:"error,omitempty"`
+	Signature  *signature.Result `json:"signature,omitempty"`  // 任务结束时签名校验的结果
+	Extraction *extract.Progress `json:"extraction,omitempty"` // 下载完成后解压的进度
 }
 
 // Hub 管理多个任务的订阅者
 type Hub struct {
 	mu   sync.Mutex
-	Subs map[string]map[chan Progress]struct{} // taskID → set of subscriber channels
+	Subs map[string]map[chan Progress]*subscriber // taskID → set of subscriber channels
+	all  map[chan Progress]*subscriber            // 订阅所有任务的通道
+}
+
+// subscriber 把事件转发到订阅者的通道。通道满时不丢弃事件，而是排队等待送出：
+// 状态变化总是排队，只有与同一任务排在最后的事件状态相同的进度快照才合并为最新的一次，
+// 这样订阅者不会错过 paused、running 等中间状态。
+type subscriber struct {
+	out     chan Progress
+	mu      sync.Mutex
+	queue   []*Progress          // 尚未送入 out 的事件
+	last    map[string]*Progress // 每个任务排在最后的事件
+	sending bool                 // pump 正在送出一个事件
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+func newSubscriber(size int) *subscriber {
+	sub := &subscriber{
+		out:  make(chan Progress, size),
+		last: make(map[string]*Progress),
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go sub.pump()
+	return sub
+}
+
+func (sub *subscriber) push(p Progress) {
+	sub.mu.Lock()
+	// 没有积压时直接放入通道，保证通道未满时每个事件都送达
+	if len(sub.queue) == 0 && !sub.sending {
+		select {
+		case sub.out <- p:
+			sub.mu.Unlock()
+			return
+		default:
+		}
+	}
+	if q, ok := sub.last[p.ID]; ok && q.State == p.State {
+		*q = p
+	} else {
+		q := &p
+		sub.queue = append(sub.queue, q)
+		sub.last[p.ID] = q
+	}
+	sub.mu.Unlock()
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pump 依次把 queue 中的事件送入 out，注销后关闭 out
+func (sub *subscriber) pump() {
+	defer close(sub.out)
+	for {
+		select {
+		case <-sub.wake:
+		case <-sub.done:
+			return
+		}
+		for {
+			sub.mu.Lock()
+			if len(sub.queue) == 0 {
+				sub.mu.Unlock()
+				break
+			}
+			q := sub.queue[0]
+			sub.queue[0] = nil
+			sub.queue = sub.queue[1:]
+			if sub.last[q.ID] == q {
+				delete(sub.last, q.ID)
+			}
+			p := *q
+			sub.sending = true
+			sub.mu.Unlock()
+			select {
+			case sub.out <- p:
+			case <-sub.done:
+				return
+			}
+			sub.mu.Lock()
+			sub.sending = false
+			sub.mu.Unlock()
+		}
+	}
 }
 
 // NewHub 创建一个新的 Hub
 func NewHub() *Hub {
 	return &Hub{
-		Subs: make(map[string]map[chan Progress]struct{}),
+		Subs: make(map[string]map[chan Progress]*subscriber),
+		all:  make(map[chan Progress]*subscriber),
 	}
 }
 
@@ -31,40 +126,62 @@ func NewHub() *Hub {
 func (h *Hub) NewTask(id string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	h.Subs[id] = make(map[chan Progress]struct{})
+	if _, ok := h.Subs[id]; !ok {
+		h.Subs[id] = make(map[chan Progress]*subscriber)
+	}
 }
 
 // Subscribe 为指定任务注册一个进度通道
 func (h *Hub) Subscribe(id string) chan Progress {
-	ch := make(chan Progress, cacheSize) // 带缓冲，防止阻塞发布
+	sub := newSubscriber(cacheSize)
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	if _, ok := h.Subs[id]; !ok {
-		h.Subs[id] = make(map[chan Progress]struct{})
+		h.Subs[id] = make(map[chan Progress]*subscriber)
 	}
-	h.Subs[id][ch] = struct{}{}
-	return ch
+	h.Subs[id][sub.out] = sub
+	return sub.out
 }
 
-// Publish 向所有订阅者广播一次进度更新
+// Publish 向所有订阅者广播一次进度更新，不会阻塞
 func (h *Hub) Publish(id string, prog Progress) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	for ch := range h.Subs[id] {
-		select {
-		case ch <- prog:
-		default:
-			// 如果通道满了就跳过，以免阻塞
-		}
+	for _, sub := range h.Subs[id] {
+		sub.push(prog)
+	}
+	for _, sub := range h.all {
+		sub.push(prog)
 	}
 }
 
-// Unsubscribe 和清理订阅者
+// SubscribeAll 注册一个接收所有任务进度的通道
+func (h *Hub) SubscribeAll() chan Progress {
+	sub := newSubscriber(cacheSize * 4)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.all[sub.out] = sub
+	return sub.out
+}
+
+// UnsubscribeAll 注销 SubscribeAll 返回的通道，通道随后被关闭
+func (h *Hub) UnsubscribeAll(ch chan Progress) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if sub, ok := h.all[ch]; ok {
+		delete(h.all, ch)
+		close(sub.done)
+	}
+}
+
+// Unsubscribe 和清理订阅者，通道随后被关闭
 func (h *Hub) Unsubscribe(id string, ch chan Progress) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	delete(h.Subs[id], ch)
-	close(ch)
+	if sub, ok := h.Subs[id][ch]; ok {
+		delete(h.Subs[id], ch)
+		close(sub.done)
+	}
 	if len(h.Subs[id]) == 0 {
 		delete(h.Subs, id)
 	}
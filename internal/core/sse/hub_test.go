@@ -0,0 +1,86 @@
+package sse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-download/internal/pget"
+)
+
+// drain 读取通道中的事件，直到 wait 内没有新的事件
+func drain(ch chan Progress, wait time.Duration) []Progress {
+	var got []Progress
+	for {
+		select {
+		case p := <-ch:
+			got = append(got, p)
+		case <-time.After(wait):
+			return got
+		}
+	}
+}
+
+func TestPublishCoalescesWhenFull(t *testing.T) {
+	h := NewHub()
+	h.NewTask("a")
+	one := h.Subscribe("a")
+	all := h.SubscribeAll()
+
+	// 订阅者暂时不读取，通道很快被填满
+	for i := 1; i <= 1000; i++ {
+		h.Publish("a", Progress{ID: "a", State: "running", Progress: pget.Progress{Downloaded: int64(i)}})
+		h.Publish("b", Progress{ID: "b", State: "running", Progress: pget.Progress{Downloaded: int64(i)}})
+	}
+	h.Publish("a", Progress{ID: "a", State: "completed", Progress: pget.Progress{Downloaded: 1000}})
+	h.Publish("b", Progress{ID: "b", State: "failed"})
+
+	got := drain(one, 100*time.Millisecond)
+	require.NotEmpty(t, got)
+	assert.Less(t, len(got), 1000)
+	assert.Equal(t, "completed", got[len(got)-1].State)
+
+	last := map[string]string{}
+	for _, p := range drain(all, 100*time.Millisecond) {
+		last[p.ID] = p.State
+	}
+	assert.Equal(t, map[string]string{"a": "completed", "b": "failed"}, last)
+
+	h.Unsubscribe("a", one)
+	h.UnsubscribeAll(all)
+	for range one {
+	}
+	for range all {
+	}
+}
+
+func TestPublishKeepsStateChanges(t *testing.T) {
+	h := NewHub()
+	ch := h.Subscribe("a")
+	publish := func(state string, n int) {
+		for i := 0; i < n; i++ {
+			h.Publish("a", Progress{ID: "a", State: state, Progress: pget.Progress{Downloaded: int64(i)}})
+		}
+	}
+	// 订阅者读取变慢时，暂停和恢复仍然按顺序送达
+	publish("running", 100)
+	publish("paused", 1)
+	publish("running", 100)
+	publish("completed", 1)
+
+	got := drain(ch, 100*time.Millisecond)
+	assert.Less(t, len(got), 100)
+	var states []string
+	for _, p := range got {
+		if len(states) == 0 || states[len(states)-1] != p.State {
+			states = append(states, p.State)
+		}
+	}
+	assert.Equal(t, []string{"running", "paused", "running", "completed"}, states)
+	assert.Equal(t, int64(99), got[len(got)-2].Downloaded, "the latest snapshot is kept")
+
+	h.Unsubscribe("a", ch)
+	for range ch {
+	}
+}
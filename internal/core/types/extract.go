@@ -0,0 +1,50 @@
+package types
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Extract 是任务的解压设置，Enabled 为 false 时不解压，即使有匹配的解压规则
+type Extract struct {
+	Enabled       bool `json:"enabled"`
+	DeleteArchive bool `json:"deleteArchive,omitempty"` // 解压成功后删除压缩包
+}
+
+// ExtractRule 对文件名匹配 Files、域名匹配 Hosts 中任意一个通配符的压缩包在下载完成后解压，
+// Files 或 Hosts 为空时不限制
+type ExtractRule struct {
+	Files         []string `json:"files" yaml:"files"` // 如 "*.zip"，不区分大小写
+	Hosts         []string `json:"hosts" yaml:"hosts"` // 如 "*.example.com"
+	DeleteArchive bool     `json:"deleteArchive" yaml:"deleteArchive"`
+}
+
+// Match 判断规则是否适用于从 host 下载的文件 name
+func (r ExtractRule) Match(host, name string) bool {
+	return matchAny(r.Files, strings.ToLower(name)) && matchAny(r.Hosts, strings.ToLower(host))
+}
+
+func matchAny(patterns []string, v string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateExtractRules 检查解压规则中的通配符是否合法
+func ValidateExtractRules(rules []ExtractRule) error {
+	for i, r := range rules {
+		for _, p := range append(append([]string(nil), r.Files...), r.Hosts...) {
+			if _, err := path.Match(p, ""); err != nil || p == "" {
+				return fmt.Errorf("extract rule %d: invalid pattern %q", i+1, p)
+			}
+		}
+	}
+	return nil
+}
@@ -1,9 +1,109 @@
 package types
 
+import "time"
+
 var Version string
 
 type Request struct {
-	URL          string `json:"url"`
-	DownloadPath string `json:"downloadPath"`
-	ProxyUrl     string `json:"proxyUrl"`
+	URL          string   `json:"url"`
+	Mirrors      []string `json:"mirrors"` // 同一文件的其它下载地址
+	DownloadPath string   `json:"downloadPath"`
+	ProxyUrl     string   `json:"proxyUrl"`
+	RateLimit    int64    `json:"rateLimit"` // 任务限速 bytes/s，0 表示不限速
+
+	Headers  map[string]string `json:"headers,omitempty"`  // 附加到每个请求的请求头
+	Procs    int               `json:"procs,omitempty"`    // 每个 URL 的连接数，0 表示使用配置
+	Checksum string            `json:"checksum,omitempty"` // 下载完成后校验，形如 "sha256:<hex>"
+	Priority int               `json:"priority,omitempty"` // 排队时数值大的先开始
+	Auth     *Auth             `json:"auth,omitempty"`     // 认证凭据，优先于配置中的凭据库
+	Method   string            `json:"method,omitempty"`   // 为空时为 GET
+	Body     string            `json:"body,omitempty"`     // 每个请求都带上的请求体
+	Size     int64             `json:"size,omitempty"`     // 预期的文件大小，与服务端不一致时拒绝
+	Pieces   *Pieces           `json:"pieces,omitempty"`   // 分块摘要，分段完成时校验
+
+	// 镜像地址中 user:pass@ 形式的凭据，按镜像的 host 保存，只发送给该镜像
+	MirrorAuth map[string]*Auth `json:"mirrorAuth,omitempty"`
+
+	// 没有摘要时查找同目录下的 SHA256SUMS、<文件名>.sha256 等校验文件，未设置时使用配置
+	AutoVerify *bool `json:"autoVerify,omitempty"`
+	// 下载完成后用信任的公钥校验分离签名，失败时文件被隔离
+	Signature *Signature `json:"signature,omitempty"`
+	// 下载和校验完成后解压到同级目录，未设置时使用配置中的解压规则
+	Extract *Extract `json:"extract,omitempty"`
+
+	StartAfter *time.Time  `json:"startAfter,omitempty"` // 在此时间之后才开始下载
+	Window     *TimeWindow `json:"window,omitempty"`     // 只在该时间窗口内下载
+}
+
+// CurlImport 从 curl 命令创建任务，例如浏览器开发者工具中 "Copy as cURL" 的结果。
+// Request 中的下载目录、连接数等设置会覆盖从命令中解析出的值。
+type CurlImport struct {
+	Command string `json:"command"`
+	Request
+}
+
+// MetalinkImport 从 Metalink (.meta4/.metalink) 创建任务，URL 和 Content 二选一。
+// 每个文件是批量下载中的一项，Defaults 与 BatchRequest 中的相同。
+type MetalinkImport struct {
+	URL      string  `json:"url,omitempty"`
+	Content  string  `json:"content,omitempty"`
+	Defaults Request `json:"defaults"`
+}
+
+// Signature 是分离签名（.asc、.sig、.minisig 或 base64 编码的 ed25519 签名），URL 和 Content 二选一，
+// 都为空时依次尝试下载地址加上 .minisig、.asc、.sig
+type Signature struct {
+	URL     string `json:"url,omitempty"`
+	Content string `json:"content,omitempty"` // 文本格式的签名
+}
+
+// Pieces 是按固定长度切分的分块摘要，Hashes 为十六进制，最后一块可以较短
+type Pieces struct {
+	Algorithm string   `json:"algorithm"`
+	Length    int64    `json:"length"`
+	Hashes    []string `json:"hashes"`
+}
+
+// BatchRequest 批量创建相互独立的任务，每一项是一个文件，项中未设置的字段使用 Defaults
+type BatchRequest struct {
+	Defaults Request   `json:"defaults"`
+	Items    []Request `json:"items"`
+}
+
+// Auth 是任务的认证凭据，只发送给下载地址所在的域名，不会出现在任务查询结果中。
+// Scheme 为 basic、bearer 或 digest，为空时有 Token 则使用 Bearer，否则按服务端的质询选择。
+type Auth struct {
+	Scheme   string `json:"scheme,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// TimeWindow 每天的一个时间段，Start/End 形如 "01:00"，End 小于 Start 时表示跨过午夜。
+// Days 限定从哪几天开始（0 表示周日），为空表示每天。
+type TimeWindow struct {
+	Start string         `json:"start" yaml:"start"`
+	End   string         `json:"end" yaml:"end"`
+	Days  []time.Weekday `json:"days,omitempty" yaml:"days,omitempty"`
+}
+
+// SpeedProfile 每周计划中的一条限速规则，Limit 为 0 表示不限速
+type SpeedProfile struct {
+	Name       string `json:"name" yaml:"name"`
+	TimeWindow `yaml:",inline"`
+	Limit      int64 `json:"limit" yaml:"limit"`
+}
+
+// Limits 全局限速设置，单位 bytes/s，0 表示不限速。
+// Effective 为考虑限速计划之后实际生效的值，Profile 为命中的计划名。
+type Limits struct {
+	Global    int64  `json:"global"`
+	Effective int64  `json:"effective"`
+	Profile   string `json:"profile,omitempty"`
+}
+
+// TaskPatch 修改任务参数，未设置的字段保持不变
+type TaskPatch struct {
+	RateLimit *int64 `json:"rateLimit"`
+	Priority  *int   `json:"priority"`
 }
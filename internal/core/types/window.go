@@ -0,0 +1,80 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// parseClock 把 "HH:MM" 解析为当天的分钟数
+func parseClock(v string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(v, "%d:%d", &h, &m); err != nil || h < 0 || h > 24 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", v)
+	}
+	return h*60 + m, nil
+}
+
+// Validate 检查时间窗口是否合法
+func (w *TimeWindow) Validate() error {
+	if w == nil {
+		return nil
+	}
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return err
+	}
+	if start == end {
+		return fmt.Errorf("empty time window %s-%s", w.Start, w.End)
+	}
+	for _, d := range w.Days {
+		if d < time.Sunday || d > time.Saturday {
+			return fmt.Errorf("invalid weekday %d", d)
+		}
+	}
+	return nil
+}
+
+// Contains 判断 t 是否落在时间窗口内，窗口需已通过 Validate
+func (w *TimeWindow) Contains(t time.Time) bool {
+	start, _ := parseClock(w.Start)
+	end, _ := parseClock(w.End)
+	minute := t.Hour()*60 + t.Minute()
+
+	day := t.Weekday()
+	var in bool
+	if start < end {
+		in = minute >= start && minute < end
+	} else {
+		// 跨过午夜的窗口：午夜之后的部分属于前一天开始的窗口
+		in = minute >= start || minute < end
+		if minute < end {
+			day = (day + 6) % 7
+		}
+	}
+	if !in || len(w.Days) == 0 {
+		return in
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateSchedule 检查每周限速计划是否合法
+func ValidateSchedule(profiles []SpeedProfile) error {
+	for i := range profiles {
+		if err := profiles[i].TimeWindow.Validate(); err != nil {
+			return fmt.Errorf("profile %q: %w", profiles[i].Name, err)
+		}
+		if profiles[i].Limit < 0 {
+			return fmt.Errorf("profile %q: rate limit must not be negative", profiles[i].Name)
+		}
+	}
+	return nil
+}
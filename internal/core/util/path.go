@@ -0,0 +1,65 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Canonical 返回绝对、清理过并解析了符号链接的路径。
+// 路径不存在时解析最近的已存在的上级目录，再拼接剩余部分。
+func Canonical(path string) (string, error) {
+	p, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	p = filepath.Clean(p)
+
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(p)}, rest...)
+		p = parent
+	}
+}
+
+// Within 判断 path 是否等于 root 或位于 root 之下，两者都应已是规范路径
+func Within(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// ResolveWithin 规范化 path，并要求它位于 roots 中的某一个目录之下
+func ResolveWithin(path string, roots []string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	p, err := Canonical(path)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range roots {
+		r, err := Canonical(root)
+		if err != nil {
+			continue
+		}
+		if Within(p, r) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("path %s is outside the allowed download roots %v", path, roots)
+}
@@ -0,0 +1,53 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveWithin(t *testing.T) {
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	root := filepath.Join(base, "downloads")
+	outside := filepath.Join(base, "outside")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "iso"), 0755))
+	require.NoError(t, os.MkdirAll(outside, 0755))
+	roots := []string{root}
+
+	got, err := ResolveWithin(filepath.Join(root, "iso"), roots)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "iso"), got)
+
+	// 不存在的目录按已存在的上级解析
+	got, err = ResolveWithin(filepath.Join(root, "new", "dir"), roots)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "new", "dir"), got)
+
+	got, err = ResolveWithin(root, roots)
+	require.NoError(t, err)
+	assert.Equal(t, root, got)
+
+	for _, p := range []string{
+		outside,
+		filepath.Join(root, "..", "outside"),
+		filepath.Join(root, "iso", "..", "..", "outside", "x"),
+		base + string(filepath.Separator) + "downloads-evil",
+	} {
+		_, err := ResolveWithin(p, roots)
+		assert.Error(t, err, p)
+	}
+
+	if runtime.GOOS != "windows" {
+		// 根目录内指向外部的符号链接不能用来逃逸
+		require.NoError(t, os.Symlink(outside, filepath.Join(root, "link")))
+		_, err = ResolveWithin(filepath.Join(root, "link"), roots)
+		assert.Error(t, err)
+		_, err = ResolveWithin(filepath.Join(root, "link", "sub", "dir"), roots)
+		assert.Error(t, err)
+	}
+}
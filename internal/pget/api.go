@@ -0,0 +1,310 @@
+package pget
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Request 描述一次下载
+type Request struct {
+	URLs     []string    // 同一文件的下载地址，第一个为主地址，其余为镜像
+	Output   string      // 保存到的目录（必须已存在）或目标文件路径，为空时为当前目录
+	Procs    int         // URLs 中每个地址的连接数，默认 1，发现的镜像不增加连接数
+	Header   http.Header // 附加到每个请求的请求头，不能覆盖 Range
+	Referer  string
+	Checksum string       // 下载完成后校验，形如 "sha256:<hex>"
+	Limiters []*Limiter   // 本次下载额外的限速器，例如任务限速
+	Auth     *Credential  // 只用于主地址所在的域名，优先于 WithCredentials，Hosts 被忽略
+	Method   string       // 为空时为 GET，其它方法要求服务端对该请求支持 Range
+	Body     []byte       // 每个请求都带上的请求体
+	Size     int64        // 预期的文件大小，大于 0 时与服务端不一致则报错
+	Pieces   *PieceHashes // 分块摘要，分段完成时校验，不一致的分块从其它镜像重新下载
+}
+
+// Result 是一次成功下载的结果
+type Result struct {
+	Path         string   // 下载完成后的文件路径
+	Size         int64    // 文件大小
+	URLs         []string // 实际使用的地址（跟随重定向之后）
+	Duration     time.Duration
+	Checksum     string // 校验通过的摘要，没有校验时为空
+	ChecksumFrom string // Checksum 来自哪个响应头，来自 Request 时为空
+}
+
+type EventType string
+
+const (
+	EventStart    EventType = "start"    // 探测完成，即将开始传输
+	EventProgress EventType = "progress" // 定期的进度快照，最后一次的速度为 0
+	EventMerge    EventType = "merge"    // 所有分段下载完成，开始合并
+	EventVerify   EventType = "verify"   // 合并完成，开始校验
+)
+
+// Event 是下载过程中的事件。Progress 在 EventProgress 时是完整的快照，
+// 其余事件只有 Path 和 Total。
+type Event struct {
+	Type     EventType
+	Progress Progress
+}
+
+// Client 以类型化的参数执行下载，不读写终端，可以被多个下载共享
+type Client struct {
+	http      *http.Client
+	proxy     string
+	rules     []ProxyRule
+	tls       []TLSConfig
+	creds     []Credential
+	pool      *ProxyPool
+	jar       http.CookieJar
+	timeout   time.Duration
+	userAgent string
+	events    chan<- Event
+	opts      []DownloadOption
+}
+
+type ClientOption func(c *Client)
+
+// WithProxy 通过 proxy 发出所有请求
+func WithProxy(proxy string) ClientOption {
+	return func(c *Client) {
+		c.proxy = proxy
+	}
+}
+
+// WithProxyRules 按目标域名选择代理，未命中任何规则时使用 WithProxy 设置的代理
+func WithProxyRules(rules []ProxyRule) ClientOption {
+	return func(c *Client) {
+		c.rules = rules
+	}
+}
+
+// WithTLS 设置全局和按域名的 TLS 设置，例如私有 CA、客户端证书和公钥固定
+func WithTLS(configs []TLSConfig) ClientOption {
+	return func(c *Client) {
+		c.tls = configs
+	}
+}
+
+// WithCredentials 设置按域名的认证凭据
+func WithCredentials(creds []Credential) ClientOption {
+	return func(c *Client) {
+		c.creds = creds
+	}
+}
+
+// WithHTTPClient 使用调用方提供的 http.Client，此时忽略 WithProxy、WithProxyRules 和 WithTLS，
+// client 已有 Jar 时也忽略 WithCookieJar
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// WithTimeout 设置探测请求的超时，默认 10 秒
+func WithTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		if d > 0 {
+			c.timeout = d
+		}
+	}
+}
+
+// WithAgent 设置 User-Agent，请求头中的 User-Agent 优先
+func WithAgent(ua string) ClientOption {
+	return func(c *Client) {
+		c.userAgent = ua
+	}
+}
+
+// WithEvents 把下载事件发送到 ch。发送会阻塞，调用方需要持续读取直到 Download 返回，
+// Download 返回之后不会再发送。
+func WithEvents(ch chan<- Event) ClientOption {
+	return func(c *Client) {
+		c.events = ch
+	}
+}
+
+// WithDownloadOptions 对每次下载应用底层的 DownloadOption，例如 WithObserver、WithLimiters
+func WithDownloadOptions(opts ...DownloadOption) ClientOption {
+	return func(c *Client) {
+		c.opts = append(c.opts, opts...)
+	}
+}
+
+// NewClient 创建下载客户端，代理地址不合法时返回错误
+func NewClient(opts ...ClientOption) (*Client, error) {
+	c := &Client{
+		timeout:   10 * time.Second,
+		userAgent: "Pget",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if err := ValidateCredentials(c.creds); err != nil {
+		return nil, err
+	}
+	if c.http == nil {
+		// TODO(codehex): calc maxIdleConnsPerHost
+		client, err := newTransportClient(16, TransportConfig{
+			Proxy:       c.proxy,
+			ProxyRules:  c.rules,
+			TLS:         c.tls,
+			Credentials: c.creds,
+			Jar:         c.jar,
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.http = client
+	} else if _, ok := c.http.Transport.(*authTransport); !ok {
+		// 调用方的 client 也要能处理认证
+		client := *c.http
+		next := client.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		client.Transport = newAuthTransport(next, c.creds)
+		c.http = &client
+	}
+	if c.jar != nil && c.http.Jar == nil {
+		client := *c.http
+		client.Jar = c.jar
+		c.http = &client
+	}
+	return c, nil
+}
+
+// Download 下载 req 描述的文件，已存在的分段文件会被续传
+func (c *Client) Download(ctx context.Context, req *Request) (*Result, error) {
+	if len(req.URLs) == 0 {
+		return nil, errors.New("URL is required at least one")
+	}
+	if req.Checksum != "" {
+		if _, err := parseChecksum(req.Checksum); err != nil {
+			return nil, err
+		}
+	}
+	if req.Pieces != nil {
+		if err := req.Pieces.Validate(req.Size); err != nil {
+			return nil, err
+		}
+	}
+	if req.Auth != nil {
+		if err := req.Auth.Validate(); err != nil {
+			return nil, err
+		}
+		// 镜像可能属于第三方，它们的凭据由调用方通过 ContextWithCredential 指定
+		ctx = ContextWithCredential(ctx, req.Auth, req.URLs[0])
+	}
+	procs := req.Procs
+	if procs <= 0 {
+		procs = 1
+	}
+	start := time.Now()
+
+	checkClient := c.http
+	if c.pool != nil {
+		checkClient = c.pool.Client()
+	}
+	target, err := Check(ctx, &CheckConfig{
+		URLs:    req.URLs,
+		Timeout: c.timeout,
+		Client:  checkClient,
+		Header:  req.Header,
+		Method:  req.Method,
+		Body:    req.Body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 没有指定摘要时使用服务端在响应头中给出的摘要
+	checksum, from := req.Checksum, ""
+	if checksum == "" {
+		checksum, from = target.Checksum, target.ChecksumFrom
+	}
+	if req.Size > 0 && target.ContentLength != req.Size {
+		return nil, errors.Errorf("expected %d bytes, but the server reports %d", req.Size, target.ContentLength)
+	}
+
+	dir, filename, err := outputPath(req.Output, target.Filename)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, filename)
+
+	opts := []DownloadOption{
+		WithUserAgent(c.userAgent, ""),
+		WithReferer(req.Referer),
+		WithHeader(req.Header),
+		withUntrusted(target.Discovered),
+		WithMethod(req.Method, req.Body),
+		WithChecksum(checksum),
+		WithLimiters(req.Limiters...),
+	}
+	if req.Pieces != nil {
+		opts = append(opts, WithPieces(req.Pieces))
+	}
+	if c.events != nil {
+		emit := func(typ EventType, p Progress) {
+			select {
+			case c.events <- Event{Type: typ, Progress: p}:
+			case <-ctx.Done():
+			}
+		}
+		stage := func(typ EventType) {
+			emit(typ, Progress{Path: path, Total: target.ContentLength})
+		}
+		stage(EventStart)
+		opts = append(opts,
+			WithProgressCallback(func(p Progress) { emit(EventProgress, p) }),
+			withStage(stage),
+		)
+	}
+	opts = append(opts, c.opts...)
+
+	// 连接数只按调用方给出的 URL 计算：发现的镜像只分担这些分段，
+	// 否则镜像列表一变分段数和分段目录就跟着变，之前的进度无法续传
+	err = Download(ctx, &DownloadConfig{
+		Filename:      filename,
+		Dirname:       dir,
+		ContentLength: target.ContentLength,
+		Procs:         procs * len(req.URLs),
+		URLs:          target.URLs,
+		Client:        c.http,
+		Pool:          c.pool,
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		Path:         path,
+		Size:         target.ContentLength,
+		URLs:         target.URLs,
+		Duration:     time.Since(start),
+		Checksum:     checksum,
+		ChecksumFrom: from,
+	}, nil
+}
+
+// outputPath 把 Output 拆分成目录和文件名。Output 是已存在的目录时使用服务端给出的文件名，
+// 否则视为目标文件路径并创建其所在目录。
+func outputPath(output, filename string) (string, string, error) {
+	if output == "" {
+		return "", filename, nil
+	}
+	if fi, err := os.Stat(output); err == nil && fi.IsDir() {
+		return output, filename, nil
+	}
+	dir, name := filepath.Split(output)
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", "", errors.Wrapf(err, "failed to create diretory at %s", dir)
+		}
+	}
+	return dir, name, nil
+}
@@ -0,0 +1,136 @@
+package pget
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientDownload(t *testing.T) {
+	data := make([]byte, 512*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	sum := sha256.Sum256(data)
+	var agent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agent = r.Header.Get("User-Agent")
+		http.ServeContent(w, r, "data.bin", time.Now(), bytes.NewReader(data))
+	}))
+	t.Cleanup(ts.Close)
+
+	events := make(chan Event)
+	client, err := NewClient(WithAgent("test-agent"), WithEvents(events))
+	require.NoError(t, err)
+
+	var got []Event
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ev := range events {
+			got = append(got, ev)
+		}
+	}()
+
+	dir := t.TempDir()
+	res, err := client.Download(context.Background(), &Request{
+		URLs:     []string{ts.URL + "/data.bin"},
+		Output:   dir,
+		Procs:    3,
+		Checksum: "sha256:" + hex.EncodeToString(sum[:]),
+	})
+	close(events)
+	<-done
+	require.NoError(t, err)
+
+	assert.Equal(t, filepath.Join(dir, "data.bin"), res.Path)
+	assert.Equal(t, int64(len(data)), res.Size)
+	assert.Equal(t, "test-agent", agent)
+	b, err := os.ReadFile(res.Path)
+	require.NoError(t, err)
+	assert.Equal(t, data, b)
+
+	// 事件依次为开始、若干进度、合并、校验，最后一次进度是完成时的快照
+	require.GreaterOrEqual(t, len(got), 4)
+	assert.Equal(t, EventStart, got[0].Type)
+	assert.Equal(t, res.Path, got[0].Progress.Path)
+	assert.Equal(t, EventMerge, got[len(got)-2].Type)
+	assert.Equal(t, EventVerify, got[len(got)-1].Type)
+	last := got[len(got)-3]
+	assert.Equal(t, EventProgress, last.Type)
+	assert.Equal(t, int64(len(data)), last.Progress.Downloaded)
+	assert.Len(t, last.Progress.Segments, 3)
+}
+
+func TestClientOutputFile(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "a.txt", time.Now(), bytes.NewReader([]byte("hello world")))
+	}))
+	t.Cleanup(ts.Close)
+
+	client, err := NewClient()
+	require.NoError(t, err)
+	out := filepath.Join(t.TempDir(), "sub", "renamed.txt")
+	res, err := client.Download(context.Background(), &Request{URLs: []string{ts.URL + "/a.txt"}, Output: out})
+	require.NoError(t, err)
+	assert.Equal(t, out, res.Path)
+	b, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+}
+
+func TestClientErrors(t *testing.T) {
+	_, err := NewClient(WithProxy("://bad"))
+	assert.Error(t, err)
+
+	client, err := NewClient()
+	require.NoError(t, err)
+	_, err = client.Download(context.Background(), &Request{})
+	assert.Error(t, err)
+	_, err = client.Download(context.Background(), &Request{URLs: []string{"http://127.0.0.1:1/a"}, Checksum: "crc:00"})
+	assert.Error(t, err)
+}
+
+func TestClientPostDownload(t *testing.T) {
+	data := make([]byte, 200*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 只有带着正确表单的 POST 才返回文件，HEAD 探测会失败
+		b, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || string(b) != "id=7" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="export.bin"`)
+		http.ServeContent(w, r, "", time.Now(), bytes.NewReader(data))
+	}))
+	defer ts.Close()
+
+	client, err := NewClient()
+	require.NoError(t, err)
+	_, err = client.Download(context.Background(), &Request{URLs: []string{ts.URL + "/export"}, Output: t.TempDir()})
+	assert.ErrorContains(t, err, "405")
+
+	res, err := client.Download(context.Background(), &Request{
+		URLs:   []string{ts.URL + "/export"},
+		Output: t.TempDir(),
+		Procs:  4,
+		Method: http.MethodPost,
+		Body:   []byte("id=7"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "export.bin", filepath.Base(res.Path))
+	got, err := os.ReadFile(res.Path)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
@@ -0,0 +1,429 @@
+package pget
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+type AuthScheme string
+
+const (
+	AuthBasic  AuthScheme = "basic"  // 每个请求直接带上用户名和密码
+	AuthBearer AuthScheme = "bearer" // 每个请求直接带上令牌
+	AuthDigest AuthScheme = "digest" // 只应答服务端的 Digest 质询，不会以明文发送密码
+)
+
+// RedactedSecret 替换对外展示的密码和令牌
+const RedactedSecret = "xxxxx"
+
+// Credential 是 HTTP 认证凭据。Scheme 为空时，有 Token 则使用 Bearer，
+// 否则等待服务端质询，优先应答 Digest，其次 Basic。
+type Credential struct {
+	Hosts    []string   `json:"hosts,omitempty" yaml:"hosts,omitempty"` // 通配符，只在凭据库中使用
+	Scheme   AuthScheme `json:"scheme,omitempty" yaml:"scheme,omitempty"`
+	Username string     `json:"username,omitempty" yaml:"username,omitempty"`
+	Password string     `json:"password,omitempty" yaml:"password,omitempty"`
+	Token    string     `json:"token,omitempty" yaml:"token,omitempty"`
+}
+
+func (c *Credential) scheme() AuthScheme {
+	if c.Scheme == "" && c.Token != "" {
+		return AuthBearer
+	}
+	return c.Scheme
+}
+
+// Validate 检查凭据是否完整，错误信息中不包含密码和令牌
+func (c *Credential) Validate() error {
+	switch c.Scheme {
+	case "", AuthBasic, AuthBearer, AuthDigest:
+	default:
+		return fmt.Errorf("unknown auth scheme %q, want basic, bearer or digest", c.Scheme)
+	}
+	if c.Token != "" && (c.Username != "" || c.Password != "") {
+		return fmt.Errorf("auth: set either token or username and password")
+	}
+	if c.scheme() == AuthBearer {
+		if c.Token == "" {
+			return fmt.Errorf("auth: bearer requires a token")
+		}
+		return nil
+	}
+	if c.Username == "" {
+		return fmt.Errorf("auth: username is required")
+	}
+	if strings.ContainsAny(c.Username, ":\r\n") {
+		return fmt.Errorf("auth: username must not contain a colon or line break")
+	}
+	return nil
+}
+
+// Redacted 返回隐去密码和令牌的副本
+func (c Credential) Redacted() Credential {
+	if c.Password != "" {
+		c.Password = RedactedSecret
+	}
+	if c.Token != "" {
+		c.Token = RedactedSecret
+	}
+	return c
+}
+
+// ValidateCredentials 检查凭据库，每条凭据都必须指定域名
+func ValidateCredentials(creds []Credential) error {
+	for i := range creds {
+		c := &creds[i]
+		if len(c.Hosts) == 0 {
+			return fmt.Errorf("credential %d: hosts is required", i+1)
+		}
+		for _, h := range c.Hosts {
+			if _, err := path.Match(h, ""); err != nil || h == "" {
+				return fmt.Errorf("credential %d: invalid host pattern %q", i+1, h)
+			}
+		}
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("credential %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+type credentialKey struct{}
+
+type scopedCredential struct {
+	cred  Credential
+	hosts map[string]bool
+}
+
+// ContextWithCredential 让经由 ctx 发出、目标为 urls 所在域名的请求使用 cred，
+// 优先于凭据库。重定向到其它域名的请求不会带上 cred。
+// ctx 中已有同一域名的凭据时，先加入的优先。
+func ContextWithCredential(ctx context.Context, cred *Credential, urls ...string) context.Context {
+	if cred == nil {
+		return ctx
+	}
+	sc := &scopedCredential{cred: *cred, hosts: make(map[string]bool)}
+	for _, raw := range urls {
+		if u, err := url.Parse(raw); err == nil {
+			sc.hosts[strings.ToLower(u.Hostname())] = true
+		}
+	}
+	scoped, _ := ctx.Value(credentialKey{}).([]*scopedCredential)
+	return context.WithValue(ctx, credentialKey{}, append(scoped[:len(scoped):len(scoped)], sc))
+}
+
+// authTransport 为请求添加认证信息，并应答 401 质询。请求中已有 Authorization 时不做处理。
+type authTransport struct {
+	next  http.RoundTripper
+	creds []Credential
+
+	mu      sync.Mutex
+	digests map[string]*digestState // 按 host:port 缓存的 Digest 质询，之后的请求直接应答
+	basic   map[string]bool         // 要求 Basic 的 host:port，之后的请求直接发送
+}
+
+func newAuthTransport(next http.RoundTripper, creds []Credential) *authTransport {
+	return &authTransport{
+		next:    next,
+		creds:   creds,
+		digests: make(map[string]*digestState),
+		basic:   make(map[string]bool),
+	}
+}
+
+func (a *authTransport) credential(req *http.Request) *Credential {
+	host := strings.ToLower(strings.TrimSuffix(req.URL.Hostname(), "."))
+	scoped, _ := req.Context().Value(credentialKey{}).([]*scopedCredential)
+	for _, sc := range scoped {
+		if sc.hosts[host] {
+			return &sc.cred
+		}
+	}
+	for i := range a.creds {
+		for _, pattern := range a.creds[i].Hosts {
+			if matched, _ := path.Match(strings.ToLower(pattern), host); matched {
+				return &a.creds[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (a *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cred := a.credential(req)
+	if cred == nil || req.Header.Get("Authorization") != "" {
+		return a.next.RoundTrip(req)
+	}
+	r := req.Clone(req.Context())
+	sent := a.authorize(r, cred)
+	resp, err := a.next.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !a.challenged(resp, cred, r.URL.Host, sent) {
+		return resp, err
+	}
+
+	// 按质询重发一次，有请求体时需要能够重新读取
+	r = req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		r.Body = body
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	a.authorize(r, cred)
+	return a.next.RoundTrip(r)
+}
+
+// authorize 添加 Authorization，返回使用的方式，没有添加时为空
+func (a *authTransport) authorize(r *http.Request, cred *Credential) AuthScheme {
+	switch cred.scheme() {
+	case AuthBearer:
+		r.Header.Set("Authorization", "Bearer "+cred.Token)
+		return AuthBearer
+	case AuthBasic:
+		r.SetBasicAuth(cred.Username, cred.Password)
+		return AuthBasic
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if d := a.digests[r.URL.Host]; d != nil {
+		r.Header.Set("Authorization", d.authorize(r, cred))
+		return AuthDigest
+	}
+	if cred.scheme() == "" && a.basic[r.URL.Host] {
+		r.SetBasicAuth(cred.Username, cred.Password)
+		return AuthBasic
+	}
+	return ""
+}
+
+// challenged 记录 401 中的质询，返回是否值得用新的认证信息重试
+func (a *authTransport) challenged(resp *http.Response, cred *Credential, host string, sent AuthScheme) bool {
+	scheme := cred.scheme()
+	if scheme == AuthBearer || scheme == AuthBasic {
+		return false
+	}
+	challenges := parseChallenges(resp.Header.Values("WWW-Authenticate"))
+
+	var best *digestState
+	for _, ch := range challenges {
+		if ch.scheme != "digest" {
+			continue
+		}
+		if d, err := newDigestState(ch.params); err == nil && (best == nil || d.strength > best.strength) {
+			best = d
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if best != nil {
+		// 已经应答过 Digest 而 nonce 没有过期，说明凭据错误
+		if sent == AuthDigest && !best.stale {
+			return false
+		}
+		a.digests[host] = best
+		return true
+	}
+	if scheme == "" && sent == "" {
+		for _, ch := range challenges {
+			if ch.scheme == "basic" {
+				a.basic[host] = true
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type authChallenge struct {
+	scheme string
+	params map[string]string
+}
+
+// parseChallenges 解析 WWW-Authenticate，一个头中可以有多个以逗号分隔的质询
+func parseChallenges(values []string) []authChallenge {
+	var out []authChallenge
+	for _, s := range values {
+		for {
+			s = strings.TrimLeft(s, " \t,")
+			if s == "" {
+				break
+			}
+			i := strings.IndexAny(s, " \t,")
+			if i < 0 {
+				out = append(out, authChallenge{scheme: strings.ToLower(s)})
+				break
+			}
+			ch := authChallenge{scheme: strings.ToLower(s[:i]), params: make(map[string]string)}
+			s = s[i:]
+			for {
+				rest := strings.TrimLeft(s, " \t,")
+				j := strings.IndexAny(rest, "= \t,")
+				if j <= 0 || rest[j] != '=' {
+					// 下一个质询开始
+					s = rest
+					break
+				}
+				name := strings.ToLower(rest[:j])
+				value, remain := parseParamValue(rest[j+1:])
+				ch.params[name] = value
+				s = remain
+			}
+			out = append(out, ch)
+		}
+	}
+	return out
+}
+
+// parseParamValue 解析 token 或带转义的 quoted-string
+func parseParamValue(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		end := strings.IndexAny(s, " \t,")
+		if end < 0 {
+			return s, ""
+		}
+		return s[:end], s[end:]
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), ""
+}
+
+// digestState 是一次 Digest 质询（RFC 7616），nc 在同一个 nonce 的每次应答中递增
+type digestState struct {
+	realm, nonce, opaque string
+	algorithm            string
+	qop                  string // "auth" 或为空（RFC 2069 兼容）
+	userhash, stale      bool
+	sess                 bool
+	hash                 func() hash.Hash
+	strength             int
+	nc                   uint32
+}
+
+func newDigestState(p map[string]string) (*digestState, error) {
+	d := &digestState{
+		realm:     p["realm"],
+		nonce:     p["nonce"],
+		opaque:    p["opaque"],
+		algorithm: p["algorithm"],
+		userhash:  strings.EqualFold(p["userhash"], "true"),
+		stale:     strings.EqualFold(p["stale"], "true"),
+	}
+	if d.nonce == "" {
+		return nil, fmt.Errorf("digest challenge without nonce")
+	}
+	if d.algorithm == "" {
+		d.algorithm = "MD5"
+	}
+	base, sess := strings.CutSuffix(strings.ToUpper(d.algorithm), "-SESS")
+	d.sess = sess
+	switch base {
+	case "MD5":
+		d.hash, d.strength = md5.New, 1
+	case "SHA-256":
+		d.hash, d.strength = sha256.New, 2
+	case "SHA-512-256":
+		d.hash, d.strength = sha512.New512_256, 3
+	default:
+		return nil, fmt.Errorf("unsupported digest algorithm %q", d.algorithm)
+	}
+	if qop, ok := p["qop"]; ok {
+		for _, q := range strings.Split(qop, ",") {
+			if strings.TrimSpace(q) == "auth" {
+				d.qop = "auth"
+			}
+		}
+		if d.qop == "" {
+			return nil, fmt.Errorf("unsupported digest qop %q", qop)
+		}
+	}
+	return d, nil
+}
+
+func (d *digestState) h(s string) string {
+	x := d.hash()
+	io.WriteString(x, s)
+	return hex.EncodeToString(x.Sum(nil))
+}
+
+// authorize 计算 Authorization，调用方持有锁
+func (d *digestState) authorize(r *http.Request, cred *Credential) string {
+	d.nc++
+	nc := fmt.Sprintf("%08x", d.nc)
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	cnonce := hex.EncodeToString(buf)
+
+	ha1 := d.h(cred.Username + ":" + d.realm + ":" + cred.Password)
+	if d.sess {
+		ha1 = d.h(ha1 + ":" + d.nonce + ":" + cnonce)
+	}
+	uri := r.URL.RequestURI()
+	ha2 := d.h(r.Method + ":" + uri)
+	var response string
+	if d.qop != "" {
+		response = d.h(strings.Join([]string{ha1, d.nonce, nc, cnonce, d.qop, ha2}, ":"))
+	} else {
+		response = d.h(ha1 + ":" + d.nonce + ":" + ha2)
+	}
+
+	username := cred.Username
+	if d.userhash {
+		username = d.h(cred.Username + ":" + d.realm)
+	}
+	parts := []string{
+		"username=" + quote(username),
+		"realm=" + quote(d.realm),
+		"nonce=" + quote(d.nonce),
+		"uri=" + quote(uri),
+		"algorithm=" + d.algorithm,
+		"response=" + quote(response),
+	}
+	if d.opaque != "" {
+		parts = append(parts, "opaque="+quote(d.opaque))
+	}
+	if d.qop != "" {
+		parts = append(parts, "qop="+d.qop, "nc="+nc, "cnonce="+quote(cnonce))
+	}
+	if d.userhash {
+		parts = append(parts, "userhash=true")
+	}
+	return "Digest " + strings.Join(parts, ", ")
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+func quote(s string) string {
+	return `"` + quoteEscaper.Replace(s) + `"`
+}
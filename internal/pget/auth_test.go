@@ -0,0 +1,255 @@
+package pget
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChallenges(t *testing.T) {
+	got := parseChallenges([]string{
+		`Basic realm="files", Digest realm="a \"b\"", nonce=abc, qop="auth,auth-int", algorithm=SHA-256`,
+		`Bearer`,
+	})
+	require.Len(t, got, 3)
+	assert.Equal(t, "basic", got[0].scheme)
+	assert.Equal(t, "files", got[0].params["realm"])
+	assert.Equal(t, "digest", got[1].scheme)
+	assert.Equal(t, map[string]string{"realm": `a "b"`, "nonce": "abc", "qop": "auth,auth-int", "algorithm": "SHA-256"}, got[1].params)
+	assert.Equal(t, "bearer", got[2].scheme)
+}
+
+func TestValidateCredentials(t *testing.T) {
+	assert.NoError(t, ValidateCredentials([]Credential{
+		{Hosts: []string{"*.example.com"}, Token: "t"},
+		{Hosts: []string{"files.test"}, Scheme: AuthDigest, Username: "u", Password: "p"},
+	}))
+	for _, c := range []Credential{
+		{Token: "t"},
+		{Hosts: []string{"a"}, Scheme: "ntlm", Username: "u"},
+		{Hosts: []string{"a"}, Scheme: AuthBearer},
+		{Hosts: []string{"a"}, Username: "u", Token: "t"},
+		{Hosts: []string{"a"}, Username: "a:b", Password: "p"},
+		{Hosts: []string{"a"}, Password: "p"},
+	} {
+		err := ValidateCredentials([]Credential{c})
+		if assert.Error(t, err, "%+v", c) {
+			assert.NotContains(t, err.Error(), "secret")
+		}
+	}
+	assert.Equal(t, Credential{Username: "u", Password: RedactedSecret}, Credential{Username: "u", Password: "p"}.Redacted())
+}
+
+// digestServer 要求 SHA-256 Digest 认证，nonce 使用 maxUses 次后过期
+func digestServer(t *testing.T, user, pass string, maxUses int) (*httptest.Server, func() int) {
+	data := bytes.Repeat([]byte("digest"), 20*1024)
+	const realm = "files@test"
+	var (
+		mu         sync.Mutex
+		nonce      string
+		uses       int
+		challenges int
+		seen       = map[string]bool{}
+	)
+	h := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		challenge := func(stale bool) {
+			challenges++
+			nonce = fmt.Sprintf("n%d", challenges)
+			uses = 0
+			w.Header().Add("WWW-Authenticate", `Basic realm="`+realm+`"`)
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", nonce="%s", qop="auth", algorithm=MD5, stale=%v`, realm, nonce, stale))
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", nonce="%s", qop="auth", algorithm=SHA-256, opaque="op", stale=%v`, realm, nonce, stale))
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Digest ") {
+			challenge(false)
+			return
+		}
+		p := parseChallenges([]string{auth})[0].params
+		if p["nonce"] != nonce || uses >= maxUses {
+			challenge(true)
+			return
+		}
+		ha1 := h(user + ":" + realm + ":" + pass)
+		ha2 := h(r.Method + ":" + r.URL.RequestURI())
+		want := h(strings.Join([]string{ha1, nonce, p["nc"], p["cnonce"], "auth", ha2}, ":"))
+		if p["algorithm"] != "SHA-256" || p["response"] != want || p["opaque"] != "op" || p["uri"] != r.URL.RequestURI() || seen[nonce+p["nc"]] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		seen[nonce+p["nc"]] = true
+		uses++
+		http.ServeContent(w, r, "data.bin", time.Now(), bytes.NewReader(data))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return challenges
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	ts, challenges := digestServer(t, "alice", "s3cret", 100)
+	client, err := NewClient()
+	require.NoError(t, err)
+
+	res, err := client.Download(context.Background(), &Request{
+		URLs:   []string{ts.URL + "/data.bin?x=1"},
+		Output: t.TempDir(),
+		Procs:  4,
+		Auth:   &Credential{Username: "alice", Password: "s3cret"},
+	})
+	require.NoError(t, err)
+	b, err := os.ReadFile(res.Path)
+	require.NoError(t, err)
+	assert.Len(t, b, 120*1024)
+	// 只有探测请求收到质询，分段请求复用 nonce
+	assert.Equal(t, 1, challenges())
+
+	// 密码错误
+	_, err = client.Download(context.Background(), &Request{
+		URLs:   []string{ts.URL + "/data.bin"},
+		Output: t.TempDir(),
+		Auth:   &Credential{Scheme: AuthDigest, Username: "alice", Password: "wrong"},
+	})
+	assert.Error(t, err)
+}
+
+func TestDigestStaleNonce(t *testing.T) {
+	ts, challenges := digestServer(t, "alice", "s3cret", 2)
+	got, err := download(t, ts.URL+"/data.bin", WithCredentials([]Credential{
+		{Hosts: []string{"127.0.0.1"}, Scheme: AuthDigest, Username: "alice", Password: "s3cret"},
+	}))
+	require.NoError(t, err)
+	assert.Len(t, got, 120*1024)
+	assert.Greater(t, challenges(), 1)
+}
+
+func TestBasicAndBearerAuth(t *testing.T) {
+	data := []byte("protected content")
+	var (
+		mu    sync.Mutex
+		auths = map[string][]string{}
+	)
+	handler := func(name string, ok func(r *http.Request) bool) *httptest.Server {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			auths[name] = append(auths[name], r.Header.Get("Authorization"))
+			mu.Unlock()
+			if !ok(r) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="x"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			http.ServeContent(w, r, "data.txt", time.Now(), bytes.NewReader(data))
+		}))
+		t.Cleanup(ts.Close)
+		return ts
+	}
+	other := handler("other", func(r *http.Request) bool { return true })
+	basic := handler("basic", func(r *http.Request) bool {
+		u, p, ok := r.BasicAuth()
+		return ok && u == "bob" && p == "pw"
+	})
+	bearer := handler("bearer", func(r *http.Request) bool { return r.Header.Get("Authorization") == "Bearer tkn" })
+
+	_, err := download(t, basic.URL+"/data.txt")
+	assert.ErrorContains(t, err, "401")
+
+	// Scheme 为空时等到质询后才发送密码，之后的请求直接带上
+	client, err := NewClient()
+	require.NoError(t, err)
+	_, err = client.Download(context.Background(), &Request{
+		URLs:   []string{basic.URL + "/data.txt"},
+		Output: t.TempDir(),
+		Auth:   &Credential{Username: "bob", Password: "pw"},
+	})
+	require.NoError(t, err)
+	mu.Lock()
+	assert.Empty(t, auths["basic"][len(auths["basic"])-3], "the probe waits for a challenge")
+	assert.Equal(t, "Basic Ym9iOnB3", auths["basic"][len(auths["basic"])-1])
+	mu.Unlock()
+
+	// 凭据库中的 Bearer 令牌，不会发给其它域名
+	client, err = NewClient(WithCredentials([]Credential{{Hosts: []string{"127.0.0.1"}, Token: "tkn"}}))
+	require.NoError(t, err)
+	_, err = client.Download(context.Background(), &Request{URLs: []string{bearer.URL + "/a"}, Output: t.TempDir()})
+	require.NoError(t, err)
+	_, err = client.Download(context.Background(), &Request{
+		URLs:   []string{strings.Replace(other.URL, "127.0.0.1", "localhost", 1) + "/a"},
+		Output: t.TempDir(),
+	})
+	require.NoError(t, err)
+	mu.Lock()
+	for _, a := range auths["other"] {
+		assert.Empty(t, a)
+	}
+	mu.Unlock()
+
+	// Auth 只发给主地址，镜像的凭据由调用方通过 ContextWithCredential 指定
+	mirror := strings.Replace(other.URL, "127.0.0.1", "localhost", 1) + "/data.txt"
+	mu.Lock()
+	auths["other"] = nil
+	mu.Unlock()
+	client, err = NewClient()
+	require.NoError(t, err)
+	_, err = client.Download(context.Background(), &Request{
+		URLs:   []string{basic.URL + "/data.txt", mirror},
+		Output: t.TempDir(),
+		Procs:  2,
+		Auth:   &Credential{Scheme: AuthBasic, Username: "bob", Password: "pw"},
+	})
+	require.NoError(t, err)
+	mu.Lock()
+	require.NotEmpty(t, auths["other"])
+	for _, a := range auths["other"] {
+		assert.Empty(t, a)
+	}
+	mu.Unlock()
+
+	// 请求头中显式的 Authorization 优先
+	_, err = client.Download(context.Background(), &Request{
+		URLs:   []string{bearer.URL + "/a"},
+		Output: t.TempDir(),
+		Header: http.Header{"Authorization": {"Bearer other"}},
+	})
+	assert.Error(t, err)
+}
+
+func TestScopedCredentials(t *testing.T) {
+	mirror := &Credential{Username: "alice", Password: "m"}
+	main := &Credential{Username: "bob", Password: "pw"}
+	ctx := ContextWithCredential(context.Background(), mirror, "https://mirror.example.org/a.iso")
+	ctx = ContextWithCredential(ctx, main, "https://example.com/a.iso", "https://mirror.example.org/a.iso")
+
+	a := newAuthTransport(http.DefaultTransport, nil)
+	for url, want := range map[string]*Credential{
+		"https://example.com/a.iso":        main,
+		"https://mirror.example.org/a.iso": mirror, // 先加入的凭据优先
+		"https://other.example.net/a.iso":  nil,
+	} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		require.NoError(t, err)
+		assert.Equal(t, want, a.credential(req), url)
+	}
+}
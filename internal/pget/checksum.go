@@ -0,0 +1,82 @@
+package pget
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrChecksumMismatch 合并后的文件与期望的摘要不一致
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// checksum 是期望的文件摘要，在合并分段时顺带计算
+type checksum struct {
+	algo string
+	want []byte
+	hash hash.Hash
+}
+
+// parseChecksum 解析 "sha256:<hex>" 形式的摘要
+func parseChecksum(spec string) (*checksum, error) {
+	algo, value, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, errors.Errorf("invalid checksum %q, want <algorithm>:<hex>", spec)
+	}
+	algo = strings.ToLower(algo)
+	newHash, ok := checksumAlgorithms[algo]
+	if !ok {
+		return nil, errors.Errorf("unsupported checksum algorithm %q", algo)
+	}
+	want, err := hex.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid checksum")
+	}
+	h := newHash()
+	if len(want) != h.Size() {
+		return nil, errors.Errorf("invalid %s checksum length %d", algo, len(want))
+	}
+	return &checksum{algo: algo, want: want, hash: h}, nil
+}
+
+func (c *checksum) verify() error {
+	got := c.hash.Sum(nil)
+	if string(got) != string(c.want) {
+		return fmt.Errorf("%w: %s want %x, got %x", ErrChecksumMismatch, c.algo, c.want, got)
+	}
+	return nil
+}
+
+// ValidateChecksum 检查 "sha256:<hex>" 形式的摘要是否合法
+func ValidateChecksum(spec string) error {
+	_, err := parseChecksum(spec)
+	return err
+}
+
+// WithChecksum 合并完成后校验文件摘要，spec 形如 "sha256:<hex>"
+func WithChecksum(spec string) DownloadOption {
+	return func(c *DownloadConfig) {
+		if spec == "" {
+			return
+		}
+		sum, err := parseChecksum(spec)
+		if err != nil {
+			c.optionErr = err
+			return
+		}
+		c.checksum = sum
+	}
+}
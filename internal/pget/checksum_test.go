@@ -0,0 +1,67 @@
+package pget
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadHeaderAndChecksum(t *testing.T) {
+	data := make([]byte, 128*1024)
+	rand.New(rand.NewSource(3)).Read(data)
+	sum := sha256.Sum256(data)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Now(), bytes.NewReader(data))
+	}))
+	t.Cleanup(ts.Close)
+
+	header, err := parseHeaders([]string{"Authorization: Bearer t0ken"})
+	require.NoError(t, err)
+
+	download := func(spec string) (string, error) {
+		dir := t.TempDir()
+		err := Download(context.Background(), &DownloadConfig{
+			Filename:      "file.bin",
+			Dirname:       dir,
+			ContentLength: int64(len(data)),
+			Procs:         2,
+			URLs:          []string{ts.URL},
+			Client:        newDownloadClient(2),
+		}, WithHeader(header), WithChecksum(spec))
+		return filepath.Join(dir, "file.bin"), err
+	}
+
+	path, err := download("sha256:" + hex.EncodeToString(sum[:]))
+	require.NoError(t, err)
+	got, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	sum[0] ^= 0xff
+	_, err = download("SHA256:" + hex.EncodeToString(sum[:]))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	_, err = download("crc32:00")
+	assert.ErrorContains(t, err, "unsupported checksum algorithm")
+	_, err = download("sha256:abcd")
+	assert.ErrorContains(t, err, "invalid sha256 checksum length")
+
+	_, err = parseHeaders([]string{"no colon"})
+	assert.Error(t, err)
+}
@@ -2,46 +2,72 @@ package pget
 
 import (
 	"context"
-	"fmt"
 	"net"
 	"net/http"
-	"net/url"
 	"runtime"
 	"time"
 )
 
-func NewClientByProxy(maxIdleConnsPerHost int, proxy string) *http.Client {
-	return newDownloadClientByProxy(maxIdleConnsPerHost, proxy)
+// TransportConfig 是下载连接的代理、TLS、认证和 cookie 设置
+type TransportConfig struct {
+	Proxy      string      // 为空时使用环境变量中的代理
+	ProxyRules []ProxyRule // 按域名选择代理，优先于 Proxy
+	TLS        []TLSConfig // 全局和按域名的 TLS 设置
+	// 按域名的认证凭据，ContextWithCredential 指定的凭据优先
+	Credentials []Credential
+	Jar         http.CookieJar // 为 nil 时不发送和保存 cookie
 }
 
-func newDownloadClientByProxy(maxIdleConnsPerHost int, proxy string) *http.Client {
+// NewClientByProxy 创建经过 proxy 的 http.Client，proxy 为空时使用环境变量中的代理
+func NewClientByProxy(maxIdleConnsPerHost int, proxy string, rules ...ProxyRule) (*http.Client, error) {
+	return newDownloadClientByProxy(maxIdleConnsPerHost, proxy, rules...)
+}
+
+// NewHTTPClient 按 c 创建下载用的 http.Client，设置不合法时返回错误
+func NewHTTPClient(maxIdleConnsPerHost int, c TransportConfig) (*http.Client, error) {
+	return newTransportClient(maxIdleConnsPerHost, c)
+}
+
+func newDownloadClientByProxy(maxIdleConnsPerHost int, proxy string, rules ...ProxyRule) (*http.Client, error) {
+	return newTransportClient(maxIdleConnsPerHost, TransportConfig{Proxy: proxy, ProxyRules: rules})
+}
+
+// newTransportClient 按 ProxyRules 为每个目标域名选择代理，未命中时使用 Proxy，
+// 并按 TLS 为每个目标域名选择证书设置。
+// 代理配置不合法时返回错误，不会退回到直连。
+func newTransportClient(maxIdleConnsPerHost int, c TransportConfig) (*http.Client, error) {
+	tlsRoutes, err := newTLSRoutes(c.TLS)
+	if err != nil {
+		return nil, err
+	}
 	tr := http.DefaultTransport.(*http.Transport).Clone()
 	dialer := newDialRateLimiter(&net.Dialer{
 		Timeout:   30 * time.Second,
 		KeepAlive: 30 * time.Second,
 	})
-	tr.DialContext = dialer.DialContext
+	router, err := newProxyRouter(c.Proxy, c.ProxyRules, dialer)
+	if err != nil {
+		return nil, err
+	}
+	tr.Proxy = router.proxy
+	tr.DialContext = router.DialContext
 	tr.MaxIdleConns = 0 // no limit
 	tr.MaxIdleConnsPerHost = maxIdleConnsPerHost
 	tr.DisableCompression = true
 
-	if proxy != "" {
-		// Set up proxy
-		proxyUrl, err := url.Parse(proxy)
-		if err != nil {
-			fmt.Fprintf(stdout, "Invalid proxy URL: %v", err)
-		}
-		tr.Proxy = http.ProxyURL(proxyUrl)
+	var rt http.RoundTripper = tr
+	if len(tlsRoutes) > 0 {
+		rt = newTLSTransport(tr, tlsRoutes)
 	}
-
 	return &http.Client{
-		Transport: tr,
-	}
-
+		Transport: newAuthTransport(rt, c.Credentials),
+		Jar:       c.Jar,
+	}, nil
 }
 
 func newDownloadClient(maxIdleConnsPerHost int) *http.Client {
-	return newDownloadClientByProxy(maxIdleConnsPerHost, "")
+	client, _ := newDownloadClientByProxy(maxIdleConnsPerHost, "")
+	return client
 }
 
 func newClient(client *http.Client) *http.Client {
@@ -73,6 +99,10 @@ func newDialRateLimiter(dialer *net.Dialer) *dialRateLimiter {
 	}
 }
 
+func (d *dialRateLimiter) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
 func (d *dialRateLimiter) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
 	d.sem <- struct{}{}
 	defer func() { <-d.sem }()
@@ -0,0 +1,169 @@
+package pget
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// httpOnlyPrefix 是 curl 和浏览器导出工具标记 HttpOnly cookie 的域名前缀
+const httpOnlyPrefix = "#HttpOnly_"
+
+// Cookie 是 Netscape cookies.txt 中的一条记录
+type Cookie struct {
+	Domain     string    `json:"domain"`     // 不带前导点
+	Subdomains bool      `json:"subdomains"` // 是否也发给子域名
+	Path       string    `json:"path"`
+	Secure     bool      `json:"secure"`
+	HTTPOnly   bool      `json:"httpOnly"`
+	Expires    time.Time `json:"expires"` // 零值表示会话 cookie
+	Name       string    `json:"name"`
+	Value      string    `json:"-"`
+}
+
+// NewCookieJar 创建使用公共后缀列表的 cookie jar，避免 cookie 被设置到 com 这样的顶级域名
+func NewCookieJar() *cookiejar.Jar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return jar
+}
+
+// WithCookieJar 让探测和分段请求带上 jar 中的 cookie，并把响应中的 Set-Cookie 写回 jar
+func WithCookieJar(jar http.CookieJar) ClientOption {
+	return func(c *Client) {
+		c.jar = jar
+	}
+}
+
+// ParseCookies 解析 curl -c 和浏览器导出工具生成的 Netscape cookies.txt，
+// 格式错误时返回带行号的错误，已过期的 cookie 被跳过
+func ParseCookies(r io.Reader) ([]Cookie, error) {
+	var cookies []Cookie
+	now := time.Now()
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		if httpOnly {
+			line = line[len(httpOnlyPrefix):]
+		}
+		if strings.TrimSpace(line) == "" || (!httpOnly && strings.HasPrefix(line, "#")) {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) == 6 {
+			// 一些导出工具省略空的值
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("cookies line %d: expected 7 tab-separated fields, got %d", n, len(fields))
+		}
+		subdomains, err := parseCookieBool(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("cookies line %d: %w", n, err)
+		}
+		secure, err := parseCookieBool(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("cookies line %d: %w", n, err)
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cookies line %d: invalid expiry %q", n, fields[4])
+		}
+		c := Cookie{
+			Domain:     strings.TrimPrefix(strings.ToLower(fields[0]), "."),
+			Subdomains: subdomains || strings.HasPrefix(fields[0], "."),
+			Path:       fields[2],
+			Secure:     secure,
+			HTTPOnly:   httpOnly,
+			Name:       fields[5],
+			Value:      fields[6],
+		}
+		if c.Domain == "" || c.Name == "" {
+			return nil, fmt.Errorf("cookies line %d: domain and name are required", n)
+		}
+		if c.Path == "" {
+			c.Path = "/"
+		}
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+			if c.Expires.Before(now) {
+				continue
+			}
+		}
+		cookies = append(cookies, c)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return cookies, nil
+}
+
+func parseCookieBool(s string) (bool, error) {
+	switch strings.ToUpper(s) {
+	case "TRUE":
+		return true, nil
+	case "FALSE":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected TRUE or FALSE, got %q", s)
+}
+
+// WriteCookies 以 Netscape cookies.txt 格式写出 cookies，可以被 ParseCookies 和 curl -b 读取
+func WriteCookies(w io.Writer, cookies []Cookie) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("# Netscape HTTP Cookie File\n")
+	for _, c := range cookies {
+		domain := c.Domain
+		if c.Subdomains {
+			domain = "." + domain
+		}
+		if c.HTTPOnly {
+			domain = httpOnlyPrefix + domain
+		}
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, cookieBool(c.Subdomains), c.Path,
+			cookieBool(c.Secure), expires, c.Name, c.Value)
+	}
+	return bw.Flush()
+}
+
+func cookieBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// SetCookies 把 cookies 放入 jar，同名的 cookie 被覆盖
+func SetCookies(jar http.CookieJar, cookies []Cookie) {
+	for _, c := range cookies {
+		u := &url.URL{Scheme: "http", Host: c.Domain, Path: c.Path}
+		if c.Secure {
+			u.Scheme = "https"
+		}
+		hc := &http.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HttpOnly: c.HTTPOnly,
+			Expires:  c.Expires,
+		}
+		if c.Subdomains {
+			hc.Domain = c.Domain
+		}
+		jar.SetCookies(u, []*http.Cookie{hc})
+	}
+}
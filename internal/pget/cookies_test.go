@@ -0,0 +1,111 @@
+package pget
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCookies(t *testing.T) {
+	const txt = "# Netscape HTTP Cookie File\r\n" +
+		"\n" +
+		".example.com\tTRUE\t/\tFALSE\t0\tsession\tabc\r\n" +
+		"#HttpOnly_files.example.com\tFALSE\t/dl\tTRUE\t4102444800\ttoken\tx=y\n" +
+		"example.com\tFALSE\t/\tFALSE\t1\texpired\tz\n" +
+		"example.org\tFALSE\t/\tFALSE\t0\tempty\n"
+	cookies, err := ParseCookies(strings.NewReader(txt))
+	require.NoError(t, err)
+	require.Len(t, cookies, 3)
+	assert.Equal(t, Cookie{Domain: "example.com", Subdomains: true, Path: "/", Name: "session", Value: "abc"}, cookies[0])
+	assert.Equal(t, Cookie{Domain: "files.example.com", Path: "/dl", Secure: true, HTTPOnly: true,
+		Expires: time.Unix(4102444800, 0), Name: "token", Value: "x=y"}, cookies[1])
+	assert.Equal(t, "", cookies[2].Value)
+
+	// 写出后可以原样读回
+	var buf bytes.Buffer
+	require.NoError(t, WriteCookies(&buf, cookies))
+	again, err := ParseCookies(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, cookies, again)
+
+	for _, bad := range []string{
+		"example.com\tFALSE\t/\tFALSE\t0",
+		"example.com\tyes\t/\tFALSE\t0\ta\tb",
+		"example.com\tFALSE\t/\tFALSE\tnever\ta\tb",
+		"\tFALSE\t/\tFALSE\t0\ta\tb",
+	} {
+		_, err := ParseCookies(strings.NewReader("# c\n" + bad + "\n"))
+		assert.ErrorContains(t, err, "line 2", bad)
+	}
+}
+
+func TestSetCookies(t *testing.T) {
+	jar := NewCookieJar()
+	SetCookies(jar, []Cookie{
+		{Domain: "example.com", Subdomains: true, Path: "/", Name: "a", Value: "1"},
+		{Domain: "example.com", Path: "/", Name: "b", Value: "2"},
+		{Domain: "example.com", Path: "/", Secure: true, Name: "c", Value: "3"},
+	})
+	names := func(rawURL string) []string {
+		u, _ := url.Parse(rawURL)
+		var got []string
+		for _, c := range jar.Cookies(u) {
+			got = append(got, c.Name)
+		}
+		return got
+	}
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, names("https://example.com/x"))
+	assert.ElementsMatch(t, []string{"a", "b"}, names("http://example.com/x"))
+	// 只有 Subdomains 的 cookie 发给子域名
+	assert.ElementsMatch(t, []string{"a"}, names("http://cdn.example.com/x"))
+	assert.Empty(t, names("http://example.org/x"))
+}
+
+func TestDownloadWithCookies(t *testing.T) {
+	data := bytes.Repeat([]byte("cookie"), 20*1024)
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Header.Get("Cookie"))
+		mu.Unlock()
+		if c, err := r.Cookie("session"); err != nil || c.Value != "abc" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		// 探测请求下发的 cookie 之后的分段请求必须带上
+		if r.Header.Get("Range") == "" || r.Header.Get("Range") == "bytes=0-0" {
+			http.SetCookie(w, &http.Cookie{Name: "edge", Value: "42", Path: "/"})
+		} else if c, err := r.Cookie("edge"); err != nil || c.Value != "42" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "data.bin", time.Now(), bytes.NewReader(data))
+	}))
+	defer ts.Close()
+
+	_, err := download(t, ts.URL+"/data.bin")
+	assert.Error(t, err)
+
+	jar := NewCookieJar()
+	cookies, err := ParseCookies(strings.NewReader("127.0.0.1\tFALSE\t/\tFALSE\t0\tsession\tabc\n"))
+	require.NoError(t, err)
+	SetCookies(jar, cookies)
+	got, err := download(t, ts.URL+"/data.bin", WithCookieJar(jar))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, seen[len(seen)-1], "edge=42")
+}
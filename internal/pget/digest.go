@@ -0,0 +1,101 @@
+package pget
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// 服务端给出摘要的响应头，按优先级排列
+const (
+	FromReprDigest    = "repr-digest"    // Repr-Digest（RFC 9530）
+	FromContentDigest = "content-digest" // Content-Digest（RFC 9530）
+	FromDigest        = "digest"         // Digest（RFC 3230）
+	FromContentMD5    = "content-md5"    // Content-MD5（RFC 1864）
+)
+
+// digestAlgorithms 把 RFC 3230 中的算法名映射到 checksumAlgorithms 中的名字
+var digestAlgorithms = map[string]string{
+	"md5":     "md5",
+	"sha":     "sha1",
+	"sha-256": "sha256",
+	"sha-512": "sha512",
+}
+
+// reprDigestAlgorithms 是 RFC 9530 中未被废弃的算法
+var reprDigestAlgorithms = map[string]string{
+	"sha-256": "sha256",
+	"sha-512": "sha512",
+}
+
+// responseChecksum 返回响应头给出的整个文件的摘要和来源。Content-Digest 和 Content-MD5
+// 描述的是响应体，只在 final 为 true（不是重定向）、GET 等请求返回 200 且没有压缩时采用；
+// HEAD 响应没有响应体，其中的 Content-Digest 是空内容的摘要（RFC 9530）。
+func responseChecksum(resp *http.Response, final bool) (string, string) {
+	if sum := parseReprDigest(resp.Header.Values("Repr-Digest")); sum != "" && final {
+		return sum, FromReprDigest
+	}
+	whole := final && resp.StatusCode == http.StatusOK && resp.Request.Method != http.MethodHead &&
+		(resp.Header.Get("Content-Encoding") == "" || strings.EqualFold(resp.Header.Get("Content-Encoding"), "identity"))
+	if sum := parseReprDigest(resp.Header.Values("Content-Digest")); sum != "" && whole {
+		return sum, FromContentDigest
+	}
+	if sum := parseDigest(resp.Header.Values("Digest")); sum != "" {
+		return sum, FromDigest
+	}
+	if sum := contentMD5(resp.Header.Get("Content-MD5")); sum != "" && whole {
+		return sum, FromContentMD5
+	}
+	return "", ""
+}
+
+// parseReprDigest 从 Repr-Digest 或 Content-Digest 中选出支持的最强摘要，
+// 值是结构化字段中的字节序列，形如 sha-256=:<base64>:
+func parseReprDigest(values []string) string {
+	return strongest(values, reprDigestAlgorithms, func(v string) string {
+		if len(v) < 2 || v[0] != ':' || v[len(v)-1] != ':' {
+			return ""
+		}
+		return v[1 : len(v)-1]
+	})
+}
+
+// parseDigest 从 Digest 头中选出支持的最强摘要，形如 "sha256:<hex>"，没有时为空
+func parseDigest(values []string) string {
+	return strongest(values, digestAlgorithms, func(v string) string { return v })
+}
+
+// contentMD5 把 Content-MD5 转换为 "md5:<hex>"，不合法时为空
+func contentMD5(v string) string {
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+	if err != nil || len(sum) != checksumAlgorithms["md5"]().Size() {
+		return ""
+	}
+	return "md5:" + hex.EncodeToString(sum)
+}
+
+// strongest 解析逗号分隔的 算法=值 列表，unwrap 取出 base64 编码的摘要
+func strongest(values []string, algorithms map[string]string, unwrap func(string) string) string {
+	var (
+		checksum string
+		best     int
+	)
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+			algo := algorithms[strings.ToLower(strings.TrimSpace(name))]
+			if !ok || algo == "" || hashStrength[algo] <= best {
+				continue
+			}
+			// 结构化字段的参数在分号之后
+			value, _, _ = strings.Cut(value, ";")
+			sum, err := base64.StdEncoding.DecodeString(unwrap(strings.TrimSpace(value)))
+			if err != nil || len(sum) != checksumAlgorithms[algo]().Size() {
+				continue
+			}
+			checksum, best = algo+":"+hex.EncodeToString(sum), hashStrength[algo]
+		}
+	}
+	return checksum
+}
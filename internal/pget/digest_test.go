@@ -0,0 +1,139 @@
+package pget
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDigest(t *testing.T) {
+	sha := sha256.Sum256([]byte("x"))
+	md := md5.Sum([]byte("x"))
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sha[:]), parseDigest([]string{
+		"MD5=" + base64.StdEncoding.EncodeToString(md[:]),
+		"UNIXsum=30637, SHA-256=" + base64.StdEncoding.EncodeToString(sha[:]),
+	}))
+	assert.Equal(t, "md5:"+hex.EncodeToString(md[:]), parseDigest([]string{"md5=" + base64.StdEncoding.EncodeToString(md[:]) + ",SHA-256=bad"}))
+	assert.Empty(t, parseDigest(nil))
+}
+
+func TestResponseChecksum(t *testing.T) {
+	sha := sha256.Sum256([]byte("x"))
+	sha5 := sha512.Sum512([]byte("x"))
+	md := md5.Sum([]byte("x"))
+	b64 := base64.StdEncoding.EncodeToString
+	sha256sum := "sha256:" + hex.EncodeToString(sha[:])
+
+	for _, tc := range []struct {
+		name   string
+		status int
+		header http.Header
+		final  bool
+		want   string
+		from   string
+	}{
+		{"repr-digest", 200, http.Header{"Repr-Digest": {"sha-256=:" + b64(sha[:]) + ":, md5=:" + b64(md[:]) + ":"}}, true,
+			sha256sum, FromReprDigest},
+		{"strongest", 200, http.Header{"Repr-Digest": {"sha-256=:" + b64(sha[:]) + ":", "sha-512=:" + b64(sha5[:]) + ":"}}, true,
+			"sha512:" + hex.EncodeToString(sha5[:]), FromReprDigest},
+		{"repr-digest wins", 200, http.Header{"Content-Md5": {b64(md[:])}, "Repr-Digest": {"sha-256=:" + b64(sha[:]) + ":"}}, true,
+			sha256sum, FromReprDigest},
+		{"repr-digest of partial content", 206, http.Header{"Repr-Digest": {"sha-256=:" + b64(sha[:]) + ":"}}, true,
+			sha256sum, FromReprDigest},
+		{"content-digest", 200, http.Header{"Content-Digest": {"sha-256=:" + b64(sha[:]) + ":"}}, true,
+			sha256sum, FromContentDigest},
+		{"content-digest of partial content", 206, http.Header{"Content-Digest": {"sha-256=:" + b64(sha[:]) + ":"}}, true, "", ""},
+		{"content-digest of compressed content", 200, http.Header{"Content-Encoding": {"gzip"}, "Content-Digest": {"sha-256=:" + b64(sha[:]) + ":"}}, true, "", ""},
+		{"md5 is deprecated in content-digest", 200, http.Header{"Content-Digest": {"md5=:" + b64(md[:]) + ":"}}, true, "", ""},
+		{"content-md5", 200, http.Header{"Content-Md5": {b64(md[:])}}, true,
+			"md5:" + hex.EncodeToString(md[:]), FromContentMD5},
+		{"content-md5 of a redirect", 302, http.Header{"Content-Md5": {b64(md[:])}}, false, "", ""},
+		{"digest of a redirect", 302, http.Header{"Digest": {"SHA-256=" + b64(sha[:])}}, false,
+			sha256sum, FromDigest},
+		{"malformed", 200, http.Header{"Repr-Digest": {"sha-256=" + b64(sha[:])}, "Content-Md5": {"x"}}, true, "", ""},
+	} {
+		sum, from := responseChecksum(&http.Response{StatusCode: tc.status, Header: tc.header, Request: &http.Request{Method: http.MethodGet}}, tc.final)
+		assert.Equal(t, tc.want, sum, tc.name)
+		assert.Equal(t, tc.from, from, tc.name)
+	}
+
+	// HEAD 响应没有响应体，只采用描述整个文件的 Repr-Digest 和 Digest
+	head := &http.Request{Method: http.MethodHead}
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		want   string
+		from   string
+	}{
+		{"content-digest", http.Header{"Content-Digest": {"sha-256=:" + b64(sha[:]) + ":"}}, "", ""},
+		{"content-md5", http.Header{"Content-Md5": {b64(md[:])}}, "", ""},
+		{"repr-digest", http.Header{"Repr-Digest": {"sha-256=:" + b64(sha[:]) + ":"}}, sha256sum, FromReprDigest},
+		{"digest", http.Header{"Content-Md5": {b64(md[:])}, "Digest": {"SHA-256=" + b64(sha[:])}}, sha256sum, FromDigest},
+	} {
+		sum, from := responseChecksum(&http.Response{StatusCode: 200, Header: tc.header, Request: head}, true)
+		assert.Equal(t, tc.want, sum, "head "+tc.name)
+		assert.Equal(t, tc.from, from, "head "+tc.name)
+	}
+}
+
+func TestClientVerifiesServerDigest(t *testing.T) {
+	data := bytes.Repeat([]byte("digest"), 16*1024)
+	sum := sha256.Sum256(data)
+	reprDigest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Repr-Digest", reprDigest)
+		http.ServeContent(w, r, "data.bin", time.Now(), bytes.NewReader(data))
+	}))
+	t.Cleanup(ts.Close)
+
+	client, err := NewClient()
+	require.NoError(t, err)
+	res, err := client.Download(context.Background(), &Request{URLs: []string{ts.URL + "/data.bin"}, Output: t.TempDir(), Procs: 2})
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), res.Checksum)
+	assert.Equal(t, FromReprDigest, res.ChecksumFrom)
+
+	// 请求中的摘要优先
+	md := md5.Sum(data)
+	res, err = client.Download(context.Background(), &Request{URLs: []string{ts.URL + "/data.bin"}, Output: t.TempDir(), Checksum: "md5:" + hex.EncodeToString(md[:])})
+	require.NoError(t, err)
+	assert.Empty(t, res.ChecksumFrom)
+
+	reprDigest = "sha-256=:" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)) + ":"
+	_, err = client.Download(context.Background(), &Request{URLs: []string{ts.URL + "/data.bin"}, Output: t.TempDir()})
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+func TestHeadContentDigestIgnored(t *testing.T) {
+	data := bytes.Repeat([]byte("digest"), 16*1024)
+	empty := sha256.Sum256(nil)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// HEAD 响应的 Content-Digest 按 RFC 9530 是空响应体的摘要
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(empty[:])+":")
+		}
+		http.ServeContent(w, r, "data.bin", time.Now(), bytes.NewReader(data))
+	}))
+	t.Cleanup(ts.Close)
+
+	client, err := NewClient()
+	require.NoError(t, err)
+	res, err := client.Download(context.Background(), &Request{URLs: []string{ts.URL + "/data.bin"}, Output: t.TempDir(), Procs: 2})
+	require.NoError(t, err)
+	assert.Empty(t, res.ChecksumFrom)
+	got, err := os.ReadFile(res.Path)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
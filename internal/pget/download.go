@@ -1,6 +1,7 @@
 package pget
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"golang.org/x/sync/errgroup"
@@ -9,7 +10,6 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -19,20 +19,30 @@ type assignTasksConfig struct {
 	Procs         int
 	TaskSize      int64 // download filesize per task
 	ContentLength int64 // full download filesize
-	URLs          []string
+	Mirrors       *mirrorSet
 	PartialDir    string
 	Filename      string
 	Client        *http.Client
+	Pool          *ProxyPool
+	Limiters      []*Limiter
 }
 
 type task struct {
 	ID         int
 	Procs      int
-	URL        string
 	Range      Range
+	Size       int64 // bytes left in this part when the task was assigned
 	PartialDir string
 	Filename   string
 	Client     *http.Client
+
+	mirrors  *mirrorSet
+	pool     *ProxyPool // 非 nil 时每个连接从代理池中选择代理
+	limiters []*Limiter
+	progress *tracker
+	observer Observer
+	pieces   *pieceVerifier // 非 nil 时记录数据来自哪个镜像
+	written  int64          // bytes written to the part by this task
 }
 
 func (t *task) destPath() string {
@@ -46,23 +56,55 @@ func (t *task) String() string {
 type makeRequestOption struct {
 	useragent string
 	referer   string
+	header    http.Header
+	method    string // 为空时为 GET
+	body      []byte
+	untrusted map[string]bool // 从 Link 头发现的镜像，请求时不带 header 和 referer
 }
 
-func (t *task) makeRequest(ctx context.Context, opt *makeRequestOption) (*http.Request, error) {
-	req, err := http.NewRequest("GET", t.URL, nil)
+func (t *task) makeRequest(ctx context.Context, url string, opt *makeRequestOption) (*http.Request, error) {
+	// continue from what previous attempts have written
+	r := t.Range
+	r.low += t.written
+	req, err := newRangeRequest(ctx, url, opt, r)
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("failed to make a new request: %d", t.ID))
 	}
-	req = req.WithContext(ctx)
+	return req, nil
+}
 
-	// set download ranges
-	req.Header.Set("Range", t.Range.BytesRange())
+// newRangeRequest 创建下载 r 的请求，带上 opt 中的方法、请求体和请求头
+func newRangeRequest(ctx context.Context, url string, opt *makeRequestOption, r Range) (*http.Request, error) {
+	method := opt.method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if opt.body != nil {
+		body = bytes.NewReader(opt.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
 
 	// set useragent
 	req.Header.Set("User-Agent", opt.useragent)
 
+	// 自定义请求头可以覆盖 User-Agent，但不能覆盖 Range。
+	// Cookie、Authorization 等请求头是给用户指定的地址的，不发给第三方镜像
+	untrusted := opt.untrusted[url]
+	if !untrusted {
+		for k, v := range opt.header {
+			req.Header[k] = v
+		}
+	}
+
+	// set download ranges
+	req.Header.Set("Range", r.BytesRange())
+
 	// set referer
-	if opt.referer != "" {
+	if opt.referer != "" && !untrusted {
 		req.Header.Set("Referer", opt.referer)
 	}
 
@@ -73,13 +115,17 @@ func (t *task) makeRequest(ctx context.Context, opt *makeRequestOption) (*http.R
 func assignTasks(c *assignTasksConfig) []*task {
 	tasks := make([]*task, 0, c.Procs)
 
-	var totalActiveProcs int
 	for i := 0; i < c.Procs; i++ {
 
 		r := makeRange(i, c.Procs, c.TaskSize, c.ContentLength)
 
 		partName := getPartialFilePath(c.PartialDir, c.Filename, c.Procs, i)
 
+		size := r.high - r.low + 1
+		if i == c.Procs-1 {
+			size = r.high - r.low
+		}
+
 		if info, err := os.Stat(partName); err == nil {
 			infosize := info.Size()
 			// check if the part is fully downloaded
@@ -94,19 +140,21 @@ func assignTasks(c *assignTasksConfig) []*task {
 
 			// make low range from this next byte
 			r.low += infosize
+			size -= infosize
 		}
 
 		tasks = append(tasks, &task{
 			ID:         i,
 			Procs:      c.Procs,
-			URL:        c.URLs[totalActiveProcs%len(c.URLs)],
 			Range:      r,
+			Size:       size,
 			PartialDir: c.PartialDir,
 			Filename:   c.Filename,
 			Client:     c.Client,
+			mirrors:    c.Mirrors,
+			pool:       c.Pool,
+			limiters:   c.Limiters,
 		})
-
-		totalActiveProcs++
 	}
 
 	return tasks
@@ -119,10 +167,18 @@ type DownloadConfig struct {
 	Procs         int
 	URLs          []string
 	Client        *http.Client
+	Pool          *ProxyPool // 设置后分段连接经由代理池，而不是 Client
 
 	*makeRequestOption
 
 	ProgressFn ProgressFunc
+	Limiters   []*Limiter
+	Observer   Observer
+
+	checksum  *checksum
+	pieces    *PieceHashes
+	optionErr error           // 选项参数不合法
+	stage     func(EventType) // 进入合并、校验阶段时回调
 }
 
 type DownloadOption func(c *DownloadConfig)
@@ -134,6 +190,17 @@ func WithProgressCallback(fn ProgressFunc) DownloadOption {
 	}
 }
 
+// WithLimiters 为所有连接的读取套上限速器，例如全局限速和任务限速
+func WithLimiters(limiters ...*Limiter) DownloadOption {
+	return func(c *DownloadConfig) {
+		for _, l := range limiters {
+			if l != nil {
+				c.Limiters = append(c.Limiters, l)
+			}
+		}
+	}
+}
+
 func WithUserAgent(ua, version string) DownloadOption {
 	return func(c *DownloadConfig) {
 		if ua == "" {
@@ -149,6 +216,38 @@ func WithReferer(referer string) DownloadOption {
 	}
 }
 
+// withStage 在进入合并、校验阶段时回调 fn
+func withStage(fn func(EventType)) DownloadOption {
+	return func(c *DownloadConfig) {
+		c.stage = fn
+	}
+}
+
+// WithHeader 为每个分段请求附加请求头，例如 Cookie、Authorization
+func WithHeader(header http.Header) DownloadOption {
+	return func(c *DownloadConfig) {
+		c.makeRequestOption.header = header
+	}
+}
+
+// WithMethod 用 method 和 body 发出分段请求，例如需要 POST 表单才能下载的文件
+func WithMethod(method string, body []byte) DownloadOption {
+	return func(c *DownloadConfig) {
+		c.makeRequestOption.method = method
+		c.makeRequestOption.body = body
+	}
+}
+
+// withUntrusted 标记从 Link 头发现的镜像，请求它们时不带 WithHeader 和 WithReferer 设置的请求头
+func withUntrusted(urls []string) DownloadOption {
+	return func(c *DownloadConfig) {
+		c.makeRequestOption.untrusted = make(map[string]bool, len(urls))
+		for _, u := range urls {
+			c.makeRequestOption.untrusted[u] = true
+		}
+	}
+}
+
 func Download(ctx context.Context, c *DownloadConfig, opts ...DownloadOption) error {
 	partialDir := getPartialDirname(c.Dirname, c.Filename, c.Procs)
 
@@ -162,21 +261,51 @@ func Download(ctx context.Context, c *DownloadConfig, opts ...DownloadOption) er
 	for _, opt := range opts {
 		opt(c)
 	}
+	if c.optionErr != nil {
+		return c.optionErr
+	}
+	if c.Observer == nil {
+		c.Observer = nopObserver{}
+	}
+
+	mirrors := newMirrorSet(c.URLs)
+	taskSize := c.ContentLength / int64(c.Procs)
 
 	tasks := assignTasks(&assignTasksConfig{
 		Procs:         c.Procs,
-		TaskSize:      c.ContentLength / int64(c.Procs),
+		TaskSize:      taskSize,
 		ContentLength: c.ContentLength,
-		URLs:          c.URLs,
+		Mirrors:       mirrors,
 		PartialDir:    partialDir,
 		Filename:      c.Filename,
 		Client:        newClient(c.Client),
+		Pool:          c.Pool,
+		Limiters:      c.Limiters,
 	})
 
+	var pieces *pieceVerifier
+	if c.pieces != nil {
+		v, err := newPieceVerifier(c, mirrors, partialDir, taskSize)
+		if err != nil {
+			return err
+		}
+		pieces = v
+	}
+
+	progress := newTracker(c.Procs, taskSize, c.ContentLength, tasks, mirrors)
+	progress.path = filepath.Join(c.Dirname, c.Filename)
+	for _, t := range tasks {
+		t.progress = progress
+		t.observer = c.Observer
+		t.pieces = pieces
+	}
+
 	if err := parallelDownload(ctx, &parallelDownloadConfig{
 		ContentLength:     c.ContentLength,
 		Tasks:             tasks,
 		PartialDir:        partialDir,
+		Progress:          progress,
+		Pieces:            pieces,
 		makeRequestOption: c.makeRequestOption,
 		DownloadConfig:    c,
 	}); err != nil {
@@ -184,13 +313,29 @@ func Download(ctx context.Context, c *DownloadConfig, opts ...DownloadOption) er
 		return err
 	}
 
-	return bindFiles(c, partialDir)
+	if c.stage != nil {
+		c.stage(EventMerge)
+	}
+	start := time.Now()
+	if err := bindFiles(c, partialDir); err != nil {
+		return err
+	}
+	c.Observer.Merged(time.Since(start))
+	if c.checksum != nil {
+		if c.stage != nil {
+			c.stage(EventVerify)
+		}
+		return c.checksum.verify()
+	}
+	return nil
 }
 
 type parallelDownloadConfig struct {
 	ContentLength int64
 	Tasks         []*task
 	PartialDir    string
+	Progress      *tracker
+	Pieces        *pieceVerifier // 非 nil 时每个分段完成后校验其中的分块
 	*makeRequestOption
 
 	*DownloadConfig
@@ -199,81 +344,175 @@ type parallelDownloadConfig struct {
 func parallelDownload(ctx context.Context, c *parallelDownloadConfig) error {
 	eg, ctx := errgroup.WithContext(ctx)
 
-	// check file size already downloaded for resume
-	_, err := checkProgress(c.PartialDir)
-	if err != nil {
-		return errors.Wrap(err, "failed to get directory size")
+	var progressFn ProgressFunc
+	if c.DownloadConfig != nil {
+		progressFn = c.DownloadConfig.ProgressFn
 	}
 
-	// 全局累计已下载字节
-	var downloaded int64
-
-	// 启动采样器，定时计算下载速度
-	sampleInterval := 1500 * time.Millisecond
-	if c.DownloadConfig != nil && c.DownloadConfig.ProgressFn != nil {
-		// 使用一个 goroutine 周期性计算 delta / 秒上报 speed
-		eg.Go(func() error {
-			ticker := time.NewTicker(sampleInterval)
+	// 启动采样器，定时上报进度快照；上报最终进度之前等采样器退出，保证最终进度是最后一次回调
+	stopSampler := func() {}
+	if progressFn != nil {
+		sampleDone := make(chan struct{})
+		sampleExited := make(chan struct{})
+		stopSampler = func() {
+			close(sampleDone)
+			<-sampleExited
+		}
+		go func() {
+			defer close(sampleExited)
+			ticker := time.NewTicker(progressInterval)
 			defer ticker.Stop()
-			last := int64(0)
-			lastTime := time.Now()
 			for {
 				select {
 				case <-ctx.Done():
-					return ctx.Err()
+					return
+				case <-sampleDone:
+					return
 				case t := <-ticker.C:
-					cur := atomic.LoadInt64(&downloaded)
-					delta := cur - last
-					elapsed := t.Sub(lastTime).Seconds()
-					speed := int64(0)
-					if delta > 0 && elapsed > 0 {
-						speed = int64(float64(delta) / elapsed)
-					}
-					c.DownloadConfig.ProgressFn(cur, c.ContentLength, speed)
-					// 如果已经完成，退出采样器
-					if c.ContentLength > 0 && cur >= c.ContentLength {
-						return nil
-					}
-					last = cur
-					lastTime = t
+					progressFn(c.Progress.snapshot(t))
 				}
 			}
+		}()
+	}
+
+	if c.Pieces != nil {
+		// 续传前已经下载完整的分段没有任务，直接校验
+		pending := make(map[int]bool, len(c.Tasks))
+		for _, task := range c.Tasks {
+			pending[task.ID] = true
+		}
+		var done []int
+		for i := 0; i < c.Pieces.procs; i++ {
+			if !pending[i] {
+				done = append(done, i)
+			}
+		}
+		eg.Go(func() error {
+			return c.Pieces.segmentsDone(ctx, done...)
 		})
 	}
 
 	for _, task := range c.Tasks {
 		task := task
 		eg.Go(func() error {
-			req, err := task.makeRequest(ctx, c.makeRequestOption)
-			if err != nil {
+			if err := task.run(ctx, c.makeRequestOption); err != nil {
 				return err
 			}
-			return task.downloadWithProgress(req, &downloaded, c.ContentLength, c.DownloadConfig.ProgressFn)
+			return c.Pieces.segmentsDone(ctx, task.ID)
 		})
 	}
 
-	err = eg.Wait()
+	err := eg.Wait()
+	stopSampler()
 
-	// 最后确保上报 100% 且 speed=0
-	if c.DownloadConfig != nil && c.DownloadConfig.ProgressFn != nil {
-		cur := atomic.LoadInt64(&downloaded)
-		c.DownloadConfig.ProgressFn(cur, c.ContentLength, 0)
+	// 最后确保上报最终进度且 speed=0
+	if progressFn != nil {
+		progressFn(c.Progress.final())
 	}
 
 	return err
 }
 
-func (t *task) downloadWithProgress(
-	req *http.Request,
-	downloaded *int64,
-	total int64,
-	progressFn ProgressFunc,
-) error {
-	resp, err := t.Client.Do(req)
+const (
+	// 单个分段在所有镜像上累计的最大尝试次数
+	maxSegmentAttempts = 5
+	// 超过该时长没有收到任何数据，认为连接卡死
+	stallTimeout = 20 * time.Second
+	// 每隔多久采样一次连接速度
+	speedSampleInterval = 2 * time.Second
+	// 连接建立后先观察一段时间再判断是否过慢
+	slowGracePeriod = 6 * time.Second
+	// 剩余字节不足时不值得换镜像
+	minReassignBytes = 1 << 20
+)
+
+var (
+	errMirrorStalled = errors.New("mirror stalled")
+	errMirrorSlow    = errors.New("mirror too slow")
+)
+
+// run 下载该分段，失败、卡死或过慢时把剩余部分转给其它镜像继续
+func (t *task) run(ctx context.Context, opt *makeRequestOption) error {
+	var (
+		last    *mirror
+		lastErr error
+	)
+	for attempt := 0; attempt < maxSegmentAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				t.progress.setState(t.ID, SegmentPending, "")
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * 500 * time.Millisecond):
+			}
+		}
+
+		m := t.mirrors.pick(last)
+		t.progress.setState(t.ID, SegmentActive, m.url)
+		err := t.downloadFrom(ctx, m, opt)
+		t.mirrors.release(m, err)
+		if err == nil {
+			t.progress.setState(t.ID, SegmentDone, "")
+			return nil
+		}
+		if ctx.Err() != nil {
+			t.progress.setState(t.ID, SegmentPending, "")
+			return err
+		}
+		log.Printf("%s: %s failed, reassigning: %v", t, m.url, err)
+		t.observer.Retry(m.host, err)
+		t.progress.setState(t.ID, SegmentRetrying, "")
+		last, lastErr = m, err
+	}
+	t.progress.setState(t.ID, SegmentFailed, "")
+	return errors.Wrapf(lastErr, "gave up after %d attempts: %q", maxSegmentAttempts, t.String())
+}
+
+// downloadFrom 从指定镜像下载分段中尚未写入的部分
+func (t *task) downloadFrom(ctx context.Context, m *mirror, opt *makeRequestOption) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	stall := time.AfterFunc(stallTimeout, func() { cancel(errMirrorStalled) })
+	defer stall.Stop()
+
+	req, err := t.makeRequest(ctx, m.url, opt)
 	if err != nil {
-		return errors.Wrapf(err, "failed to get response: %q", t.String())
+		return err
+	}
+	client := t.Client
+	var px *poolProxy
+	if t.pool != nil {
+		px = t.pool.acquire()
+		defer t.pool.release(px)
+		client = px.client
+	}
+	return t.downloadWithProgress(req, client, m, px, stall)
+}
+
+func (t *task) downloadWithProgress(req *http.Request, client *http.Client, m *mirror, px *poolProxy, stall *time.Timer) error {
+	// 被 stall 定时器取消时，返回更明确的原因
+	cause := func(err error) error {
+		if c := context.Cause(req.Context()); c == errMirrorStalled {
+			return c
+		}
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if req.Context().Err() == nil {
+		// 只有连接本身失败才算代理的问题，取消和卡死不算
+		t.pool.report(px, err)
+	}
+	if err != nil {
+		return errors.Wrapf(cause(err), "failed to get response: %q", t.String())
 	}
 	defer resp.Body.Close()
+	t.observer.Response(m.host, resp.StatusCode)
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return errors.Errorf("unexpected status %q: %q", resp.Status, t.String())
+	}
 
 	f, err := os.OpenFile(t.destPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
@@ -281,84 +520,52 @@ func (t *task) downloadWithProgress(
 	}
 	defer f.Close()
 
+	body := newLimitedReader(req.Context(), resp.Body, stall, t.limiters)
+	t.pieces.record(t.ID, t.Range.low+t.written, m)
+
+	start := time.Now()
+	lastSample, sampled := start, t.written
+
 	buf := make([]byte, 32*1024)
 	for {
-		n, readErr := resp.Body.Read(buf)
+		n, readErr := body.Read(buf)
 		if n > 0 {
+			stall.Reset(stallTimeout)
 			if _, writeErr := f.Write(buf[:n]); writeErr != nil {
 				return errors.Wrapf(writeErr, "write error: %q", t.String())
 			}
-			// 原子累加，并触发回调
-			newTotal := atomic.AddInt64(downloaded, int64(n))
-			if progressFn != nil {
-				// 只上报下载进度，不上报速度
-				progressFn(newTotal, total, -1)
-			}
+			t.written += int64(n)
+			t.mirrors.addBytes(m, int64(n))
+			t.pool.addBytes(px, int64(n))
+			t.progress.add(t.ID, int64(n))
+			t.observer.Bytes(m.host, int64(n))
 		}
 		if readErr == io.EOF {
+			if t.written < t.Size {
+				return errors.Wrapf(io.ErrUnexpectedEOF, "read error: %q", t.String())
+			}
 			break
 		}
 		if readErr != nil {
-			return errors.Wrapf(readErr, "read error: %q", t.String())
+			return errors.Wrapf(cause(readErr), "read error: %q", t.String())
+		}
+
+		// 周期性测速，明显慢于其它镜像时把剩余部分让出去；限速时速度不代表镜像快慢
+		if now := time.Now(); now.Sub(lastSample) >= speedSampleInterval {
+			if !throttled(t.limiters) {
+				speed := float64(t.written-sampled) / now.Sub(lastSample).Seconds()
+				slow := t.mirrors.sample(m, speed)
+				if slow && now.Sub(start) >= slowGracePeriod && t.Size-t.written >= minReassignBytes {
+					return errMirrorSlow
+				}
+			}
+			lastSample, sampled = now, t.written
 		}
 	}
 	return nil
 }
 
-//func parallelDownload(ctx context.Context, c *parallelDownloadConfig) error {
-//	eg, ctx := errgroup.WithContext(ctx)
-//
-//	bar := pb.Start64(c.ContentLength).SetWriter(stdout).Set(pb.Bytes, true)
-//	defer bar.Finish()
-//
-//	// check file size already downloaded for resume
-//	size, err := checkProgress(c.PartialDir)
-//	if err != nil {
-//		return errors.Wrap(err, "failed to get directory size")
-//	}
-//
-//	bar.SetCurrent(size)
-//
-//	for _, task := range c.Tasks {
-//		task := task
-//		eg.Go(func() error {
-//			req, err := task.makeRequest(ctx, c.makeRequestOption)
-//			if err != nil {
-//				return err
-//			}
-//			return task.download(req, bar)
-//		})
-//	}
-//
-//	return eg.Wait()
-//}
-
-//func (t *task) download(req *http.Request, bar *pb.ProgressBar) error {
-//	resp, err := t.Client.Do(req)
-//	if err != nil {
-//		return errors.Wrapf(err, "failed to get response: %q", t.String())
-//	}
-//	defer resp.Body.Close()
-//
-//	output, err := os.OpenFile(t.destPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
-//	if err != nil {
-//		return errors.Wrapf(err, "failed to create: %q", t.String())
-//	}
-//	defer output.Close()
-//
-//	//rd := bar.NewProxyReader(resp.Body)
-//
-//	//if _, err := io.Copy(output, rd); err != nil {
-//	if _, err := io.Copy(output, resp.Body); err != nil {
-//		return errors.Wrapf(err, "failed to write response body: %q", t.String())
-//	}
-//
-//	return nil
-//}
-
 func bindFiles(c *DownloadConfig, partialDir string) error {
-	//log.Println("start bind files, target file:", c.Dirname+c.Filename)
-
 	destPath := filepath.Join(c.Dirname, c.Filename)
 	f, err := os.Create(destPath)
 	if err != nil {
@@ -366,7 +573,12 @@ func bindFiles(c *DownloadConfig, partialDir string) error {
 	}
 	defer f.Close()
 
-	//bar := pb.Start64(c.ContentLength).SetWriter(stdout)
+	// 需要校验时在合并的同时计算摘要，避免再读一遍文件
+	var w io.Writer = f
+	if c.checksum != nil {
+		c.checksum.hash.Reset()
+		w = io.MultiWriter(f, c.checksum.hash)
+	}
 
 	copyFn := func(name string) error {
 		subfp, err := os.Open(name)
@@ -376,8 +588,7 @@ func bindFiles(c *DownloadConfig, partialDir string) error {
 
 		defer subfp.Close()
 
-		//proxy := bar.NewProxyReader(subfp)
-		if _, err := io.Copy(f, subfp); err != nil {
+		if _, err := io.Copy(w, subfp); err != nil {
 			return errors.Wrapf(err, "failed to copy %q", name)
 		}
 
@@ -396,8 +607,6 @@ func bindFiles(c *DownloadConfig, partialDir string) error {
 		}
 	}
 
-	//bar.Finish()
-
 	// remove download location
 	// RemoveAll reason: will create .DS_Store in download location if execute on mac
 	if err := os.RemoveAll(partialDir); err != nil {
@@ -0,0 +1,160 @@
+package pget
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxDuplicates 是从 Link 头中采纳的最多镜像数
+const maxDuplicates = 8
+
+// advertised 从响应以及导致它的重定向响应中收集 Link: rel=duplicate 镜像（RFC 6249）
+// 和整个文件的摘要。MirrorBrain 等重定向器把它们放在 302 响应上。
+func advertised(resp *http.Response) (duplicates []string, checksum, source string) {
+	var links []duplicateLink
+	for r := resp; r != nil; {
+		for _, v := range r.Header.Values("Link") {
+			links = append(links, parseDuplicateLinks(v, r.Request.URL)...)
+		}
+		if checksum == "" {
+			checksum, source = responseChecksum(r, r == resp)
+		}
+		r = r.Request.Response
+	}
+	sort.SliceStable(links, func(i, j int) bool { return links[i].pri < links[j].pri })
+	for _, l := range links {
+		duplicates = append(duplicates, l.url)
+	}
+	return duplicates, checksum, source
+}
+
+// duplicateLink 是 Link 头中的一个镜像，pri 越小越优先，未设置时排在最后
+type duplicateLink struct {
+	url string
+	pri int
+}
+
+// parseDuplicateLinks 解析一个 Link 头中 rel=duplicate 的链接，相对地址按 base 解析
+func parseDuplicateLinks(v string, base *url.URL) []duplicateLink {
+	var links []duplicateLink
+	for _, part := range splitLinks(v) {
+		part = strings.TrimSpace(part)
+		if !strings.HasPrefix(part, "<") {
+			continue
+		}
+		end := strings.Index(part, ">")
+		if end < 0 {
+			continue
+		}
+		target, params := part[1:end], part[end+1:]
+		duplicate, pri := false, int(^uint(0)>>1)
+		for _, p := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "rel":
+				for _, rel := range strings.Fields(value) {
+					duplicate = duplicate || strings.EqualFold(rel, "duplicate")
+				}
+			case "pri":
+				if n, err := strconv.Atoi(value); err == nil {
+					pri = n
+				}
+			}
+		}
+		if !duplicate {
+			continue
+		}
+		u, err := base.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		links = append(links, duplicateLink{url: u.String(), pri: pri})
+	}
+	return links
+}
+
+// splitLinks 按逗号拆分 Link 头，忽略 <> 和引号中的逗号
+func splitLinks(v string) []string {
+	var (
+		parts         []string
+		start         int
+		inURL, quoted bool
+	)
+	for i, c := range v {
+		switch {
+		case c == '<' && !quoted:
+			inURL = true
+		case c == '>' && !quoted:
+			inURL = false
+		case c == '"' && !inURL:
+			quoted = !quoted
+		case c == ',' && !inURL && !quoted:
+			parts = append(parts, v[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, v[start:])
+}
+
+// discoverMirrors 探测服务端通过 Link 头给出的镜像，只保留大小与 infos 一致且尚未在使用的镜像。
+// 探测请求不带 c.Header，凭据只用于用户指定的地址所在的域名。
+func discoverMirrors(ctx context.Context, client *http.Client, c *CheckConfig, infos []*mirrorInfo) []*mirrorInfo {
+	known := map[string]bool{}
+	for _, u := range c.URLs {
+		known[u] = true
+	}
+	retrieved := map[string]bool{}
+	for _, info := range infos {
+		known[info.RetrievedURL] = true
+		retrieved[info.RetrievedURL] = true
+	}
+	var candidates []string
+	for _, info := range infos {
+		for _, u := range info.Duplicates {
+			if !known[u] && len(candidates) < maxDuplicates {
+				known[u] = true
+				candidates = append(candidates, u)
+			}
+		}
+	}
+
+	// 镜像可能在其它域名，不带给用户指定的地址的请求头
+	bare := *c
+	bare.Header = nil
+	found := make([]*mirrorInfo, len(candidates))
+	var wg sync.WaitGroup
+	for i, u := range candidates {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			info, err := getMirrorInfo(ctx, client, u, &bare)
+			if err != nil {
+				log.Printf("skip advertised mirror %s: %v", u, err)
+				return
+			}
+			if info.ContentLength != infos[0].ContentLength {
+				log.Printf("skip advertised mirror %s: size %d does not match %d", u, info.ContentLength, infos[0].ContentLength)
+				return
+			}
+			found[i] = info
+		}(i, u)
+	}
+	wg.Wait()
+
+	// 不同的地址可能重定向到同一个镜像
+	var mirrors []*mirrorInfo
+	for _, info := range found {
+		if info != nil && !retrieved[info.RetrievedURL] {
+			retrieved[info.RetrievedURL] = true
+			mirrors = append(mirrors, info)
+		}
+	}
+	return mirrors
+}
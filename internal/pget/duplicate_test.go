@@ -0,0 +1,151 @@
+package pget
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDuplicateLinks(t *testing.T) {
+	base, _ := url.Parse("http://example.com/pub/file.iso")
+	links := parseDuplicateLinks(`<http://a.example.com/file.iso>; rel=duplicate; pri=2; geo=de, `+
+		`</mirror/file.iso>; rel="duplicate"; pri=1, `+
+		`<http://example.com/file.iso.meta4>; rel=describedby; type="application/metalink4+xml", `+
+		`<ftp://ftp.example.com/file.iso>; rel=duplicate, `+
+		`<http://b.example.com/a,b.iso>; rel="alternate duplicate"`, base)
+	assert.Equal(t, []duplicateLink{
+		{url: "http://a.example.com/file.iso", pri: 2},
+		{url: "http://example.com/mirror/file.iso", pri: 1},
+		{url: "http://b.example.com/a,b.iso", pri: int(^uint(0) >> 1)},
+	}, links)
+}
+
+func TestCheckAdvertisedMirrors(t *testing.T) {
+	data := make([]byte, 128*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	sum := sha256.Sum256(data)
+
+	serve := func(content []byte) *httptest.Server {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "file.bin", time.Now(), bytes.NewReader(content))
+		}))
+		t.Cleanup(ts.Close)
+		return ts
+	}
+	primary := serve(data)
+	mirror := serve(data)
+	truncated := serve(data[:1024])
+	down := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(down.Close)
+
+	// 与 MirrorBrain 一样，镜像和摘要放在重定向响应上
+	digest := base64.StdEncoding.EncodeToString(sum[:])
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", fmt.Sprintf("<%s/file.bin>; rel=duplicate; pri=1", mirror.URL))
+		w.Header().Add("Link", fmt.Sprintf("<%s/file.bin>; rel=duplicate; pri=2, <%s/file.bin>; rel=duplicate; pri=3", truncated.URL, down.URL))
+		w.Header().Set("Digest", "SHA-256="+digest)
+		http.Redirect(w, r, primary.URL+"/file.bin", http.StatusFound)
+	}))
+	t.Cleanup(redirector.Close)
+
+	target, err := Check(context.Background(), &CheckConfig{
+		URLs:    []string{redirector.URL + "/file.bin"},
+		Timeout: 5 * time.Second,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{primary.URL + "/file.bin", mirror.URL + "/file.bin"}, target.URLs)
+	assert.Equal(t, []string{mirror.URL + "/file.bin"}, target.Discovered)
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), target.Checksum)
+
+	client, err := NewClient()
+	require.NoError(t, err)
+	res, err := client.Download(context.Background(), &Request{URLs: []string{redirector.URL + "/file.bin"}, Output: t.TempDir(), Procs: 2})
+	require.NoError(t, err)
+	assert.Equal(t, target.URLs, res.URLs)
+	got, err := os.ReadFile(res.Path)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// 服务端给出的摘要与内容不一致时下载失败
+	digest = base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	_, err = client.Download(context.Background(), &Request{URLs: []string{redirector.URL + "/file.bin"}, Output: t.TempDir()})
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+func TestResumeWithChangedMirrors(t *testing.T) {
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	var (
+		mu     sync.Mutex
+		failed = true // 第一次下载时除第一个分段外全部失败，留下部分进度
+		ranges []string
+	)
+	serve := func(w http.ResponseWriter, r *http.Request) {
+		rng := r.Header.Get("Range")
+		mu.Lock()
+		fail := failed && rng != "" && !strings.HasPrefix(rng, "bytes=0-")
+		ranges = append(ranges, rng)
+		mu.Unlock()
+		if fail {
+			http.Error(w, "try again later", http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Now(), bytes.NewReader(data))
+	}
+	a := httptest.NewServer(http.HandlerFunc(serve))
+	t.Cleanup(a.Close)
+	b := httptest.NewServer(http.HandlerFunc(serve))
+	t.Cleanup(b.Close)
+
+	advertised := []string{a.URL}
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		for i, u := range advertised {
+			w.Header().Add("Link", fmt.Sprintf("<%s/file.bin>; rel=duplicate; pri=%d", u, i+1))
+		}
+		mu.Unlock()
+		serve(w, r)
+	}))
+	t.Cleanup(primary.Close)
+
+	client, err := NewClient()
+	require.NoError(t, err)
+	req := &Request{URLs: []string{primary.URL + "/file.bin"}, Output: t.TempDir(), Procs: 2}
+	_, err = client.Download(context.Background(), req)
+	require.Error(t, err)
+
+	mu.Lock()
+	failed = false
+	advertised = []string{a.URL, b.URL}
+	ranges = nil
+	mu.Unlock()
+
+	res, err := client.Download(context.Background(), req)
+	require.NoError(t, err)
+	assert.Len(t, res.URLs, 3)
+	got, err := os.ReadFile(res.Path)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	assert.NotEmpty(t, ranges)
+	// 第一个分段已在第一次下载完成，续传时不应再请求
+	for _, rng := range ranges {
+		assert.False(t, strings.HasPrefix(rng, "bytes=0-") && rng != "bytes=0-0", "segment refetched: %s", rng)
+	}
+}
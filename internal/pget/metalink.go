@@ -0,0 +1,151 @@
+package pget
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MetalinkFile 是 Metalink 中描述的一个文件
+type MetalinkFile struct {
+	Name     string
+	Size     int64        // 未知时为 0
+	URLs     []string     // 按优先级排序，只包含 http 和 https 地址
+	Checksum string       // 支持的最强摘要，形如 "sha256:<hex>"，没有时为空
+	Pieces   *PieceHashes // 支持的最强分块摘要，没有时为 nil
+}
+
+// hashStrength 是支持的摘要算法，数值越大越优先
+var hashStrength = map[string]int{"md5": 1, "sha1": 2, "sha256": 3, "sha512": 4}
+
+type metalinkXML struct {
+	Files  []metalinkFileXML `xml:"file"`       // Metalink 4 (RFC 5854)
+	Files3 []metalinkFileXML `xml:"files>file"` // Metalink 3
+}
+
+type metalinkFileXML struct {
+	Name   string           `xml:"name,attr"`
+	Size   int64            `xml:"size"`
+	Hashes []metalinkHash   `xml:"hash"`
+	Pieces []metalinkPieces `xml:"pieces"`
+	URLs   []metalinkURL    `xml:"url"`
+
+	// Metalink 3 把摘要放在 verification 中，地址放在 resources 中
+	Verification struct {
+		Hashes []metalinkHash   `xml:"hash"`
+		Pieces []metalinkPieces `xml:"pieces"`
+	} `xml:"verification"`
+	Resources []metalinkURL `xml:"resources>url"`
+}
+
+type metalinkHash struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type metalinkPieces struct {
+	Type   string         `xml:"type,attr"`
+	Length int64          `xml:"length,attr"`
+	Hashes []metalinkHash `xml:"hash"`
+}
+
+type metalinkURL struct {
+	Priority   int    `xml:"priority,attr"`   // Metalink 4，越小越优先
+	Preference int    `xml:"preference,attr"` // Metalink 3，越大越优先
+	Value      string `xml:",chardata"`
+}
+
+// ParseMetalink 解析 Metalink 4 (.meta4) 或 Metalink 3 (.metalink)，
+// 忽略不支持的摘要算法和非 http(s) 的地址，没有可用地址的文件报错
+func ParseMetalink(r io.Reader) ([]MetalinkFile, error) {
+	var doc metalinkXML
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, errors.Wrap(err, "invalid metalink")
+	}
+	if len(doc.Files) == 0 && len(doc.Files3) == 0 {
+		return nil, errors.New("metalink describes no files")
+	}
+
+	var files []MetalinkFile
+	for _, f := range doc.Files {
+		sort.SliceStable(f.URLs, func(i, j int) bool {
+			return metalinkPriority(f.URLs[i].Priority) < metalinkPriority(f.URLs[j].Priority)
+		})
+		file, err := newMetalinkFile(f.Name, f.Size, f.Hashes, f.Pieces, f.URLs)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	for _, f := range doc.Files3 {
+		sort.SliceStable(f.Resources, func(i, j int) bool {
+			return f.Resources[i].Preference > f.Resources[j].Preference
+		})
+		file, err := newMetalinkFile(f.Name, f.Size, f.Verification.Hashes, f.Verification.Pieces, f.Resources)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// metalinkPriority 把未设置的优先级排在最后
+func metalinkPriority(p int) int {
+	if p <= 0 {
+		return int(^uint(0) >> 1)
+	}
+	return p
+}
+
+func newMetalinkFile(name string, size int64, hashes []metalinkHash, pieces []metalinkPieces, urls []metalinkURL) (MetalinkFile, error) {
+	file := MetalinkFile{Name: strings.TrimSpace(name), Size: size}
+	if file.Name == "" {
+		return file, errors.New("metalink file has no name")
+	}
+	for _, u := range urls {
+		v := strings.TrimSpace(u.Value)
+		if p, err := url.Parse(v); err == nil && (p.Scheme == "http" || p.Scheme == "https") && p.Host != "" {
+			file.URLs = append(file.URLs, v)
+		}
+	}
+	if len(file.URLs) == 0 {
+		return file, errors.Errorf("metalink file %q has no http(s) url", file.Name)
+	}
+
+	best := 0
+	for _, h := range hashes {
+		algo := hashName(h.Type)
+		if s := hashStrength[algo]; s > best {
+			best = s
+			file.Checksum = algo + ":" + strings.ToLower(strings.TrimSpace(h.Value))
+		}
+	}
+
+	best = 0
+	for _, p := range pieces {
+		algo := hashName(p.Type)
+		if s := hashStrength[algo]; s > best && len(p.Hashes) > 0 {
+			best = s
+			file.Pieces = &PieceHashes{Algorithm: algo, Length: p.Length}
+			for _, h := range p.Hashes {
+				file.Pieces.Hashes = append(file.Pieces.Hashes, strings.ToLower(strings.TrimSpace(h.Value)))
+			}
+		}
+	}
+	if file.Pieces != nil {
+		if err := file.Pieces.Validate(file.Size); err != nil {
+			return file, errors.Wrapf(err, "metalink file %q", file.Name)
+		}
+	}
+	return file, nil
+}
+
+// hashName 把 "sha-256"（Metalink 4）和 "sha256"（Metalink 3）统一为 "sha256"
+func hashName(typ string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(typ)), "-", "")
+}
@@ -0,0 +1,83 @@
+package pget
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMetalink(t *testing.T) {
+	const meta4 = `<?xml version="1.0" encoding="UTF-8"?>
+<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+  <file name="example.iso">
+    <size>20</size>
+    <hash type="md5">0123456789abcdef0123456789abcdef</hash>
+    <hash type="sha-256">ABCDEF0000000000000000000000000000000000000000000000000000000000</hash>
+    <hash type="sha3-256">ffff</hash>
+    <pieces length="16" type="sha-1">
+      <hash>0000000000000000000000000000000000000001</hash>
+      <hash>0000000000000000000000000000000000000002</hash>
+    </pieces>
+    <url location="de">http://de.example.com/example.iso</url>
+    <url location="us" priority="1">https://us.example.com/example.iso</url>
+    <url priority="2">ftp://ftp.example.com/example.iso</url>
+    <url priority="3">http://fr.example.com/example.iso</url>
+    <metaurl mediatype="torrent">http://example.com/example.iso.torrent</metaurl>
+  </file>
+</metalink>`
+	files, err := ParseMetalink(strings.NewReader(meta4))
+	require.NoError(t, err)
+	assert.Equal(t, []MetalinkFile{{
+		Name:     "example.iso",
+		Size:     20,
+		URLs:     []string{"https://us.example.com/example.iso", "http://fr.example.com/example.iso", "http://de.example.com/example.iso"},
+		Checksum: "sha256:abcdef0000000000000000000000000000000000000000000000000000000000",
+		Pieces: &PieceHashes{Algorithm: "sha1", Length: 16, Hashes: []string{
+			"0000000000000000000000000000000000000001",
+			"0000000000000000000000000000000000000002",
+		}},
+	}}, files)
+
+	const metalink3 = `<?xml version="1.0" encoding="UTF-8"?>
+<metalink version="3.0" xmlns="http://www.metalinker.org/">
+  <files>
+    <file name="a.tar.gz">
+      <size>5</size>
+      <verification>
+        <hash type="sha1">0000000000000000000000000000000000000003</hash>
+      </verification>
+      <resources>
+        <url type="http" preference="10">http://slow.example.com/a.tar.gz</url>
+        <url type="http" preference="100">http://fast.example.com/a.tar.gz</url>
+      </resources>
+    </file>
+    <file name="b.tar.gz">
+      <resources>
+        <url type="http">http://example.com/b.tar.gz</url>
+      </resources>
+    </file>
+  </files>
+</metalink>`
+	files, err = ParseMetalink(strings.NewReader(metalink3))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, []string{"http://fast.example.com/a.tar.gz", "http://slow.example.com/a.tar.gz"}, files[0].URLs)
+	assert.Equal(t, "sha1:0000000000000000000000000000000000000003", files[0].Checksum)
+	assert.Equal(t, int64(5), files[0].Size)
+	assert.Equal(t, "b.tar.gz", files[1].Name)
+	assert.Empty(t, files[1].Checksum)
+	assert.Nil(t, files[1].Pieces)
+
+	for _, bad := range []string{
+		`not xml`,
+		`<metalink xmlns="urn:ietf:params:xml:ns:metalink"></metalink>`,
+		`<metalink><file name="a"><url>ftp://example.com/a</url></file></metalink>`,
+		// 分块数与大小不一致
+		`<metalink><file name="a"><size>40</size><pieces length="16" type="sha-1"><hash>0000000000000000000000000000000000000001</hash></pieces><url>http://example.com/a</url></file></metalink>`,
+	} {
+		_, err := ParseMetalink(strings.NewReader(bad))
+		assert.Error(t, err, bad)
+	}
+}
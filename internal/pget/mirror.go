@@ -0,0 +1,174 @@
+package pget
+
+import (
+	"sync"
+)
+
+const (
+	// 连续失败达到该次数的镜像被视为不健康，只在没有其它可用镜像时才会被选中
+	maxMirrorFailures = 3
+	// 镜像吞吐的 EWMA 平滑系数
+	mirrorSpeedAlpha = 0.3
+	// 当前连接速度低于最快镜像的 1/slowMirrorRatio 时，认为该连接过慢
+	slowMirrorRatio = 4
+)
+
+// MirrorStat 是单个镜像在本次下载中的表现
+type MirrorStat struct {
+	URL      string `json:"url"`
+	Bytes    int64  `json:"bytes"`    // 已从该镜像下载的字节数
+	Speed    int64  `json:"speed"`    // 单连接平滑吞吐, bytes per second
+	Active   int    `json:"active"`   // 正在使用该镜像的连接数
+	Segments int    `json:"segments"` // 已在该镜像上完成的分段数
+	Errors   int    `json:"errors"`   // 累计失败（含过慢被换下）次数
+	Healthy  bool   `json:"healthy"`
+}
+
+type mirror struct {
+	url      string
+	host     string
+	bytes    int64
+	speed    float64
+	measured bool
+	active   int
+	segments int
+	errors   int
+	fails    int // 连续失败次数，成功后清零
+}
+
+func (m *mirror) healthy() bool {
+	return m.fails < maxMirrorFailures
+}
+
+// mirrorSet 按观测到的吞吐和错误对镜像打分，为每个分段的每次尝试挑选镜像
+type mirrorSet struct {
+	mu      sync.Mutex
+	mirrors []*mirror
+}
+
+func newMirrorSet(urls []string) *mirrorSet {
+	s := &mirrorSet{mirrors: make([]*mirror, 0, len(urls))}
+	for _, url := range urls {
+		s.mirrors = append(s.mirrors, &mirror{url: url, host: hostOf(url)})
+	}
+	return s
+}
+
+// pick 选出下一次尝试使用的镜像，并占用它的一个连接。
+// avoid 是上次失败的镜像，只要还有其它选择就不会再选它。
+// 尚未测速的镜像优先（按连接数最少），其余按 speed/(active+1) 选最大者，
+// 这样更快的镜像会承接更多分段。
+func (s *mirrorSet) pick(avoid *mirror) *mirror {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	candidates := make([]*mirror, 0, len(s.mirrors))
+	for _, m := range s.mirrors {
+		if m != avoid && m.healthy() {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, m := range s.mirrors {
+			if m.healthy() {
+				candidates = append(candidates, m)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		// 全部不健康：退而求其次，选连续失败最少的
+		best := s.mirrors[0]
+		for _, m := range s.mirrors[1:] {
+			if m.fails < best.fails {
+				best = m
+			}
+		}
+		best.active++
+		return best
+	}
+
+	var best *mirror
+	for _, m := range candidates {
+		if !m.measured && (best == nil || best.measured || m.active < best.active) {
+			best = m
+		}
+	}
+	if best == nil {
+		score := func(m *mirror) float64 { return m.speed / float64(m.active+1) }
+		for _, m := range candidates {
+			if best == nil || score(m) > score(best) {
+				best = m
+			}
+		}
+	}
+	best.active++
+	return best
+}
+
+// release 归还 pick 占用的连接，并记录本次尝试的结果
+func (s *mirrorSet) release(m *mirror, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.active--
+	if err == nil {
+		m.segments++
+		m.fails = 0
+		return
+	}
+	m.errors++
+	if err != errMirrorSlow {
+		m.fails++
+	}
+}
+
+// corrupt 记录 m 提供了校验不一致的数据，之后的分段尽量避开它
+func (s *mirrorSet) corrupt(m *mirror) {
+	s.mu.Lock()
+	m.errors++
+	m.fails++
+	s.mu.Unlock()
+}
+
+func (s *mirrorSet) addBytes(m *mirror, n int64) {
+	s.mu.Lock()
+	m.bytes += n
+	s.mu.Unlock()
+}
+
+// sample 记录一次单连接吞吐采样，并判断该连接相对最快的其它镜像是否过慢
+func (s *mirrorSet) sample(m *mirror, speed float64) (slow bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !m.measured {
+		m.speed = speed
+		m.measured = true
+	} else {
+		m.speed = mirrorSpeedAlpha*speed + (1-mirrorSpeedAlpha)*m.speed
+	}
+
+	for _, o := range s.mirrors {
+		if o != m && o.healthy() && o.measured && o.speed > speed*slowMirrorRatio {
+			return true
+		}
+	}
+	return false
+}
+
+// stats 返回各镜像的统计快照
+func (s *mirrorSet) stats() []MirrorStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make([]MirrorStat, 0, len(s.mirrors))
+	for _, m := range s.mirrors {
+		stats = append(stats, MirrorStat{
+			URL:      m.url,
+			Bytes:    m.bytes,
+			Speed:    int64(m.speed),
+			Active:   m.active,
+			Segments: m.segments,
+			Errors:   m.errors,
+			Healthy:  m.healthy(),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,90 @@
+package pget
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirrorFailover(t *testing.T) {
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Now(), bytes.NewReader(data))
+	}))
+	t.Cleanup(good.Close)
+
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "mirror is down", http.StatusBadGateway)
+	}))
+	t.Cleanup(bad.Close)
+
+	var progress Progress
+	tmpDir := t.TempDir()
+	err := Download(context.Background(), &DownloadConfig{
+		Filename:      "file.bin",
+		Dirname:       tmpDir,
+		ContentLength: int64(len(data)),
+		Procs:         4,
+		URLs:          []string{bad.URL, good.URL},
+		Client:        newDownloadClient(4),
+	}, WithProgressCallback(func(p Progress) { progress = p }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(tmpDir, "file.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data, got)
+
+	assert.Equal(t, int64(len(data)), progress.Downloaded)
+	for _, seg := range progress.Segments {
+		assert.Equal(t, SegmentDone, seg.State)
+		assert.Equal(t, good.URL, seg.Mirror)
+	}
+
+	stats := progress.Mirrors
+	if assert.Len(t, stats, 2) {
+		assert.Equal(t, bad.URL, stats[0].URL)
+		assert.NotZero(t, stats[0].Errors)
+		assert.Zero(t, stats[0].Bytes)
+		assert.Equal(t, int64(len(data)), stats[1].Bytes)
+		assert.Equal(t, 4, stats[1].Segments)
+	}
+}
+
+func TestMirrorSetPick(t *testing.T) {
+	s := newMirrorSet([]string{"a", "b"})
+
+	// 未测速的镜像轮流分配
+	first := s.pick(nil)
+	second := s.pick(nil)
+	assert.NotEqual(t, first.url, second.url)
+	s.release(first, nil)
+	s.release(second, nil)
+
+	// 测速后更快的镜像优先
+	s.sample(s.mirrors[0], 100)
+	assert.False(t, s.sample(s.mirrors[1], 1000))
+	assert.True(t, s.sample(s.mirrors[0], 100), "a is far slower than b")
+	assert.Equal(t, "b", s.pick(nil).url)
+
+	// 连续失败的镜像被摘除
+	for i := 0; i < maxMirrorFailures; i++ {
+		m := s.pick(nil)
+		s.release(m, errMirrorStalled)
+	}
+	assert.False(t, s.mirrors[1].healthy())
+	assert.Equal(t, "a", s.pick(s.mirrors[0]).url)
+}
@@ -0,0 +1,42 @@
+package pget
+
+import (
+	"net/url"
+	"time"
+)
+
+// Observer 接收下载过程中的事件，用于统计指标。方法会被多个连接并发调用。
+type Observer interface {
+	// Bytes 从 host 收到 n 字节
+	Bytes(host string, n int64)
+	// Response 收到 host 的响应
+	Response(host string, status int)
+	// Retry 分段在 host 上失败，将换镜像重试
+	Retry(host string, err error)
+	// Merged 分段合并完成
+	Merged(d time.Duration)
+}
+
+type nopObserver struct{}
+
+func (nopObserver) Bytes(string, int64)  {}
+func (nopObserver) Response(string, int) {}
+func (nopObserver) Retry(string, error)  {}
+func (nopObserver) Merged(time.Duration) {}
+
+// WithObserver 设置下载事件的观察者
+func WithObserver(o Observer) DownloadOption {
+	return func(c *DownloadConfig) {
+		if o != nil {
+			c.Observer = o
+		}
+	}
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -4,8 +4,8 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"net/http"
 	"os"
-	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
@@ -15,8 +15,6 @@ import (
 	"github.com/pkg/errors"
 )
 
-type ProgressFunc func(downloaded, total, speed int64)
-
 // Pget structs
 type Pget struct {
 	Trace  bool
@@ -29,8 +27,13 @@ type Pget struct {
 	timeout   int
 	useragent string
 	referer   string
+	header    http.Header
+	checksum  string
+	tls       []TLSConfig
 
 	ProgressFn ProgressFunc
+	Limiters   []*Limiter // 读取时依次经过的限速器，可在下载过程中调整速率
+	Observer   Observer   // 下载事件的观察者，用于统计指标
 }
 
 // New for pget package
@@ -42,59 +45,44 @@ func New() *Pget {
 	}
 }
 
-// Run execute methods in pget package
+// Run 解析命令行参数并下载，下载本身由 Client 完成
 func (pget *Pget) Run(ctx context.Context, version string, args []string) error {
 	if err := pget.Ready(version, args); err != nil {
 		return errTop(err)
 	}
 
-	// TODO(codehex): calc maxIdleConnsPerHost
-	client := newDownloadClientByProxy(16, pget.Proxy)
-
-	target, err := Check(ctx, &CheckConfig{
-		URLs:    pget.URLs,
-		Timeout: time.Duration(pget.timeout) * time.Second,
-		Client:  client,
-	})
-	if err != nil {
-		return err
-	}
-
-	filename := target.Filename
-
-	var dir string
-	if pget.Output != "" {
-		fi, err := os.Stat(pget.Output)
-		if err == nil && fi.IsDir() {
-			dir = pget.Output
-		} else {
-			dir, filename = filepath.Split(pget.Output)
-			if dir != "" {
-				if err := os.MkdirAll(dir, 0755); err != nil {
-					return errors.Wrapf(err, "failed to create diretory at %s", dir)
-				}
-			}
-		}
+	ua := pget.useragent
+	if ua == "" {
+		ua = "Pget/" + version
 	}
-
-	opts := []DownloadOption{
-		WithUserAgent(pget.useragent, version),
-		WithReferer(pget.referer),
-	}
-
-	// 如果 ProgressFn 被设置，就通过一个新 Option 传给 Download
+	var opts []DownloadOption
 	if pget.ProgressFn != nil {
 		opts = append(opts, WithProgressCallback(pget.ProgressFn))
 	}
+	if pget.Observer != nil {
+		opts = append(opts, WithObserver(pget.Observer))
+	}
+	client, err := NewClient(
+		WithProxy(pget.Proxy),
+		WithTLS(pget.tls),
+		WithTimeout(time.Duration(pget.timeout)*time.Second),
+		WithAgent(ua),
+		WithDownloadOptions(opts...),
+	)
+	if err != nil {
+		return err
+	}
 
-	return Download(ctx, &DownloadConfig{
-		Filename:      filename,
-		Dirname:       dir,
-		ContentLength: target.ContentLength,
-		Procs:         pget.Procs,
-		URLs:          target.URLs,
-		Client:        client,
-	}, opts...)
+	_, err = client.Download(ctx, &Request{
+		URLs:     pget.URLs,
+		Output:   pget.Output,
+		Procs:    max(pget.Procs/len(pget.URLs), 1), // Procs 是所有 URL 的连接数之和
+		Header:   pget.header,
+		Referer:  pget.referer,
+		Checksum: pget.checksum,
+		Limiters: pget.Limiters,
+	})
+	return err
 }
 
 const (
@@ -125,7 +113,7 @@ func (pget *Pget) Ready(version string, args []string) error {
 		return errors.Wrap(err, "failed to parse of url")
 	}
 
-	if opts.NumConnection > warningNumConnection && !prompter.YN(warningMessage, false) {
+	if opts.NumConnection > warningNumConnection && !opts.Yes && !prompter.YN(warningMessage, false) {
 		return makeIgnoreErr()
 	}
 
@@ -147,6 +135,34 @@ func (pget *Pget) Ready(version string, args []string) error {
 		pget.Proxy = opts.Proxy
 	}
 
+	if len(opts.Headers) > 0 {
+		header, err := parseHeaders(opts.Headers)
+		if err != nil {
+			return err
+		}
+		pget.header = header
+	}
+
+	if opts.Checksum != "" {
+		if _, err := parseChecksum(opts.Checksum); err != nil {
+			return err
+		}
+		pget.checksum = opts.Checksum
+	}
+
+	if len(opts.CACert) > 0 || opts.Cert != "" || opts.Key != "" || len(opts.Pins) > 0 || opts.Insecure {
+		pget.tls = []TLSConfig{{
+			CAFiles:            opts.CACert,
+			CertFile:           opts.Cert,
+			KeyFile:            opts.Key,
+			Pins:               opts.Pins,
+			InsecureSkipVerify: opts.Insecure,
+		}}
+		if err := ValidateTLS(pget.tls); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
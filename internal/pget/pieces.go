@@ -0,0 +1,309 @@
+package pget
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var errPieceMismatch = errors.New("piece checksum mismatch")
+
+// PieceHashes 是按固定长度切分的分块摘要，例如 Metalink 中的 pieces，最后一块可以较短
+type PieceHashes struct {
+	Algorithm string   `json:"algorithm"` // md5、sha1、sha256 或 sha512
+	Length    int64    `json:"length"`
+	Hashes    []string `json:"hashes"` // 十六进制
+}
+
+// Validate 检查算法、分块长度和摘要，size 大于 0 时还检查分块数是否与文件大小一致
+func (p *PieceHashes) Validate(size int64) error {
+	_, _, err := p.parse(size)
+	return err
+}
+
+func (p *PieceHashes) parse(size int64) (func() hash.Hash, [][]byte, error) {
+	newHash, ok := checksumAlgorithms[strings.ToLower(p.Algorithm)]
+	if !ok {
+		return nil, nil, errors.Errorf("unsupported piece hash algorithm %q", p.Algorithm)
+	}
+	if p.Length <= 0 {
+		return nil, nil, errors.New("piece length must be positive")
+	}
+	if size > 0 && int64(len(p.Hashes)) != (size+p.Length-1)/p.Length {
+		return nil, nil, errors.Errorf("%d piece hashes do not cover %d bytes in pieces of %d", len(p.Hashes), size, p.Length)
+	}
+	want := make([][]byte, len(p.Hashes))
+	for i, h := range p.Hashes {
+		b, err := hex.DecodeString(strings.TrimSpace(h))
+		if err != nil || len(b) != newHash().Size() {
+			return nil, nil, errors.Errorf("invalid hash of piece %d", i)
+		}
+		want[i] = b
+	}
+	return newHash, want, nil
+}
+
+// WithPieces 在分段完成时校验其中的分块，不一致的分块从其它镜像重新下载
+func WithPieces(p *PieceHashes) DownloadOption {
+	return func(c *DownloadConfig) {
+		c.pieces = p
+	}
+}
+
+// span 记录分段文件中从 offset 开始的数据来自哪个镜像
+type span struct {
+	offset int64
+	m      *mirror
+}
+
+// pieceVerifier 在分段完成后校验完全落在已完成分段中的分块，跨分段的分块等两边都完成后再校验
+type pieceVerifier struct {
+	mu       sync.Mutex
+	algo     string
+	newHash  func() hash.Hash
+	want     [][]byte
+	length   int64
+	size     int64
+	taskSize int64
+	procs    int
+	partPath func(i int) string
+	done     []bool   // 分段是否已完成
+	checked  []bool   // 分块是否已经（或正在）校验
+	spans    [][]span // 每个分段的数据来源
+
+	mirrors  *mirrorSet
+	client   *http.Client
+	pool     *ProxyPool
+	opt      *makeRequestOption
+	observer Observer
+}
+
+func newPieceVerifier(c *DownloadConfig, mirrors *mirrorSet, partialDir string, taskSize int64) (*pieceVerifier, error) {
+	newHash, want, err := c.pieces.parse(c.ContentLength)
+	if err != nil {
+		return nil, err
+	}
+	return &pieceVerifier{
+		algo:     strings.ToLower(c.pieces.Algorithm),
+		newHash:  newHash,
+		want:     want,
+		length:   c.pieces.Length,
+		size:     c.ContentLength,
+		taskSize: taskSize,
+		procs:    c.Procs,
+		partPath: func(i int) string { return getPartialFilePath(partialDir, c.Filename, c.Procs, i) },
+		done:     make([]bool, c.Procs),
+		checked:  make([]bool, len(want)),
+		spans:    make([][]span, c.Procs),
+		mirrors:  mirrors,
+		client:   newClient(c.Client),
+		pool:     c.Pool,
+		opt:      c.makeRequestOption,
+		observer: c.Observer,
+	}, nil
+}
+
+// part 返回 offset 所在的分段，以及该分段在文件中的起点
+func (v *pieceVerifier) part(offset int64) (int, int64) {
+	if v.taskSize == 0 {
+		return v.procs - 1, 0
+	}
+	i := int(offset / v.taskSize)
+	if i > v.procs-1 {
+		i = v.procs - 1
+	}
+	return i, int64(i) * v.taskSize
+}
+
+// pieceRange 返回分块在文件中的 [start, end)
+func (v *pieceVerifier) pieceRange(p int) (int64, int64) {
+	start := int64(p) * v.length
+	return start, min(start+v.length, v.size)
+}
+
+// record 记录分段 part 中从 offset 开始的数据来自 m，nil 时不做任何事
+func (v *pieceVerifier) record(part int, offset int64, m *mirror) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	v.spans[part] = append(v.spans[part], span{offset: offset, m: m})
+	v.mu.Unlock()
+}
+
+// segmentsDone 标记分段已完成，校验由此可以校验的分块，不一致的分块重新下载
+func (v *pieceVerifier) segmentsDone(ctx context.Context, parts ...int) error {
+	if v == nil {
+		return nil
+	}
+	v.mu.Lock()
+	for _, i := range parts {
+		v.done[i] = true
+	}
+	var ready []int
+	for p := range v.want {
+		if v.checked[p] {
+			continue
+		}
+		start, end := v.pieceRange(p)
+		first, _ := v.part(start)
+		last, _ := v.part(end - 1)
+		covered := true
+		for i := first; i <= last; i++ {
+			covered = covered && v.done[i]
+		}
+		if covered {
+			v.checked[p] = true
+			ready = append(ready, p)
+		}
+	}
+	v.mu.Unlock()
+
+	for _, p := range ready {
+		if err := v.verify(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verify 校验分块，不一致时换一个镜像重新下载，直到一致或尝试次数用尽
+func (v *pieceVerifier) verify(ctx context.Context, p int) error {
+	data, err := v.read(p)
+	if err != nil {
+		return err
+	}
+	if v.match(p, data) {
+		return nil
+	}
+	avoid := v.source(p)
+	log.Printf("piece %d failed %s verification, refetching", p, v.algo)
+	if avoid != nil {
+		v.mirrors.corrupt(avoid)
+	}
+	for attempt := 0; attempt < maxSegmentAttempts; attempt++ {
+		m := v.mirrors.pick(avoid)
+		data, err := v.fetch(ctx, m, p)
+		if err == nil && !v.match(p, data) {
+			err = errPieceMismatch
+		}
+		v.mirrors.release(m, err)
+		if err == nil {
+			return v.write(p, data)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("piece %d: %s failed: %v", p, m.url, err)
+		v.observer.Retry(m.host, err)
+		avoid = m
+	}
+	return fmt.Errorf("%w: piece %d still does not match after %d attempts", ErrChecksumMismatch, p, maxSegmentAttempts)
+}
+
+func (v *pieceVerifier) match(p int, data []byte) bool {
+	h := v.newHash()
+	h.Write(data)
+	return string(h.Sum(nil)) == string(v.want[p])
+}
+
+// source 返回提供分块起始数据的镜像，续传前下载的数据来源未知时为 nil
+func (v *pieceVerifier) source(p int) *mirror {
+	start, _ := v.pieceRange(p)
+	i, _ := v.part(start)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var m *mirror
+	for _, s := range v.spans[i] {
+		if s.offset <= start {
+			m = s.m
+		}
+	}
+	return m
+}
+
+// read 从分段文件中读出分块，分块可能跨越多个分段
+func (v *pieceVerifier) read(p int) ([]byte, error) {
+	start, end := v.pieceRange(p)
+	data := make([]byte, end-start)
+	err := v.each(start, end, func(f string, off, from, to int64) error {
+		fp, err := os.Open(f)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		_, err = fp.ReadAt(data[from:to], off)
+		return err
+	})
+	return data, errors.Wrapf(err, "failed to read piece %d", p)
+}
+
+// write 把重新下载的分块写回分段文件
+func (v *pieceVerifier) write(p int, data []byte) error {
+	start, end := v.pieceRange(p)
+	err := v.each(start, end, func(f string, off, from, to int64) error {
+		fp, err := os.OpenFile(f, os.O_WRONLY, 0666)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		_, err = fp.WriteAt(data[from:to], off)
+		return err
+	})
+	return errors.Wrapf(err, "failed to write piece %d", p)
+}
+
+// each 把文件中的 [start, end) 拆分到各个分段文件，fn 收到分段文件、文件内偏移和在分块中的 [from, to)
+func (v *pieceVerifier) each(start, end int64, fn func(f string, off, from, to int64) error) error {
+	for pos := start; pos < end; {
+		i, base := v.part(pos)
+		partEnd := end
+		if i < v.procs-1 {
+			partEnd = min(end, base+v.taskSize)
+		}
+		if err := fn(v.partPath(i), pos-base, pos-start, partEnd-start); err != nil {
+			return err
+		}
+		pos = partEnd
+	}
+	return nil
+}
+
+// fetch 从镜像 m 下载分块
+func (v *pieceVerifier) fetch(ctx context.Context, m *mirror, p int) ([]byte, error) {
+	start, end := v.pieceRange(p)
+	req, err := newRangeRequest(ctx, m.url, v.opt, Range{low: start, high: end - 1})
+	if err != nil {
+		return nil, err
+	}
+	client := v.client
+	if v.pool != nil {
+		px := v.pool.acquire()
+		defer v.pool.release(px)
+		client = px.client
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	v.observer.Response(m.host, resp.StatusCode)
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, errors.Errorf("unexpected status %q", resp.Status)
+	}
+	data := make([]byte, end-start)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, err
+	}
+	v.observer.Bytes(m.host, int64(len(data)))
+	return data, nil
+}
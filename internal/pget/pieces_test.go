@@ -0,0 +1,107 @@
+package pget
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadWithPieces(t *testing.T) {
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	// 分块长度与分段长度不对齐，部分分块跨越两个分段
+	const length = 24 * 1024
+	pieces := &PieceHashes{Algorithm: "sha1", Length: length}
+	for off := 0; off < len(data); off += length {
+		sum := sha1.Sum(data[off:min(off+length, len(data))])
+		pieces.Hashes = append(pieces.Hashes, hex.EncodeToString(sum[:]))
+	}
+
+	// 坏镜像每个分块的第一个字节都是错的
+	corrupted := bytes.Clone(data)
+	for off := 0; off < len(corrupted); off += length {
+		corrupted[off] ^= 0xff
+	}
+
+	var (
+		mu        sync.Mutex
+		refetched = map[string]int{}
+	)
+	serve := func(name string, content []byte) *httptest.Server {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var low, high int
+			if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &low, &high); err == nil && low%length == 0 && (high-low+1 == length || high == len(data)-1 && high-low+1 < length) {
+				mu.Lock()
+				refetched[name]++
+				mu.Unlock()
+			}
+			http.ServeContent(w, r, "file.bin", time.Now(), bytes.NewReader(content))
+		}))
+		t.Cleanup(ts.Close)
+		return ts
+	}
+	good := serve("good", data)
+	bad := serve("bad", corrupted)
+
+	var progress Progress
+	tmpDir := t.TempDir()
+	err := Download(context.Background(), &DownloadConfig{
+		Filename:      "file.bin",
+		Dirname:       tmpDir,
+		ContentLength: int64(len(data)),
+		Procs:         4,
+		URLs:          []string{bad.URL, good.URL},
+		Client:        newDownloadClient(4),
+	}, WithPieces(pieces), WithProgressCallback(func(p Progress) { progress = p }))
+	require.NoError(t, err)
+
+	got, err := os.ReadFile(filepath.Join(tmpDir, "file.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// 只从好镜像重新下载坏镜像提供的分块
+	require.Len(t, progress.Mirrors, 2)
+	assert.NotZero(t, progress.Mirrors[0].Bytes, "bad mirror served no segment")
+	assert.NotZero(t, progress.Mirrors[0].Errors)
+	mu.Lock()
+	assert.Zero(t, refetched["bad"])
+	assert.NotZero(t, refetched["good"])
+	assert.Less(t, refetched["good"], len(pieces.Hashes))
+	mu.Unlock()
+
+	// 所有镜像都给出错误数据时报告校验失败
+	err = Download(context.Background(), &DownloadConfig{
+		Filename:      "file.bin",
+		Dirname:       t.TempDir(),
+		ContentLength: int64(len(data)),
+		Procs:         2,
+		URLs:          []string{bad.URL},
+		Client:        newDownloadClient(2),
+	}, WithPieces(pieces))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	// 分块数与文件大小不一致
+	err = Download(context.Background(), &DownloadConfig{
+		Filename:      "file.bin",
+		Dirname:       t.TempDir(),
+		ContentLength: int64(len(data)) * 2,
+		Procs:         1,
+		URLs:          []string{good.URL},
+		Client:        newDownloadClient(1),
+	}, WithPieces(pieces))
+	assert.Error(t, err)
+}
@@ -0,0 +1,182 @@
+package pget
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// 进度快照的上报间隔
+	progressInterval = 500 * time.Millisecond
+	// 速度 EWMA 的时间常数，越大越平滑
+	speedTimeConstant = 3 * time.Second
+)
+
+// ProgressFunc 定期接收下载进度快照
+type ProgressFunc func(p Progress)
+
+type SegmentState string
+
+const (
+	SegmentPending  SegmentState = "pending"
+	SegmentActive   SegmentState = "active"
+	SegmentRetrying SegmentState = "retrying" // 上一次尝试失败，正在等待换镜像重试
+	SegmentDone     SegmentState = "done"
+	SegmentFailed   SegmentState = "failed"
+)
+
+// SegmentProgress 是单个分段的进度，Low/High 为分段在文件中的字节区间（含两端）
+type SegmentProgress struct {
+	ID         int          `json:"id"`
+	Low        int64        `json:"low"`
+	High       int64        `json:"high"`
+	Downloaded int64        `json:"downloaded"`
+	Speed      int64        `json:"speed"`
+	State      SegmentState `json:"state"`
+	Mirror     string       `json:"mirror,omitempty"`
+}
+
+// Progress 是一次下载的进度快照
+type Progress struct {
+	Path        string            `json:"path"` // 下载完成后的文件路径
+	Downloaded  int64             `json:"downloaded"`
+	Total       int64             `json:"total"`
+	Speed       int64             `json:"speed"`       // EWMA 平滑后的速度, bytes per second
+	ETA         int64             `json:"eta"`         // 预计剩余秒数，-1 表示未知
+	Connections int               `json:"connections"` // 正在传输的连接数
+	Segments    []SegmentProgress `json:"segments"`
+	Mirrors     []MirrorStat      `json:"mirrors,omitempty"`
+}
+
+// tracker 汇总所有分段的进度并计算平滑速度
+type tracker struct {
+	mu         sync.Mutex
+	path       string
+	total      int64
+	downloaded int64
+	segments   []SegmentProgress
+	mirrors    *mirrorSet
+
+	// 上一次快照时的状态，用于计算速度
+	sampled   bool
+	speed     float64
+	lastTime  time.Time
+	lastBytes int64
+	lastSegs  []int64
+}
+
+// newTracker 按分段布局初始化进度，未分配任务的分段视为续传前已完成
+func newTracker(procs int, taskSize, contentLength int64, tasks []*task, mirrors *mirrorSet) *tracker {
+	tr := &tracker{
+		total:    contentLength,
+		segments: make([]SegmentProgress, procs),
+		mirrors:  mirrors,
+		lastTime: time.Now(),
+		lastSegs: make([]int64, procs),
+	}
+	for i := 0; i < procs; i++ {
+		r := makeRange(i, procs, taskSize, contentLength)
+		size := r.high - r.low + 1
+		if i == procs-1 {
+			r.high = contentLength - 1
+			size = contentLength - r.low
+		}
+		tr.segments[i] = SegmentProgress{
+			ID:         i,
+			Low:        r.low,
+			High:       r.high,
+			Downloaded: size,
+			State:      SegmentDone,
+		}
+	}
+	for _, t := range tasks {
+		seg := &tr.segments[t.ID]
+		seg.Downloaded -= t.Size
+		seg.State = SegmentPending
+	}
+	for i, seg := range tr.segments {
+		tr.downloaded += seg.Downloaded
+		tr.lastSegs[i] = seg.Downloaded
+	}
+	tr.lastBytes = tr.downloaded
+	return tr
+}
+
+func (tr *tracker) add(id int, n int64) {
+	tr.mu.Lock()
+	tr.downloaded += n
+	tr.segments[id].Downloaded += n
+	tr.mu.Unlock()
+}
+
+func (tr *tracker) setState(id int, state SegmentState, mirror string) {
+	tr.mu.Lock()
+	tr.segments[id].State = state
+	if mirror != "" {
+		tr.segments[id].Mirror = mirror
+	}
+	tr.mu.Unlock()
+}
+
+// snapshot 生成当前进度并更新平滑速度
+func (tr *tracker) snapshot(now time.Time) Progress {
+	tr.mu.Lock()
+	elapsed := now.Sub(tr.lastTime).Seconds()
+	if elapsed > 0 {
+		inst := float64(tr.downloaded-tr.lastBytes) / elapsed
+		if !tr.sampled {
+			tr.speed = inst
+			tr.sampled = true
+		} else {
+			alpha := 1 - math.Exp(-elapsed/speedTimeConstant.Seconds())
+			tr.speed += alpha * (inst - tr.speed)
+		}
+	}
+
+	p := Progress{
+		Path:       tr.path,
+		Downloaded: tr.downloaded,
+		Total:      tr.total,
+		Speed:      int64(tr.speed),
+		Segments:   make([]SegmentProgress, len(tr.segments)),
+	}
+	for i, seg := range tr.segments {
+		if elapsed > 0 {
+			seg.Speed = int64(float64(seg.Downloaded-tr.lastSegs[i]) / elapsed)
+		}
+		if seg.State == SegmentActive {
+			p.Connections++
+		}
+		p.Segments[i] = seg
+		tr.lastSegs[i] = seg.Downloaded
+	}
+	tr.lastTime, tr.lastBytes = now, tr.downloaded
+	tr.mu.Unlock()
+
+	p.ETA = eta(p.Total-p.Downloaded, p.Speed)
+	p.Mirrors = tr.mirrors.stats()
+	return p
+}
+
+// final 生成下载结束时的快照，速度归零
+func (tr *tracker) final() Progress {
+	p := tr.snapshot(time.Now())
+	p.Speed = 0
+	p.ETA = eta(p.Total-p.Downloaded, 0)
+	for i := range p.Segments {
+		p.Segments[i].Speed = 0
+	}
+	return p
+}
+
+func eta(remaining, speed int64) int64 {
+	switch {
+	case remaining <= 0:
+		return 0
+	case speed <= 0:
+		return -1
+	default:
+		return (remaining + speed - 1) / speed
+	}
+}
@@ -0,0 +1,41 @@
+package pget
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackerSnapshot(t *testing.T) {
+	// 3 个分段，分段 0 已在上次运行中完成，分段 1 已下载 100 字节
+	tasks := []*task{
+		{ID: 1, Size: 233},
+		{ID: 2, Size: 334},
+	}
+	tr := newTracker(3, 333, 1000, tasks, newMirrorSet([]string{"a"}))
+
+	p := tr.snapshot(tr.lastTime)
+	assert.Equal(t, int64(433), p.Downloaded)
+	assert.Equal(t, int64(-1), p.ETA)
+	assert.Equal(t, []SegmentProgress{
+		{ID: 0, Low: 0, High: 332, Downloaded: 333, State: SegmentDone},
+		{ID: 1, Low: 333, High: 665, Downloaded: 100, State: SegmentPending},
+		{ID: 2, Low: 666, High: 999, Downloaded: 0, State: SegmentPending},
+	}, p.Segments)
+
+	tr.setState(1, SegmentActive, "a")
+	tr.add(1, 100)
+	p = tr.snapshot(tr.lastTime.Add(time.Second))
+	assert.Equal(t, int64(533), p.Downloaded)
+	assert.Equal(t, int64(100), p.Speed)
+	assert.Equal(t, int64(5), p.ETA)
+	assert.Equal(t, 1, p.Connections)
+	assert.Equal(t, int64(100), p.Segments[1].Speed)
+	assert.Equal(t, "a", p.Segments[1].Mirror)
+
+	// 之后的采样被平滑，而不是直接跳到瞬时速度
+	tr.add(1, 400)
+	p = tr.snapshot(tr.lastTime.Add(time.Second))
+	assert.True(t, p.Speed > 100 && p.Speed < 400, "smoothed speed %d", p.Speed)
+}
@@ -0,0 +1,199 @@
+package pget
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+// Direct 用在 ProxyRule.Proxy 中表示不经过代理
+const Direct = "direct"
+
+// ProxyRule 把目标域名匹配 Hosts 中任意一个通配符（如 "*.example.com"）的请求交给 Proxy
+type ProxyRule struct {
+	Hosts []string `json:"hosts" yaml:"hosts"`
+	Proxy string   `json:"proxy" yaml:"proxy"` // 代理地址，或 "direct"
+}
+
+// ParseProxy 解析代理地址，支持 http、https、socks5（本地解析域名）和 socks5h（由代理解析域名），
+// 可以带 user:pass。错误信息中不会包含密码。
+func ParseProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL %q", redactProxy(raw))
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, want http, https, socks5 or socks5h", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy URL %s has no host", u.Redacted())
+	}
+	if isSocks(u) && u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "1080")
+	}
+	return u, nil
+}
+
+// ValidateProxyRules 检查代理规则是否合法
+func ValidateProxyRules(rules []ProxyRule) error {
+	for i, r := range rules {
+		if len(r.Hosts) == 0 {
+			return fmt.Errorf("proxy rule %d: hosts is required", i+1)
+		}
+		for _, h := range r.Hosts {
+			if _, err := path.Match(h, ""); err != nil || h == "" {
+				return fmt.Errorf("proxy rule %d: invalid host pattern %q", i+1, h)
+			}
+		}
+		if r.Proxy == Direct {
+			continue
+		}
+		if _, err := ParseProxy(r.Proxy); err != nil {
+			return fmt.Errorf("proxy rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func isSocks(u *url.URL) bool {
+	return u.Scheme == "socks5" || u.Scheme == "socks5h"
+}
+
+// redactProxy 去掉无法解析的代理地址中可能存在的密码
+func redactProxy(raw string) string {
+	if i := strings.LastIndex(raw, "@"); i >= 0 {
+		if j := strings.Index(raw, "://"); j >= 0 && j < i {
+			return raw[:j+3] + "xxxxx" + raw[i:]
+		}
+		return "xxxxx" + raw[i:]
+	}
+	return raw
+}
+
+type proxyRoute struct {
+	hosts []string
+	proxy *url.URL // nil 表示直连
+}
+
+// proxyRouter 按目标域名选择代理。http(s) 代理交给 http.Transport.Proxy，
+// socks 代理在 DialContext 中处理。
+type proxyRouter struct {
+	def    *url.URL // 未命中规则时使用，为 nil 时使用环境变量中的代理
+	routes []proxyRoute
+	dialer *dialRateLimiter
+
+	// http(s) 代理自身的地址，transport 连接它们时直接拨号
+	httpProxies map[string]bool
+}
+
+func newProxyRouter(def string, rules []ProxyRule, dialer *dialRateLimiter) (*proxyRouter, error) {
+	p := &proxyRouter{dialer: dialer, httpProxies: make(map[string]bool)}
+	add := func(u *url.URL) {
+		if u != nil && !isSocks(u) {
+			p.httpProxies[canonicalAddr(u)] = true
+		}
+	}
+	if def != "" {
+		u, err := ParseProxy(def)
+		if err != nil {
+			return nil, err
+		}
+		p.def = u
+		add(u)
+	}
+	if err := ValidateProxyRules(rules); err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		route := proxyRoute{hosts: r.Hosts}
+		if r.Proxy != Direct {
+			route.proxy, _ = ParseProxy(r.Proxy)
+			add(route.proxy)
+		}
+		p.routes = append(p.routes, route)
+	}
+	return p, nil
+}
+
+// route 返回 host 应该使用的代理，nil 表示直连；ok 为 false 表示没有规则也没有默认代理
+func (p *proxyRouter) route(host string) (u *url.URL, ok bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, r := range p.routes {
+		for _, pattern := range r.hosts {
+			if matched, _ := path.Match(strings.ToLower(pattern), host); matched {
+				return r.proxy, true
+			}
+		}
+	}
+	if p.def != nil {
+		return p.def, true
+	}
+	return nil, false
+}
+
+// proxy 用作 http.Transport.Proxy
+func (p *proxyRouter) proxy(req *http.Request) (*url.URL, error) {
+	u, ok := p.route(req.URL.Hostname())
+	if !ok {
+		return http.ProxyFromEnvironment(req)
+	}
+	if u == nil || isSocks(u) {
+		return nil, nil
+	}
+	return u, nil
+}
+
+// DialContext 用作 http.Transport.DialContext，需要经过 socks 代理的连接在这里建立
+func (p *proxyRouter) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if p.httpProxies[addr] {
+		return p.dialer.DialContext(ctx, network, addr)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	u, _ := p.route(host)
+	if u == nil || !isSocks(u) {
+		return p.dialer.DialContext(ctx, network, addr)
+	}
+
+	if u.Scheme == "socks5" && net.ParseIP(host) == nil {
+		// socks5 在本地解析域名，socks5h 把域名交给代理解析
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		addr = net.JoinHostPort(ips[0].IP.String(), port)
+	}
+	var auth *proxy.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = &proxy.Auth{User: u.User.Username(), Password: password}
+	}
+	d, err := proxy.SOCKS5("tcp", u.Host, auth, p.dialer)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.(proxy.ContextDialer).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("socks proxy %s: %w", u.Redacted(), err)
+	}
+	return conn, nil
+}
+
+// canonicalAddr 返回带端口的代理地址
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
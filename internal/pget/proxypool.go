@@ -0,0 +1,238 @@
+package pget
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type PoolPolicy string
+
+const (
+	PoolRoundRobin  PoolPolicy = "round-robin"  // 依次使用每个代理
+	PoolLeastLoaded PoolPolicy = "least-loaded" // 使用当前连接数最少的代理
+)
+
+const (
+	// 连续失败达到该次数的代理被移出代理池
+	maxProxyFailures = 3
+	// 被移出的代理在这段时间之后重新加入，再失败一次会再次被移出
+	proxyEvictDuration = 30 * time.Second
+	// 统计代理吞吐的采样窗口
+	proxySpeedWindow = time.Second
+)
+
+// ProxyStat 是代理池中单个代理的统计
+type ProxyStat struct {
+	Proxy   string `json:"proxy"`  // 去掉密码的代理地址
+	Active  int    `json:"active"` // 正在使用该代理的连接数
+	Bytes   int64  `json:"bytes"`  // 经该代理下载的字节数
+	Speed   int64  `json:"speed"`  // 平滑后的总吞吐, bytes per second
+	Errors  int    `json:"errors"` // 累计的连接失败次数
+	Healthy bool   `json:"healthy"`
+}
+
+type poolProxy struct {
+	name   string // 去掉密码的地址
+	client *http.Client
+
+	active  int
+	bytes   int64
+	speed   float64
+	errors  int
+	fails   int       // 连续失败次数，成功后清零
+	evicted time.Time // 非零时表示被移出，直到 evicted+proxyEvictDuration
+
+	windowStart time.Time
+	windowBytes int64
+}
+
+// ProxyPool 把分段连接分散到多个代理上，可以被多个下载共享。
+// 每个代理有独立的连接池，不同代理之间不会复用连接。
+type ProxyPool struct {
+	mu      sync.Mutex
+	policy  PoolPolicy
+	proxies []*poolProxy
+	next    int
+	now     func() time.Time
+}
+
+// NewProxyPool 创建代理池，base.ProxyRules 命中的请求仍按规则处理（例如直连内网），
+// base.TLS 对所有代理生效，base.Proxy 被忽略
+func NewProxyPool(proxies []string, policy PoolPolicy, base TransportConfig) (*ProxyPool, error) {
+	if err := ValidatePoolPolicy(policy); err != nil {
+		return nil, err
+	}
+	if policy == "" {
+		policy = PoolRoundRobin
+	}
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("proxy pool is empty")
+	}
+	p := &ProxyPool{policy: policy, now: time.Now}
+	for _, raw := range proxies {
+		u, err := ParseProxy(raw)
+		if err != nil {
+			return nil, err
+		}
+		base.Proxy = raw
+		client, err := newTransportClient(16, base)
+		if err != nil {
+			return nil, err
+		}
+		p.proxies = append(p.proxies, &poolProxy{name: u.Redacted(), client: client})
+	}
+	return p, nil
+}
+
+// ValidatePoolPolicy 检查分配策略，空字符串表示默认的 round-robin
+func ValidatePoolPolicy(policy PoolPolicy) error {
+	switch policy {
+	case "", PoolRoundRobin, PoolLeastLoaded:
+		return nil
+	}
+	return fmt.Errorf("unknown proxy pool policy %q, want %s or %s", policy, PoolRoundRobin, PoolLeastLoaded)
+}
+
+// usable 判断代理是否在池中，被移出的代理到期后重新加入
+func (p *ProxyPool) usable(px *poolProxy, now time.Time) bool {
+	if px.evicted.IsZero() {
+		return true
+	}
+	if now.Sub(px.evicted) < proxyEvictDuration {
+		return false
+	}
+	log.Printf("proxy %s rejoins the pool\n", px.name)
+	px.evicted = time.Time{}
+	px.fails = maxProxyFailures - 1
+	return true
+}
+
+// acquire 按策略选出一个代理并占用它的一个连接。所有代理都被移出时选最早被移出的，
+// 而不是绕过代理直连。
+func (p *ProxyPool) acquire() *poolProxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+
+	var chosen *poolProxy
+	n := len(p.proxies)
+	for i := 0; i < n; i++ {
+		idx := (p.next + i) % n
+		px := p.proxies[idx]
+		if !p.usable(px, now) {
+			continue
+		}
+		if p.policy == PoolRoundRobin {
+			chosen, p.next = px, idx+1
+			break
+		}
+		if chosen == nil || px.active < chosen.active {
+			chosen = px
+		}
+	}
+	if p.policy == PoolLeastLoaded {
+		p.next++
+	}
+	if chosen == nil {
+		for _, px := range p.proxies {
+			if chosen == nil || px.evicted.Before(chosen.evicted) {
+				chosen = px
+			}
+		}
+	}
+	chosen.active++
+	return chosen
+}
+
+// release 归还 acquire 占用的连接
+func (p *ProxyPool) release(px *poolProxy) {
+	if p == nil || px == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	px.active--
+}
+
+// report 记录一次经由 px 的请求能否连通，连续失败的代理会被移出
+func (p *ProxyPool) report(px *poolProxy, err error) {
+	if p == nil || px == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		px.fails = 0
+		return
+	}
+	px.errors++
+	px.fails++
+	if px.fails >= maxProxyFailures && px.evicted.IsZero() {
+		px.evicted = p.now()
+		log.Printf("proxy %s evicted from the pool after %d failures: %v\n", px.name, px.fails, err)
+	}
+}
+
+// addBytes 记录经由 px 下载的字节并更新吞吐
+func (p *ProxyPool) addBytes(px *poolProxy, n int64) {
+	if p == nil || px == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	px.bytes += n
+	px.windowBytes += n
+	p.sample(px, p.now())
+}
+
+// sample 每个窗口结束时把窗口内的吞吐计入 EWMA
+func (p *ProxyPool) sample(px *poolProxy, now time.Time) {
+	if px.windowStart.IsZero() {
+		px.windowStart = now
+		return
+	}
+	elapsed := now.Sub(px.windowStart)
+	if elapsed < proxySpeedWindow {
+		return
+	}
+	rate := float64(px.windowBytes) / elapsed.Seconds()
+	px.speed = mirrorSpeedAlpha*rate + (1-mirrorSpeedAlpha)*px.speed
+	px.windowStart, px.windowBytes = now, 0
+}
+
+// Client 返回一个可用代理的 http.Client，用于探测等零散的请求
+func (p *ProxyPool) Client() *http.Client {
+	px := p.acquire()
+	p.release(px)
+	return px.client
+}
+
+// Stats 返回每个代理的统计
+func (p *ProxyPool) Stats() []ProxyStat {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	stats := make([]ProxyStat, len(p.proxies))
+	for i, px := range p.proxies {
+		p.sample(px, now)
+		stats[i] = ProxyStat{
+			Proxy:   px.name,
+			Active:  px.active,
+			Bytes:   px.bytes,
+			Speed:   int64(px.speed),
+			Errors:  px.errors,
+			Healthy: px.evicted.IsZero() || now.Sub(px.evicted) >= proxyEvictDuration,
+		}
+	}
+	return stats
+}
+
+// WithProxyPool 让每个分段连接从代理池中选择代理，探测请求也经过代理池
+func WithProxyPool(pool *ProxyPool) ClientOption {
+	return func(c *Client) {
+		c.pool = pool
+	}
+}
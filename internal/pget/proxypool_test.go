@@ -0,0 +1,125 @@
+package pget
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyPoolPolicy(t *testing.T) {
+	proxies := []string{"http://a:1", "http://b:2", "socks5://u:pw@c:3"}
+	_, err := NewProxyPool(proxies, "random", TransportConfig{})
+	assert.Error(t, err)
+	_, err = NewProxyPool(nil, PoolRoundRobin, TransportConfig{})
+	assert.Error(t, err)
+	_, err = NewProxyPool([]string{"ftp://x:21"}, "", TransportConfig{})
+	assert.Error(t, err)
+
+	// round-robin 依次使用每个代理
+	pool, err := NewProxyPool(proxies, "", TransportConfig{})
+	require.NoError(t, err)
+	var names []string
+	for i := 0; i < 4; i++ {
+		names = append(names, pool.acquire().name)
+	}
+	assert.Equal(t, []string{"http://a:1", "http://b:2", "socks5://u:xxxxx@c:3", "http://a:1"}, names)
+
+	// least-loaded 选择连接数最少的代理
+	pool, err = NewProxyPool(proxies, PoolLeastLoaded, TransportConfig{})
+	require.NoError(t, err)
+	a, b, c := pool.acquire(), pool.acquire(), pool.acquire()
+	assert.ElementsMatch(t, []string{"http://a:1", "http://b:2", "socks5://u:xxxxx@c:3"}, []string{a.name, b.name, c.name})
+	pool.release(b)
+	assert.Same(t, b, pool.acquire())
+}
+
+func TestProxyPoolEviction(t *testing.T) {
+	pool, err := NewProxyPool([]string{"http://a:1", "http://b:2"}, PoolRoundRobin, TransportConfig{})
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+	pool.now = func() time.Time { return now }
+
+	a := pool.proxies[0]
+	failed := errors.New("connection refused")
+	for i := 0; i < maxProxyFailures; i++ {
+		pool.report(a, failed)
+	}
+	for i := 0; i < 3; i++ {
+		px := pool.acquire()
+		pool.release(px)
+		assert.Equal(t, "http://b:2", px.name)
+	}
+	stats := pool.Stats()
+	assert.False(t, stats[0].Healthy)
+	assert.Equal(t, maxProxyFailures, stats[0].Errors)
+	assert.True(t, stats[1].Healthy)
+
+	// 全部被移出时仍然使用代理，选最早被移出的
+	now = now.Add(time.Second)
+	for i := 0; i < maxProxyFailures; i++ {
+		pool.report(pool.proxies[1], failed)
+	}
+	assert.Same(t, a, pool.acquire())
+	pool.release(a)
+
+	// 冷却后重新加入，再失败一次会再次被移出
+	now = now.Add(proxyEvictDuration)
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		seen[pool.acquire().name] = true
+	}
+	assert.True(t, seen["http://a:1"])
+	pool.report(a, failed)
+	assert.False(t, pool.Stats()[0].Healthy)
+}
+
+func TestProxyPoolDownload(t *testing.T) {
+	ts, data := fileServer(t)
+
+	var hits [2]atomic.Int64
+	forward := func(i int) *httptest.Server {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i].Add(1)
+			req, _ := http.NewRequest(r.Method, ts.URL+r.URL.Path, nil)
+			req.Header = r.Header.Clone()
+			res, err := http.DefaultTransport.RoundTrip(req)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer res.Body.Close()
+			for k, v := range res.Header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(res.StatusCode)
+			io.Copy(w, res.Body)
+		}))
+		t.Cleanup(s.Close)
+		return s
+	}
+	p0, p1 := forward(0), forward(1)
+
+	// 第三个代理不可用，失败的分段改用其它代理重试
+	pool, err := NewProxyPool([]string{p0.URL, p1.URL, "http://127.0.0.1:1"}, PoolRoundRobin, TransportConfig{})
+	require.NoError(t, err)
+	got, err := download(t, "http://files.test/data.bin", WithProxyPool(pool), WithProxy("socks5://127.0.0.1:1"))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	assert.NotZero(t, hits[0].Load())
+	assert.NotZero(t, hits[1].Load())
+	stats := pool.Stats()
+	assert.Equal(t, int64(len(data)), stats[0].Bytes+stats[1].Bytes)
+	assert.Zero(t, stats[2].Bytes)
+	assert.NotZero(t, stats[2].Errors)
+	for _, s := range stats {
+		assert.Zero(t, s.Active)
+	}
+}
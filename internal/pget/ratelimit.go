@@ -0,0 +1,137 @@
+package pget
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// 单次等待的上限，保证速率调整在一秒内生效
+const maxLimiterWait = 100 * time.Millisecond
+
+// Limiter 是一个可以在运行时调整速率的令牌桶，rate <= 0 表示不限速。
+// 同一个 Limiter 可以被多个连接、多个任务共享。
+type Limiter struct {
+	mu     sync.Mutex
+	rate   int64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter 创建一个速率为 rate bytes/s 的限速器
+func NewLimiter(rate int64) *Limiter {
+	return &Limiter{rate: rate, last: time.Now()}
+}
+
+// SetRate 修改速率，正在等待的读取会在 maxLimiterWait 内按新速率继续
+func (l *Limiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = rate
+	if rate <= 0 {
+		l.tokens = 0
+	}
+}
+
+// Rate 返回当前速率
+func (l *Limiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// refill 按经过的时间补充令牌，桶容量为一秒的流量
+func (l *Limiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+		if burst := float64(l.rate); l.tokens > burst {
+			l.tokens = burst
+		}
+	}
+	l.last = now
+}
+
+// WaitN 消费 n 个令牌，令牌不足时阻塞直到还清
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	l.refill(time.Now())
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	// 先记账再等待，允许单次读取超过桶容量
+	l.tokens -= float64(n)
+	l.mu.Unlock()
+
+	for {
+		l.mu.Lock()
+		l.refill(time.Now())
+		if l.rate <= 0 || l.tokens >= 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+		l.mu.Unlock()
+
+		if wait > maxLimiterWait {
+			wait = maxLimiterWait
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// throttled 报告是否有任一限速器在生效
+func throttled(limiters []*Limiter) bool {
+	for _, l := range limiters {
+		if l != nil && l.Rate() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// limitedReader 读取后依次向每个限速器申请令牌
+type limitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*Limiter
+	stall    *time.Timer // 等待令牌的时间不算作连接卡死
+}
+
+func newLimitedReader(ctx context.Context, r io.Reader, stall *time.Timer, limiters []*Limiter) io.Reader {
+	if len(limiters) == 0 {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, limiters: limiters, stall: stall}
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	// 单次读取不超过一秒的配额，避免低速时一次等待过久
+	for _, l := range lr.limiters {
+		if rate := l.Rate(); rate > 0 && int64(len(p)) > rate {
+			p = p[:rate]
+		}
+	}
+
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if lr.stall != nil {
+			lr.stall.Stop()
+			defer lr.stall.Reset(stallTimeout)
+		}
+		for _, l := range lr.limiters {
+			if werr := l.WaitN(lr.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+	return n, err
+}
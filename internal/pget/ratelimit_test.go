@@ -0,0 +1,50 @@
+package pget
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterWaitN(t *testing.T) {
+	l := NewLimiter(100 * 1024)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := l.WaitN(context.Background(), 10*1024); err != nil {
+			t.Fatal(err)
+		}
+	}
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 400*time.Millisecond, "50KiB at 100KiB/s took only %s", elapsed)
+	assert.True(t, elapsed < 2*time.Second, "50KiB at 100KiB/s took %s", elapsed)
+}
+
+func TestLimiterSetRate(t *testing.T) {
+	l := NewLimiter(1024)
+
+	done := make(chan error, 1)
+	go func() {
+		// 按 1KiB/s 需要等待约 10s
+		done <- l.WaitN(context.Background(), 10*1024)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	l.SetRate(0)
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("rate change did not take effect within a second")
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := NewLimiter(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, l.WaitN(ctx, 1024))
+}
@@ -1,7 +1,10 @@
 package pget
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
 	"mime"
 	"net/http"
 	"path"
@@ -27,13 +30,19 @@ type CheckConfig struct {
 	URLs    []string
 	Timeout time.Duration
 	Client  *http.Client
+	Header  http.Header // 附加到探测请求的请求头
+	Method  string      // 为空或 GET 时用 HEAD 探测，否则用该方法和 Body 请求第一个字节
+	Body    []byte
 }
 
 // Target represensts download target.
 type Target struct {
 	Filename      string
 	ContentLength int64
-	URLs          []string
+	URLs          []string // 依次为请求的地址和从 Link 头中发现的镜像
+	Discovered    []string // URLs 中从 Link 头中发现的镜像，请求它们时不带 Header
+	Checksum      string   // 主地址在响应头中给出的摘要，形如 "sha256:<hex>"，没有时为空
+	ChecksumFrom  string   // Checksum 来自哪个响应头，见 responseChecksum
 }
 
 // Check checks be able to download from targets
@@ -47,7 +56,7 @@ func Check(ctx context.Context, c *CheckConfig) (*Target, error) {
 
 	client := newClient(c.Client)
 
-	infos, err := getMirrorInfos(ctx, client, c.URLs)
+	infos, err := getMirrorInfos(ctx, client, c)
 	if err != nil {
 		return nil, err
 	}
@@ -56,9 +65,18 @@ func Check(ctx context.Context, c *CheckConfig) (*Target, error) {
 	if err != nil {
 		return nil, err
 	}
+	// 非 GET 请求的结果与请求体有关，其它地址未必等价
+	var discovered []string
+	if isGet(c.Method) {
+		for _, info := range discoverMirrors(ctx, client, c, infos) {
+			infos = append(infos, info)
+			discovered = append(discovered, info.RetrievedURL)
+		}
+	}
 	if filename == "" {
 		filename = path.Base(infos[0].RetrievedURL)
 	}
+	filename = safeFilename(filename)
 
 	urls := make([]string, len(infos))
 	for i, info := range infos {
@@ -69,25 +87,29 @@ func Check(ctx context.Context, c *CheckConfig) (*Target, error) {
 		Filename:      filename,
 		ContentLength: infos[0].ContentLength,
 		URLs:          urls,
+		Discovered:    discovered,
+		Checksum:      infos[0].Checksum,
+		ChecksumFrom:  infos[0].ChecksumFrom,
 	}, nil
 }
 
-func getMirrorInfos(ctx context.Context, client *http.Client, urls []string) ([]*mirrorInfo, error) {
+func getMirrorInfos(ctx context.Context, client *http.Client, c *CheckConfig) ([]*mirrorInfo, error) {
 	var mu sync.Mutex
 	eg, ctx := errgroup.WithContext(ctx)
 
-	infos := make([]*mirrorInfo, 0, len(urls))
+	// 按请求中的顺序保存，第一个为主地址
+	infos := make([]*mirrorInfo, len(c.URLs))
 
-	for _, url := range urls {
-		url := url
+	for i, url := range c.URLs {
+		i, url := i, url
 		eg.Go(func() error {
-			info, err := getMirrorInfo(ctx, client, url)
+			info, err := getMirrorInfo(ctx, client, url, c)
 			if err != nil {
 				return errors.Wrap(err, url)
 			}
 
 			mu.Lock()
-			infos = append(infos, info)
+			infos[i] = info
 			mu.Unlock()
 
 			return nil
@@ -105,19 +127,33 @@ type mirrorInfo struct {
 	RetrievedURL  string
 	ContentLength int64
 	Filename      string
+	Duplicates    []string // Link: rel=duplicate 给出的镜像
+	Checksum      string   // 响应头给出的摘要
+	ChecksumFrom  string
 }
 
-func getMirrorInfo(ctx context.Context, client *http.Client, url string) (*mirrorInfo, error) {
+func getMirrorInfo(ctx context.Context, client *http.Client, url string, c *CheckConfig) (*mirrorInfo, error) {
+	if !isGet(c.Method) {
+		return getMirrorInfoByRange(ctx, client, url, c)
+	}
 	req, err := http.NewRequest("HEAD", url, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to make head request")
 	}
 	req = req.WithContext(ctx)
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to head request")
 	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, errors.Errorf("unexpected status %q", resp.Status)
+	}
 
 	if resp.Header.Get("Accept-Ranges") != "bytes" {
 		return nil, errors.New("does not support range request")
@@ -126,29 +162,70 @@ func getMirrorInfo(ctx context.Context, client *http.Client, url string) (*mirro
 	if resp.ContentLength <= 0 {
 		return nil, errors.New("invalid content length")
 	}
+	return newMirrorInfo(resp, url, resp.ContentLength), nil
+}
 
+// getMirrorInfoByRange 用 c.Method 请求第一个字节，从 Content-Range 得到文件大小。
+// POST 等请求的结果与请求体有关，不能用 HEAD 探测。
+func getMirrorInfoByRange(ctx context.Context, client *http.Client, url string, c *CheckConfig) (*mirrorInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, c.Method, url, bytes.NewReader(c.Body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make probe request")
+	}
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to probe request")
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, errors.Errorf("unexpected status %q", resp.Status)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, errors.New("does not support range request")
+	}
+	var first, last, size int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &first, &last, &size); err != nil || size <= 0 {
+		return nil, errors.New("invalid content length")
+	}
+	return newMirrorInfo(resp, url, size), nil
+}
+
+// isGet 判断 method 是否为默认的 GET
+func isGet(method string) bool {
+	return method == "" || method == http.MethodGet
+}
+
+func newMirrorInfo(resp *http.Response, url string, contentLength int64) *mirrorInfo {
 	filename := ""
 	_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
 	if len(params) > 0 && params["filename"] != "" {
 		filename = params["filename"]
 	}
 
+	duplicates, checksum, from := advertised(resp)
+
 	// To perform with the correct "range access"
 	// get the last url in the redirect
 	_url := resp.Request.URL.String()
 	if isNotLastURL(_url, url) {
-		return &mirrorInfo{
-			RetrievedURL:  _url,
-			ContentLength: resp.ContentLength,
-			Filename:      filename,
-		}, nil
+		url = _url
 	}
 
 	return &mirrorInfo{
 		RetrievedURL:  url,
-		ContentLength: resp.ContentLength,
+		ContentLength: contentLength,
 		Filename:      filename,
-	}, nil
+		Duplicates:    duplicates,
+		Checksum:      checksum,
+		ChecksumFrom:  from,
+	}
 }
 
 // check contents are the same on each mirrors
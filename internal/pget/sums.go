@@ -0,0 +1,220 @@
+package pget
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// maxSumsSize 是校验文件的最大字节数
+const maxSumsSize = 1 << 20
+
+// sumsFile 是与下载文件放在一起的校验文件，{name} 替换为文件名。algo 为空时按摘要长度推断。
+type sumsFile struct {
+	name string
+	algo string
+}
+
+// sumsFiles 按优先级排列：更强的算法优先，同一算法中单个文件的校验文件优先
+var sumsFiles = []sumsFile{
+	{"{name}.sha512", "sha512"},
+	{"SHA512SUMS", "sha512"},
+	{"{name}.sha256", "sha256"},
+	{"{name}.sha256sum", "sha256"},
+	{"SHA256SUMS", "sha256"},
+	{"sha256sums.txt", "sha256"},
+	{"checksums.txt", ""},
+	{"CHECKSUMS", ""},
+	{"{name}.sha1", "sha1"},
+	{"SHA1SUMS", "sha1"},
+	{"{name}.md5", "md5"},
+	{"MD5SUMS", "md5"},
+}
+
+// hexAlgorithms 按十六进制摘要的长度推断算法
+var hexAlgorithms = map[int]string{32: "md5", 40: "sha1", 64: "sha256", 128: "sha512"}
+
+// sumEntry 是校验文件中的一行，name 为空表示只有摘要
+type sumEntry struct {
+	algo string
+	name string
+	sum  string
+}
+
+// bsdSum 匹配 BSD 风格的 "SHA256 (name) = hex"，也兼容 openssl 的 "SHA2-256(name)= hex"
+var bsdSum = regexp.MustCompile(`^([A-Za-z0-9-]+) ?\((.*)\) ?= ?([0-9A-Fa-f]+)$`)
+
+// parseSums 解析 GNU coreutils（"hex  name"、"hex *name"）和 BSD 风格的校验文件，
+// algo 为文件名隐含的算法，为空时按摘要长度推断，无法识别的行被忽略
+func parseSums(data []byte, algo string) []sumEntry {
+	var entries []sumEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if m := bsdSum.FindStringSubmatch(line); m != nil {
+			entries = appendSum(entries, bsdAlgorithm(m[1]), m[2], m[3])
+			continue
+		}
+		// GNU 格式中含有反斜杠或换行的文件名以 "\" 开头并被转义
+		escaped := strings.HasPrefix(line, `\`)
+		line = strings.TrimPrefix(line, `\`)
+		sum, name, _ := strings.Cut(line, " ")
+		name = strings.TrimPrefix(strings.TrimPrefix(name, " "), "*")
+		if escaped {
+			name = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(name)
+		}
+		a := algo
+		if a == "" {
+			a = hexAlgorithms[len(sum)]
+		}
+		entries = appendSum(entries, a, name, sum)
+	}
+	return entries
+}
+
+// bsdAlgorithm 把 "SHA256"、"SHA2-256"、"SHA-256"、"MD5" 等统一为 checksumAlgorithms 中的名字
+func bsdAlgorithm(tag string) string {
+	a := strings.ToLower(tag)
+	a = strings.Replace(a, "sha2-", "sha", 1)
+	return strings.ReplaceAll(a, "-", "")
+}
+
+// appendSum 校验算法和摘要长度，合法时追加
+func appendSum(entries []sumEntry, algo, name, sum string) []sumEntry {
+	newHash, ok := checksumAlgorithms[algo]
+	if !ok || len(sum) != hex.EncodedLen(newHash().Size()) {
+		return entries
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return entries
+	}
+	name = strings.TrimPrefix(strings.TrimSpace(name), "./")
+	return append(entries, sumEntry{algo: algo, name: name, sum: strings.ToLower(sum)})
+}
+
+// matchSum 在校验文件中查找 names 中任一文件名的摘要。单个文件的校验文件只有一行时，
+// 文件名不一致也采用，因为它通常记录的是发布者本地的路径。
+func matchSum(entries []sumEntry, single bool, names []string) (sumEntry, bool) {
+	for _, e := range entries {
+		for _, name := range names {
+			if name != "" && (e.name == name || path.Base(e.name) == name) {
+				return e, true
+			}
+		}
+	}
+	if single && len(entries) == 1 {
+		return entries[0], true
+	}
+	return sumEntry{}, false
+}
+
+// FindChecksum 在 c.URLs[0] 所在目录查找 <文件名>.sha256、SHA256SUMS 等校验文件，返回其中
+// 对应的最强摘要和校验文件的地址。文件名取自地址和 names，例如服务端在 Content-Disposition
+// 中给出的文件名。没有找到时返回空字符串，不算错误。
+func FindChecksum(ctx context.Context, c *CheckConfig, names ...string) (string, string, error) {
+	if len(c.URLs) == 0 {
+		return "", "", errors.New("URL is required at least one")
+	}
+	base, err := url.Parse(c.URLs[0])
+	if err != nil {
+		return "", "", err
+	}
+	if name := path.Base(base.Path); name != "/" && name != "." {
+		names = append([]string{name}, names...)
+	}
+	if len(names) == 0 {
+		return "", "", errors.New("cannot tell the file name from the URL")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	client := newClient(c.Client)
+
+	type candidate struct {
+		url    string
+		algo   string
+		single bool // 单个文件的校验文件
+	}
+	var (
+		candidates []candidate
+		seen       = map[string]bool{}
+	)
+	for _, f := range sumsFiles {
+		for _, name := range names {
+			u := *base
+			u.RawQuery, u.Fragment, u.RawPath = "", "", ""
+			u.Path = path.Join(path.Dir(base.Path), strings.ReplaceAll(f.name, "{name}", name))
+			if !seen[u.String()] {
+				seen[u.String()] = true
+				candidates = append(candidates, candidate{url: u.String(), algo: f.algo, single: strings.Contains(f.name, "{name}")})
+			}
+		}
+	}
+
+	// 并发请求所有候选，按优先级取第一个包含该文件的校验文件
+	found := make([]*sumEntry, len(candidates))
+	var wg sync.WaitGroup
+	for i, cand := range candidates {
+		wg.Add(1)
+		go func(i int, cand candidate) {
+			defer wg.Done()
+			data, err := fetchSums(ctx, client, cand.url, c.Header)
+			if err != nil {
+				return
+			}
+			if e, ok := matchSum(parseSums(data, cand.algo), cand.single, names); ok {
+				found[i] = &e
+			}
+		}(i, cand)
+	}
+	wg.Wait()
+
+	for i, e := range found {
+		if e != nil {
+			log.Printf("found %s checksum in %s", e.algo, candidates[i].url)
+			return e.algo + ":" + e.sum, candidates[i].url, nil
+		}
+	}
+	return "", "", nil
+}
+
+// fetchSums 下载校验文件，不存在或过大时报错
+func fetchSums(ctx context.Context, client *http.Client, u string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %q", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSumsSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSumsSize {
+		return nil, errors.New("checksum file is too large")
+	}
+	return data, nil
+}
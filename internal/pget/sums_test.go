@@ -0,0 +1,86 @@
+package pget
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSums(t *testing.T) {
+	sha := sha256.Sum256([]byte("a"))
+	md := md5.Sum([]byte("a"))
+	shaHex, mdHex := hex.EncodeToString(sha[:]), hex.EncodeToString(md[:])
+
+	data := fmt.Sprintf(`# generated by sha256sum
+%s  app-linux-amd64.tar.gz
+%s *./app-windows.zip
+\%s  dir\\name.bin
+not a checksum line
+%s  too-short.bin
+`, shaHex, shaHex, shaHex, shaHex[:10])
+	assert.Equal(t, []sumEntry{
+		{algo: "sha256", name: "app-linux-amd64.tar.gz", sum: shaHex},
+		{algo: "sha256", name: "app-windows.zip", sum: shaHex},
+		{algo: "sha256", name: `dir\name.bin`, sum: shaHex},
+	}, parseSums([]byte(data), "sha256"))
+
+	// BSD 和 openssl 的格式，算法写在每一行中
+	data = fmt.Sprintf("SHA256 (app.tar.gz) = %s\nMD5 (app.zip) = %s\nSHA2-256(app.iso)= %s\n", shaHex, mdHex, shaHex)
+	assert.Equal(t, []sumEntry{
+		{algo: "sha256", name: "app.tar.gz", sum: shaHex},
+		{algo: "md5", name: "app.zip", sum: mdHex},
+		{algo: "sha256", name: "app.iso", sum: shaHex},
+	}, parseSums([]byte(data), ""))
+
+	// 没有文件名的单个摘要，算法按长度推断
+	assert.Equal(t, []sumEntry{{algo: "md5", sum: mdHex}}, parseSums([]byte(mdHex+"\n"), ""))
+}
+
+func TestFindChecksum(t *testing.T) {
+	sha := sha256.Sum256([]byte("app"))
+	md := md5.Sum([]byte("app"))
+	files := map[string]string{
+		"/v1/SHA256SUMS":     fmt.Sprintf("%s  other.tar.gz\n%s  dist/app.tar.gz\n", hex.EncodeToString(make([]byte, 32)), hex.EncodeToString(sha[:])),
+		"/v1/app.tar.gz.md5": hex.EncodeToString(md[:]) + "  /home/builder/app.tar.gz\n",
+		"/v2/app.zip.md5":    hex.EncodeToString(md[:]),
+		"/v3/SHA256SUMS":     "<html>not found</html>",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	t.Cleanup(ts.Close)
+
+	find := func(u string, names ...string) (string, string) {
+		sum, from, err := FindChecksum(context.Background(), &CheckConfig{URLs: []string{u}, Timeout: 5 * time.Second}, names...)
+		require.NoError(t, err)
+		return sum, from
+	}
+
+	// SHA256SUMS 比 .md5 更强
+	sum, from := find(ts.URL + "/v1/app.tar.gz?token=x")
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sha[:]), sum)
+	assert.Equal(t, ts.URL+"/v1/SHA256SUMS", from)
+
+	// 地址中没有文件名时使用服务端给出的文件名
+	sum, from = find(ts.URL+"/v2/download?id=1", "app.zip")
+	assert.Equal(t, "md5:"+hex.EncodeToString(md[:]), sum)
+	assert.Equal(t, ts.URL+"/v2/app.zip.md5", from)
+
+	sum, from = find(ts.URL + "/v3/app.tar.gz")
+	assert.Empty(t, sum)
+	assert.Empty(t, from)
+}
@@ -0,0 +1,255 @@
+package pget
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// TLSConfig 是 TLS 设置。Hosts 为空的一条是全局设置，其余按顺序匹配目标域名，
+// 命中的设置中未填写的项继承全局设置，CA 与全局的合并。
+type TLSConfig struct {
+	Hosts      []string `json:"hosts,omitempty" yaml:"hosts,omitempty"`           // 通配符，如 "*.corp.example.com"
+	CAFiles    []string `json:"caFiles,omitempty" yaml:"caFiles,omitempty"`       // 在系统根证书之外信任的 CA（PEM）
+	CertFile   string   `json:"certFile,omitempty" yaml:"certFile,omitempty"`     // 客户端证书（PEM），用于 mTLS
+	KeyFile    string   `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`       // 客户端证书的私钥（PEM）
+	MinVersion string   `json:"minVersion,omitempty" yaml:"minVersion,omitempty"` // "1.0" 到 "1.3"，默认 1.2
+	// 服务端证书链中任意一个公钥必须匹配，形如 "sha256/<base64>"（与 curl --pinnedpubkey 相同）
+	Pins []string `json:"pins,omitempty" yaml:"pins,omitempty"`
+	// 跳过证书校验，只应用于实验环境，每次握手都会打印警告
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ValidateTLS 检查 TLS 设置，包括证书文件能否读取和解析
+func ValidateTLS(configs []TLSConfig) error {
+	_, err := newTLSRoutes(configs)
+	return err
+}
+
+type tlsRoute struct {
+	hosts  []string
+	config *tls.Config
+}
+
+// newTLSRoutes 把 TLS 设置转换成按域名匹配的 tls.Config，最后一条是全局设置
+func newTLSRoutes(configs []TLSConfig) ([]tlsRoute, error) {
+	var global TLSConfig
+	seen := false
+	for i, c := range configs {
+		if len(c.Hosts) > 0 {
+			continue
+		}
+		if seen {
+			return nil, fmt.Errorf("tls %d: only one entry may omit hosts", i+1)
+		}
+		global, seen = c, true
+	}
+
+	var routes []tlsRoute
+	for i, c := range configs {
+		if len(c.Hosts) == 0 {
+			continue
+		}
+		for _, h := range c.Hosts {
+			if _, err := path.Match(h, ""); err != nil || h == "" {
+				return nil, fmt.Errorf("tls %d: invalid host pattern %q", i+1, h)
+			}
+		}
+		conf, err := buildTLS(inheritTLS(c, global))
+		if err != nil {
+			return nil, fmt.Errorf("tls %d: %w", i+1, err)
+		}
+		routes = append(routes, tlsRoute{hosts: c.Hosts, config: conf})
+	}
+	if seen {
+		conf, err := buildTLS(global)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		routes = append(routes, tlsRoute{hosts: []string{"*"}, config: conf})
+	}
+	return routes, nil
+}
+
+// inheritTLS 用全局设置补全按域名的设置
+func inheritTLS(c, global TLSConfig) TLSConfig {
+	c.CAFiles = append(append([]string(nil), global.CAFiles...), c.CAFiles...)
+	if c.CertFile == "" && c.KeyFile == "" {
+		c.CertFile, c.KeyFile = global.CertFile, global.KeyFile
+	}
+	if c.MinVersion == "" {
+		c.MinVersion = global.MinVersion
+	}
+	if len(c.Pins) == 0 {
+		c.Pins = global.Pins
+	}
+	c.InsecureSkipVerify = c.InsecureSkipVerify || global.InsecureSkipVerify
+	return c
+}
+
+// buildTLS 创建 tls.Config
+func buildTLS(c TLSConfig) (*tls.Config, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.MinVersion != "" {
+		v, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown minVersion %q, want 1.0, 1.1, 1.2 or 1.3", c.MinVersion)
+		}
+		conf.MinVersion = v
+	}
+
+	if len(c.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range c.CAFiles {
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA file: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA file %s", file)
+			}
+		}
+		conf.RootCAs = pool
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, fmt.Errorf("certFile and keyFile must be set together")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	pins := make([][]byte, 0, len(c.Pins))
+	for _, p := range c.Pins {
+		pin, err := parsePin(p)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, pin)
+	}
+
+	conf.InsecureSkipVerify = c.InsecureSkipVerify
+	if c.InsecureSkipVerify || len(pins) > 0 {
+		conf.VerifyConnection = func(cs tls.ConnectionState) error {
+			if c.InsecureSkipVerify {
+				log.Printf("WARNING: accepting unverified TLS certificate %s\n", peerName(cs))
+			}
+			if len(pins) > 0 {
+				return checkPins(cs, pins)
+			}
+			return nil
+		}
+	}
+	return conf, nil
+}
+
+// parsePin 解析 "sha256/<base64>"，也接受 curl 的 "sha256//<base64>"。
+// base64 本身可能以 "/" 开头，按解码后的长度区分两种写法。
+func parsePin(p string) ([]byte, error) {
+	if b64, ok := strings.CutPrefix(p, "sha256/"); ok {
+		for _, s := range []string{b64, strings.TrimPrefix(b64, "/")} {
+			if pin, err := base64.StdEncoding.DecodeString(s); err == nil && len(pin) == sha256.Size {
+				return pin, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("invalid pin %q, want sha256/<base64 of the SPKI digest>", p)
+}
+
+// checkPins 要求证书链中至少一个公钥与 pins 匹配。校验证书时只看校验通过的链，
+// 跳过校验时看服务端发送的全部证书。
+func checkPins(cs tls.ConnectionState, pins [][]byte) error {
+	certs := cs.PeerCertificates
+	if len(cs.VerifiedChains) > 0 {
+		certs = nil
+		for _, chain := range cs.VerifiedChains {
+			certs = append(certs, chain...)
+		}
+	}
+	for _, cert := range certs {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if bytes.Equal(sum[:], pin) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("certificate %s does not match any pinned public key", peerName(cs))
+}
+
+// peerName 用于日志，连接 IP 地址时没有 ServerName，使用证书的主题
+func peerName(cs tls.ConnectionState) string {
+	name := cs.ServerName
+	if len(cs.PeerCertificates) > 0 {
+		name += " (" + cs.PeerCertificates[0].Subject.String() + ")"
+	}
+	return strings.TrimSpace(name)
+}
+
+// tlsTransport 按目标域名把 https 请求交给使用对应 TLS 设置的 Transport，
+// 各 Transport 共享代理和拨号设置，但连接池相互独立
+type tlsTransport struct {
+	def    *http.Transport
+	routes []tlsTransportRoute
+}
+
+type tlsTransportRoute struct {
+	hosts []string
+	tr    *http.Transport
+}
+
+func newTLSTransport(base *http.Transport, routes []tlsRoute) *tlsTransport {
+	t := &tlsTransport{def: base}
+	for _, r := range routes {
+		if r.config.InsecureSkipVerify {
+			log.Printf("WARNING: TLS certificate verification is DISABLED for %s\n", strings.Join(r.hosts, ", "))
+		}
+		tr := base.Clone()
+		tr.TLSClientConfig = r.config
+		t.routes = append(t.routes, tlsTransportRoute{hosts: r.hosts, tr: tr})
+	}
+	return t
+}
+
+func (t *tlsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "https" {
+		host := strings.ToLower(strings.TrimSuffix(req.URL.Hostname(), "."))
+		for _, r := range t.routes {
+			for _, pattern := range r.hosts {
+				if matched, _ := path.Match(strings.ToLower(pattern), host); matched {
+					return r.tr.RoundTrip(req)
+				}
+			}
+		}
+	}
+	return t.def.RoundTrip(req)
+}
+
+func (t *tlsTransport) CloseIdleConnections() {
+	t.def.CloseIdleConnections()
+	for _, r := range t.routes {
+		r.tr.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,174 @@
+package pget
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// issue 签发证书，parent 为 nil 时生成自签名的 CA
+func issue(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	c := &testCert{cert: cert, key: key, certFile: filepath.Join(dir, name+".pem"), keyFile: filepath.Join(dir, name+".key")}
+	require.NoError(t, os.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return c
+}
+
+func (c *testCert) pin() string {
+	sum := sha256.Sum256(c.cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// tlsServer 启动使用私有 CA 签发证书的服务器，clientCA 非 nil 时要求客户端证书
+func tlsServer(t *testing.T, server *testCert, clientCA *testCert) string {
+	data := bytes.Repeat([]byte("0123456789"), 10*1024)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data.bin", time.Now(), bytes.NewReader(data))
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{server.cert.Raw}, PrivateKey: server.key}},
+	}
+	if clientCA != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(clientCA.cert)
+		ts.TLS.ClientCAs = pool
+		ts.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts.URL + "/data.bin"
+}
+
+func TestValidateTLS(t *testing.T) {
+	ca := issue(t, "ca", nil, x509.ExtKeyUsageServerAuth)
+	assert.NoError(t, ValidateTLS([]TLSConfig{
+		{CAFiles: []string{ca.certFile}, MinVersion: "1.3"},
+		{Hosts: []string{"*.lab.test"}, InsecureSkipVerify: true, Pins: []string{ca.pin()}},
+	}))
+
+	for _, c := range [][]TLSConfig{
+		{{MinVersion: "1.4"}},
+		{{CertFile: ca.certFile}},
+		{{Pins: []string{"sha1/AAAA"}}},
+		{{Pins: []string{"sha256/AAAA"}}},
+		{{CAFiles: []string{filepath.Join(t.TempDir(), "missing.pem")}}},
+		{{CAFiles: []string{ca.keyFile}}},
+		{{MinVersion: "1.2"}, {MinVersion: "1.3"}},
+		{{Hosts: []string{"[a-"}}},
+	} {
+		assert.Error(t, ValidateTLS(c), "%+v", c)
+	}
+}
+
+func TestTLSPrivateCA(t *testing.T) {
+	ca := issue(t, "ca", nil, x509.ExtKeyUsageServerAuth)
+	server := issue(t, "server", ca, x509.ExtKeyUsageServerAuth)
+	client := issue(t, "client", ca, x509.ExtKeyUsageClientAuth)
+	mtls := tlsServer(t, server, ca)
+
+	// 系统根证书不信任私有 CA
+	_, err := download(t, mtls)
+	assert.Error(t, err)
+
+	// 信任 CA 但没有客户端证书
+	_, err = download(t, mtls, WithTLS([]TLSConfig{{CAFiles: []string{ca.certFile}}}))
+	assert.Error(t, err)
+
+	// 全局 CA 与按域名的客户端证书合并
+	got, err := download(t, mtls, WithTLS([]TLSConfig{
+		{CAFiles: []string{ca.certFile}},
+		{Hosts: []string{"127.0.0.1"}, CertFile: client.certFile, KeyFile: client.keyFile, MinVersion: "1.3"},
+	}))
+	require.NoError(t, err)
+	assert.Len(t, got, 100*1024)
+
+	// 域名不匹配时规则不生效
+	_, err = download(t, mtls, WithTLS([]TLSConfig{
+		{Hosts: []string{"*.corp.test"}, CAFiles: []string{ca.certFile}, CertFile: client.certFile, KeyFile: client.keyFile},
+	}))
+	assert.Error(t, err)
+}
+
+func TestTLSPinning(t *testing.T) {
+	ca := issue(t, "ca", nil, x509.ExtKeyUsageServerAuth)
+	server := issue(t, "server", ca, x509.ExtKeyUsageServerAuth)
+	other := issue(t, "other", nil, x509.ExtKeyUsageServerAuth)
+	url := tlsServer(t, server, nil)
+
+	// 固定叶子证书或 CA 的公钥都可以
+	for _, pin := range []string{server.pin(), ca.pin()} {
+		_, err := download(t, url, WithTLS([]TLSConfig{{CAFiles: []string{ca.certFile}, Pins: []string{pin}}}))
+		assert.NoError(t, err)
+	}
+	_, err := download(t, url, WithTLS([]TLSConfig{{CAFiles: []string{ca.certFile}, Pins: []string{other.pin()}}}))
+	assert.ErrorContains(t, err, "pinned")
+
+	// 跳过校验时仍然检查固定的公钥
+	_, err = download(t, url, WithTLS([]TLSConfig{{InsecureSkipVerify: true}}))
+	assert.NoError(t, err)
+	_, err = download(t, url, WithTLS([]TLSConfig{{InsecureSkipVerify: true, Pins: []string{other.pin()}}}))
+	assert.Error(t, err)
+}
+
+func TestParsePin(t *testing.T) {
+	sum := sha256.Sum256([]byte("x"))
+	sum[0] = 0xfc // base64 以 "/" 开头
+	b64 := base64.StdEncoding.EncodeToString(sum[:])
+	require.Equal(t, byte('/'), b64[0])
+	for _, p := range []string{"sha256/" + b64, "sha256//" + b64} {
+		pin, err := parsePin(p)
+		require.NoError(t, err, p)
+		assert.Equal(t, sum[:], pin)
+	}
+}
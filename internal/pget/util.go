@@ -2,10 +2,25 @@ package pget
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// safeFilename 去掉服务端给出的文件名中的目录部分，避免写到下载目录之外
+func safeFilename(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		return "download"
+	}
+	return name
+}
+
 func getPartialDirname(targetDir, filename string, procs int) string {
 	if targetDir == "" {
 		return fmt.Sprintf("_%s.%d", filename, procs)
@@ -55,3 +70,17 @@ func makeRange(i, procs int, rangeSize, contentLength int64) Range {
 func (r Range) BytesRange() string {
 	return fmt.Sprintf("bytes=%d-%d", r.low, r.high)
 }
+
+// parseHeaders 解析 "Name: value" 形式的请求头
+func parseHeaders(lines []string) (http.Header, error) {
+	header := make(http.Header)
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header %q, want \"Name: value\"", line)
+		}
+		header.Add(name, strings.TrimSpace(value))
+	}
+	return header, nil
+}
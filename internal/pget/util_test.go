@@ -0,0 +1,22 @@
+package pget
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSafeFilename(t *testing.T) {
+	cases := map[string]string{
+		"file.zip":              "file.zip",
+		"../../.bashrc":         ".bashrc",
+		`..\..\evil.exe`:        "evil.exe",
+		"/etc/passwd":           "passwd",
+		"..":                    "download",
+		"dir/":                  "download",
+		"  name with space.txt": "name with space.txt",
+	}
+	for in, want := range cases {
+		assert.Equal(t, want, safeFilename(in), in)
+	}
+}
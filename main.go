@@ -2,103 +2,132 @@ package main
 
 import (
 	"context"
-	_ "embed"
 	"errors"
-	"github.com/getlantern/systray"
+	"go-download/internal/cli"
+	"go-download/internal/core/auth"
+	"go-download/internal/core/config"
 	"go-download/internal/core/route"
 	"go-download/internal/core/service"
 	"go-download/internal/core/sse"
 	"log"
+	"net"
 	"net/http"
-	"runtime"
+	"os"
+	"path/filepath"
 	"time"
 )
 
-// 把生成的 icon.icns 放到 resources 中并编译进二进制
-//
-//go:embed build/resources/icon.icns
-var darwinIcon []byte
-
-//go:embed build/resources/icon.ico
-var windowsIcon []byte
-
 type App struct {
 	server *http.Server
 	hub    *sse.Hub
 	svc    *service.DownloadService
-}
+	store  *config.Store
+	guard  *auth.Guard
 
-func NewApp() *App {
-	hub := sse.NewHub()
-	svc := service.NewDownloadService(hub)
-	return &App{
-		hub: hub,
-		svc: svc,
-	}
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-func (app *App) onReady() {
-	// 设置图标与提示
-	if runtime.GOOS == "windows" {
-		systray.SetIcon(windowsIcon)
-	} else if runtime.GOOS == "darwin" {
-		systray.SetIcon(darwinIcon)
+// NewApp 创建服务，configPath 为空时使用用户配置目录下的 config.yaml，
+// 令牌、任务状态和上传的 cookie 保存在配置文件所在的目录
+func NewApp(configPath string) *App {
+	if configPath == "" {
+		p, err := config.DefaultPath()
+		if err != nil {
+			log.Fatalf("failed to locate config dir: %v", err)
+		}
+		configPath = p
 	}
+	dir := filepath.Dir(configPath)
 
-	systray.SetTooltip("Go Download (运行中)")
-
-	// 菜单项（第一个只是状态不可点击也可以响应）
-	mStatus := systray.AddMenuItem("正在运行", "应用当前状态：正在运行")
-	_ = mStatus // 如果不需要交互可忽略
-
-	// 分隔线
-	systray.AddSeparator()
-
-	// 退出菜单
-	mQuit := systray.AddMenuItem("退出", "退出应用")
-
-	// 启动后端
-	go app.startBackend()
+	hub := sse.NewHub()
+	opts := []service.Option{
+		service.WithStateFile(filepath.Join(dir, "tasks.json")),
+		service.WithCookieFile(filepath.Join(dir, "cookies.txt")),
+		service.WithKeyring(filepath.Join(dir, "keys")),
+	}
+	store, err := config.Load(configPath)
+	if err != nil {
+		// 配置文件不可用时以默认配置运行，不影响下载
+		log.Println("load config failed, using defaults:", err)
+	} else {
+		opts = append(opts, service.WithConfig(store))
+	}
+	svc := service.NewDownloadService(hub, opts...)
 
-	// 监听菜单事件
-	go func() {
-		for {
-			select {
-			case <-mQuit.ClickedCh:
-				systray.Quit()
-				return
-			}
-		}
-	}()
-}
+	token, err := auth.LoadToken(filepath.Join(dir, "token"))
+	if err != nil {
+		log.Fatalf("failed to load api token: %v", err)
+	}
+	guard := auth.NewGuard(token, func() []string {
+		return svc.Settings().AllowedOrigins
+	}, func() []string {
+		return svc.Settings().ExtensionIDs
+	}, svc.TrustOrigin)
 
-func (app *App) onExit() {
-	// 优雅关闭 http server
-	if app.server != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := app.server.Shutdown(ctx); err != nil {
-			log.Printf("server shutdown error: %v\n", err)
-		} else {
-			log.Println("server stopped gracefully")
-		}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &App{
+		server: &http.Server{
+			Addr:    svc.Settings().Listen,
+			Handler: route.SetupRouter(hub, svc, guard),
+		},
+		hub:    hub,
+		svc:    svc,
+		store:  store,
+		guard:  guard,
+		ctx:    ctx,
+		cancel: cancel,
 	}
 }
 
 func (app *App) startBackend() {
-	r := route.SetupRouter(app.hub, app.svc)
-	app.server = &http.Server{
-		Addr:    ":11235",
-		Handler: r,
+	// 时间窗口调度与限速计划
+	go app.svc.RunScheduler(app.ctx)
+	// 配置文件热加载
+	if app.store != nil {
+		go app.store.Watch(app.ctx)
 	}
 
+	if host, _, err := net.SplitHostPort(app.server.Addr); err == nil {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			log.Printf("warning: listening on %s exposes the api beyond this machine\n", app.server.Addr)
+		}
+	}
 	log.Printf("starting go-download server on %s...\n", app.server.Addr)
 	if err := app.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("failed to run server: %v", err)
 	}
 }
 
+// shutdown 停止接收请求，中断下载并保存任务状态
+func (app *App) shutdown() {
+	app.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// 优雅关闭 http server
+	if err := app.server.Shutdown(ctx); err != nil {
+		log.Printf("server shutdown error: %v\n", err)
+	} else {
+		log.Println("server stopped gracefully")
+	}
+	if err := app.svc.Shutdown(ctx); err != nil {
+		log.Printf("save tasks failed: %v\n", err)
+	} else {
+		log.Println("tasks saved")
+	}
+}
+
 func main() {
-	app := NewApp()
-	systray.Run(app.onReady, app.onExit)
+	if len(os.Args) > 1 {
+		switch {
+		case os.Args[1] == "serve":
+			runServe(os.Args[2:])
+			return
+		case cli.Commands[os.Args[1]]:
+			// 命令行客户端，通过 HTTP API 控制正在运行的守护进程
+			os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+		}
+	}
+	runTray()
 }
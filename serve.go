@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runServe 以无界面的守护进程方式运行，收到 SIGINT/SIGTERM 时保存任务后退出
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	logFile := fs.String("log-file", "", "write logs to this file instead of stdout")
+	configPath := fs.String("config", "", "path of config.yaml (default: the user config dir)")
+	_ = fs.Parse(args)
+
+	var out io.Writer = os.Stdout
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("failed to open log file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	log.SetOutput(out)
+	gin.DefaultWriter = out
+	gin.DefaultErrorWriter = out
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	app := NewApp(*configPath)
+	go app.startBackend()
+
+	<-ctx.Done()
+	log.Println("received signal, shutting down")
+	app.shutdown()
+}
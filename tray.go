@@ -0,0 +1,64 @@
+//go:build !headless
+
+package main
+
+import (
+	_ "embed"
+	"github.com/getlantern/systray"
+	"github.com/sqweek/dialog"
+	"runtime"
+)
+
+// 把生成的 icon.icns 放到 resources 中并编译进二进制
+//
+//go:embed build/resources/icon.icns
+var darwinIcon []byte
+
+//go:embed build/resources/icon.ico
+var windowsIcon []byte
+
+// runTray 以系统托盘应用的方式运行
+func runTray() {
+	app := NewApp("")
+	// 配对码通过对话框展示给用户
+	app.guard.OnPairCode = func(origin, code string) {
+		go dialog.Message("扩展 %s 请求配对，配对码：%s\n如果不是你发起的请求，请忽略。", origin, code).
+			Title("Go Download 配对").Info()
+	}
+	systray.Run(app.onReady, app.shutdown)
+}
+
+func (app *App) onReady() {
+	// 设置图标与提示
+	if runtime.GOOS == "windows" {
+		systray.SetIcon(windowsIcon)
+	} else if runtime.GOOS == "darwin" {
+		systray.SetIcon(darwinIcon)
+	}
+
+	systray.SetTooltip("Go Download (运行中)")
+
+	// 菜单项（第一个只是状态不可点击也可以响应）
+	mStatus := systray.AddMenuItem("正在运行", "应用当前状态：正在运行")
+	_ = mStatus // 如果不需要交互可忽略
+
+	// 分隔线
+	systray.AddSeparator()
+
+	// 退出菜单
+	mQuit := systray.AddMenuItem("退出", "退出应用")
+
+	// 启动后端
+	go app.startBackend()
+
+	// 监听菜单事件
+	go func() {
+		for {
+			select {
+			case <-mQuit.ClickedCh:
+				systray.Quit()
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,11 @@
+//go:build headless
+
+package main
+
+import "log"
+
+// runTray 在不带界面的构建中退化为 serve 模式
+func runTray() {
+	log.Println("built without GUI support, running in serve mode")
+	runServe(nil)
+}